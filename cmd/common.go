@@ -3,32 +3,44 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"strings"
 
 	"github.com/gofrs/uuid/v5"
+	"github.com/jonmol/http-skeleton/cmd/config"
+	"github.com/jonmol/http-skeleton/cmd/serve"
 	"github.com/jonmol/http-skeleton/util/logging"
 	"github.com/spf13/viper"
 )
 
 const (
-	FlagCfgDump  = "cfg-dump"
-	FlagCfgWrite = "cfg-save"
+	FlagCfgDump   = "cfg-dump"
+	FlagCfgWrite  = "cfg-save"
+	FlagLogRedact = config.FlagLogRedact
+	FlagLogSinks  = "log-sinks"
+
+	FlagLogDedupWindow = "log-dedup-window"
+	FlagLogDedupBurst  = "log-dedup-burst"
+	FlagLogDedupKeys   = "log-dedup-keys"
+	FlagLogSampleDebug = "log-sample-debug"
+	FlagLogSampleInfo  = "log-sample-info"
 )
 
 var serviceID = uuid.Must(uuid.NewV4())
 
 func handleGlobalFlags() {
-	exit := false
+	done := false
 
 	if viper.GetBool(FlagCfgDump) {
-		j, err := json.MarshalIndent(viper.AllSettings(), " ", " ")
+		settings := serve.Settings()
+		j, err := json.MarshalIndent(settings, " ", " ")
 		if err != nil {
-			slog.Error("Failed to marshal into json:", slog.Any("settings", viper.AllSettings()))
+			slog.Error("Failed to marshal into json:", slog.Any("settings", settings))
 		}
 		fmt.Println(string(j))
-		exit = true
+		done = true
 	}
 
 	if viper.GetBool(FlagCfgWrite) {
@@ -37,48 +49,101 @@ func handleGlobalFlags() {
 		err := viper.WriteConfig()
 		if err != nil {
 			slog.Error("Failed to write config", logging.Err(err))
-			os.Exit(1)
+			exit(1)
 		}
 		slog.Info("Wrote config to", slog.String("cfgFile", viper.ConfigFileUsed()))
-		exit = true
+		done = true
 	}
 
-	if exit {
-		os.Exit(0)
+	if done {
+		exit(0)
 	}
 }
 
+// logClosers close the log sinks, see exit
+var logClosers []io.Closer
+
+// closeLogSinks flushes and closes the log files and syslog connections, logging goes to stderr afterwards
+func closeLogSinks() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	for _, c := range logClosers {
+		if err := c.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to close log sink:", err)
+		}
+	}
+	logClosers = nil
+}
+
+// exit closes the log sinks and exits, use it instead of os.Exit
+func exit(code int) {
+	closeLogSinks()
+	os.Exit(code)
+}
+
 // setupLogger creates a logger based on the configuration
 func setupLogger(cfg BaseConfig) {
 	if err := validate.Struct(cfg); err != nil {
 		panic(err)
 	}
 
-	// set log level, INFO is default
-	logLvl := &slog.LevelVar{}
+	// set log level, INFO is default. It's kept in logging.Levels so that it can be changed while running
+	logLvl := slog.LevelInfo
 
 	switch strings.ToLower(cfg.LogMinLevel) {
 	case "debug":
-		logLvl.Set(slog.LevelDebug)
+		logLvl = slog.LevelDebug
 	case "warn":
-		logLvl.Set(slog.LevelWarn)
+		logLvl = slog.LevelWarn
 	case "error":
-		logLvl.Set(slog.LevelError)
+		logLvl = slog.LevelError
 	}
+	logging.Levels().Configure(logLvl)
 
-	out := os.Stdout
-	if cfg.LogTarget == "stderr" {
-		out = os.Stderr
+	// without sinks log-target and log-format make up the only one
+	specs := cfg.LogSinks
+	if len(specs) == 0 {
+		specs = []string{cfg.LogTarget}
+		if cfg.LogTarget == "" {
+			specs = []string{logging.SinkStdout}
+		}
 	}
-
-	// pick the output format, store in the context and make it the default logger
-	var logger *slog.Logger
+	format := logging.FormatJSON
 	if cfg.LogOutputFormat == "text" {
-		logger = slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: logLvl, AddSource: true}))
-	} else {
-		logger = slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: logLvl, AddSource: true}))
+		format = logging.FormatText
+	}
+
+	// open the outputs and fan out to them
+	sinks := make([]logging.Sink, 0, len(specs))
+	closers := make([]io.Closer, 0, len(specs))
+	for _, spec := range specs {
+		sc, err := logging.ParseSink(spec, format)
+		if err != nil {
+			panic(err)
+		}
+		sink, closer, err := sc.Open()
+		if err != nil {
+			panic(fmt.Errorf("failed to open log sink %q: %w", spec, err))
+		}
+		sinks = append(sinks, sink)
+		closers = append(closers, closer)
 	}
+
+	var handler slog.Handler = logging.NewFanout(sinks...)
+	// keep a flapping dependency from flooding the outputs
+	handler = logging.NewSampleHandler(handler, logging.SampleConfig{
+		Window:    cfg.LogDedupWindow,
+		Burst:     cfg.LogDedupBurst,
+		Keys:      cfg.LogDedupKeys,
+		DebugRate: cfg.LogSampleDebug,
+		InfoRate:  cfg.LogSampleInfo,
+	})
+	handler = logging.Levels().Handler(handler)
+	// mask passwords, tokens etc before they reach the output
+	logger := slog.New(logging.NewRedactHandler(handler, cfg.LogRedact))
 	logger = logger.With(slog.String("serviceUID", serviceID.String()), slog.Int("pid", os.Getpid()))
+	// the sinks of an earlier setup are replaced
+	closeLogSinks()
+	logClosers = closers
 	slog.SetDefault(logger)
 	logger.Debug("Logger setup")
 }
@@ -1,6 +1,20 @@
 package config
 
-import "time"
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonmol/http-skeleton/util/logging"
+)
+
+// FlagLogRedact is the global flag with the log keys to redact, here so that sub commands can read it
+const FlagLogRedact = "log-redact"
+
+var (
+	secretsMut sync.RWMutex
+	secrets    = map[string]bool{}
+)
 
 type IntConf struct {
 	Name string
@@ -14,9 +28,10 @@ type DurationConf struct {
 }
 
 type StringConf struct {
-	Name string
-	Desc string
-	Def  string
+	Name   string
+	Desc   string
+	Def    string
+	Secret bool // masked when the configuration is dumped or logged
 }
 
 type BoolConf struct {
@@ -43,3 +58,47 @@ type Configs struct {
 	Bools        []BoolConf
 	StringArrays []StringArrayConf
 }
+
+// Secrets returns the names of the entries marked as secret
+func (c Configs) Secrets() []string {
+	res := make([]string, 0)
+	for _, s := range c.Strings {
+		if s.Secret {
+			res = append(res, s.Name)
+		}
+	}
+	return res
+}
+
+// RegisterSecrets marks the keys as secret, making Redact mask them
+func RegisterSecrets(keys ...string) {
+	secretsMut.Lock()
+	defer secretsMut.Unlock()
+	for _, k := range keys {
+		secrets[strings.ToLower(k)] = true
+	}
+}
+
+// Redact returns a copy of settings, typically viper.AllSettings(), with the values of all registered secret
+// keys masked. Nested keys are matched with their full dotted path
+func Redact(settings map[string]any) map[string]any {
+	secretsMut.RLock()
+	defer secretsMut.RUnlock()
+	return redact(settings, "")
+}
+
+func redact(settings map[string]any, prefix string) map[string]any {
+	res := make(map[string]any, len(settings))
+	for k, v := range settings {
+		full := prefix + strings.ToLower(k)
+		if secrets[full] {
+			if v != nil && v != "" {
+				v = logging.RedactedValue
+			}
+		} else if sub, ok := v.(map[string]any); ok {
+			v = redact(sub, full+".")
+		}
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/jonmol/http-skeleton/cmd/serve"
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	flagMigrateTo     = "to"
+	flagMigrateDryRun = "dry-run"
+)
+
+// dbCmd is the parent of the database maintenance commands
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Database maintenance",
+	Long: `Maintenance of the database configured with the db-* flags. The configuration is read the same way
+as for serve, so use the same config file or environment variables.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// the db flags are bound here and not in init, binding them in init would take them from serve
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to bind flags:", err)
+			exit(1)
+		}
+		// the commands are short lived, the GC would only slow them down. It's set as serve binds it with a default
+		viper.Set(serve.FieldDBBadgerGCInterval, 0)
+		handleGlobalFlags()
+	},
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Schema migrations of the database",
+	Long: `The database has a schema version, serve migrates to the latest on start. These commands show the
+version and migrate up or down by hand, for instance to undo a migration before rolling back a release.
+Only one instance migrates at a time, the others wait until it's done.`,
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Runs the migrations up to --to, default the latest",
+	Run: func(cmd *cobra.Command, args []string) {
+		runMigrate(cmd, false)
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Undoes the migrations down to --to, default the last one",
+	Run: func(cmd *cobra.Command, args []string) {
+		runMigrate(cmd, true)
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows the schema version and the migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, db, done := openDB()
+		defer done()
+		s, err := db.MigrationStatus(ctx)
+		if err != nil {
+			fail(done, "Failed to read the schema version:", err)
+		}
+		fmt.Printf("%s at schema version %d of %d\n", db.Driver(), s.Current, s.Latest)
+		for _, m := range s.Migrations {
+			state := "pending"
+			if m.Applied {
+				state = "applied"
+			}
+			fmt.Printf("  %3d %-8s %s\n", m.Version, state, m.Name)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(migrateCmd)
+	migrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migrateStatusCmd)
+
+	for _, flag := range serve.ConfigStructure.Strings {
+		if isDBFlag(flag.Name) {
+			dbCmd.PersistentFlags().String(flag.Name, flag.Def, flag.Desc)
+		}
+	}
+	for _, flag := range serve.ConfigStructure.Ints {
+		if isDBFlag(flag.Name) {
+			dbCmd.PersistentFlags().Int(flag.Name, flag.Def, flag.Desc)
+		}
+	}
+	for _, flag := range serve.ConfigStructure.Bools {
+		if isDBFlag(flag.Name) {
+			dbCmd.PersistentFlags().Bool(flag.Name, flag.Def, flag.Desc)
+		}
+	}
+	for _, flag := range serve.ConfigStructure.Durations {
+		if isDBFlag(flag.Name) {
+			dbCmd.PersistentFlags().Duration(flag.Name, flag.Def, flag.Desc)
+		}
+	}
+	migrateUpCmd.Flags().Int(flagMigrateTo, model.LatestVersion, "Schema version to migrate to, -1 for the latest")
+	migrateDownCmd.Flags().Int(flagMigrateTo, 0, "Schema version to migrate down to, default one below the current")
+	for _, c := range []*cobra.Command{migrateUpCmd, migrateDownCmd} {
+		c.Flags().Bool(flagMigrateDryRun, false, "Print the migrations that would run without running them")
+	}
+}
+
+// isDBFlag is true for the flags of how the database is opened, the secondary database and what serve does in the
+// background are left out. The service name is the default key prefix
+func isDBFlag(name string) bool {
+	switch name {
+	case serve.FieldDBSwitchOver, serve.FieldDBVerifyInterval, serve.FieldDBBadgerGCInterval:
+		return false
+	case serve.FieldServiceName:
+		return true
+	}
+	return strings.HasPrefix(name, "db-") && !strings.HasPrefix(name, "db-secondary-")
+}
+
+// runMigrate migrates up or down to --to and prints the steps
+func runMigrate(cmd *cobra.Command, down bool) {
+	dryRun, _ := cmd.Flags().GetBool(flagMigrateDryRun)
+	to, _ := cmd.Flags().GetInt(flagMigrateTo)
+	ctx, db, done := openDB()
+	defer done()
+
+	s, err := db.MigrationStatus(ctx)
+	if err != nil {
+		fail(done, "Failed to read the schema version:", err)
+	}
+	if down && !cmd.Flags().Changed(flagMigrateTo) {
+		to = max(s.Current-1, 0)
+	}
+	if !down && to != model.LatestVersion && to < s.Current {
+		fail(done, fmt.Sprintf("Version %d is below the current %d, use down", to, s.Current), nil)
+	} else if down && to > s.Current {
+		fail(done, fmt.Sprintf("Version %d is above the current %d, use up", to, s.Current), nil)
+	}
+
+	steps, err := db.Migrate(ctx, to, dryRun)
+	prefix := ""
+	if dryRun {
+		prefix = "would run "
+	}
+	for _, step := range steps {
+		fmt.Println(prefix + step.String())
+	}
+	if err != nil {
+		fail(done, "Migration failed:", err)
+	}
+	if len(steps) == 0 {
+		fmt.Println("Nothing to migrate, at schema version", s.Current)
+	}
+}
+
+// openDB opens the database from the db-* flags, done closes it. The context is canceled on SIGINT and SIGTERM
+func openDB() (context.Context, *model.DB, func()) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	db := model.NewModel(ctx)
+	if err := db.Open(ctx, viper.GetString(serve.FieldDBType), serve.DecodeConfig); err != nil {
+		stop()
+		fmt.Fprintf(os.Stderr, "Failed to open %s at %s: %v\n", viper.GetString(serve.FieldDBType), serve.RedactAddr(viper.GetString(serve.FieldDBAddr)), err)
+		exit(1)
+	}
+	return ctx, db, func() {
+		if err := db.Close(context.Background()); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to close the database:", err)
+		}
+		stop()
+	}
+}
+
+// fail prints msg and err, closes the database and exits
+func fail(done func(), msg string, err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, msg, err)
+	} else {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	done()
+	exit(1)
+}
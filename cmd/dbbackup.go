@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/jonmol/http-skeleton/cmd/serve"
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagBackupFormat = "format"
+	flagBackupOut    = "out"
+	flagBackupSince  = "since"
+	flagBackupFrom   = "from"
+
+	formatNative = "native"
+	formatJSONL  = "jsonl"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Backs up the database, natively or as JSON lines",
+	Long: `Backs up the database configured with the db-* flags, or with --from the database of a running
+instance through its telemetry server. A badger database can't be opened while it's served, so use --from
+for that.
+
+--format native is the backup format of the database, only badger has one. It can be incremental, the
+version to pass as --since for the next backup is printed when done. --format jsonl is one counter per
+line, it works for every database and can be restored into another kind than it came from.
+
+  http-skeleton db backup --out full.bak
+  http-skeleton db backup --out incr.bak --since 42
+  http-skeleton db backup --from http://localhost:9090 --format jsonl --out counters.jsonl`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString(flagBackupFormat)
+		out, _ := cmd.Flags().GetString(flagBackupOut)
+		since, _ := cmd.Flags().GetUint64(flagBackupSince)
+		from, _ := cmd.Flags().GetString(flagBackupFrom)
+		if format != formatNative && format != formatJSONL {
+			fail(func() {}, "Unknown format "+format, nil)
+		} else if format == formatJSONL && since != 0 {
+			fail(func() {}, "Only native backups can be incremental", nil)
+		}
+
+		w, commit, err := createOut(out)
+		if err != nil {
+			fail(func() {}, "Failed to create the backup:", err)
+		}
+		var next uint64
+		if from != "" {
+			next, err = backupFrom(cmd.Context(), w, from, format, since)
+		} else {
+			next, err = backupDB(w, format, since)
+		}
+		if err = errors.Join(err, commit(err)); err != nil {
+			fail(func() {}, "Backup failed:", err)
+		}
+		if format == formatNative {
+			fmt.Fprintf(os.Stderr, "Backup done, use --since %d for the next incremental backup\n", next)
+		}
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <file>...",
+	Short: "Restores backups into the database",
+	Long: `Restores the files in order into the database configured with the db-* flags, - is stdin. A full native
+backup followed by its incremental ones, or JSON lines from any kind of database. Stop the service first,
+restoring natively while serving isn't safe and badger can't be opened anyway.
+
+A native restore should go into an empty database. JSON lines replace the counters they have and keep the
+others, the database is migrated first so the counters end up where this version expects them.
+
+  http-skeleton db restore full.bak incr.bak
+  http-skeleton db restore --format jsonl --db-type redis --db-addr localhost:6379 counters.jsonl`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString(flagBackupFormat)
+		if format != formatNative && format != formatJSONL {
+			fail(func() {}, "Unknown format "+format, nil)
+		}
+		ctx, db, done := openDB()
+		defer done()
+		if format == formatJSONL {
+			if err := db.EnsureDB(ctx); err != nil {
+				fail(done, "Failed to set up the database:", err)
+			}
+		}
+		for _, path := range args {
+			if err := restoreFile(ctx, db, path, format); err != nil {
+				fail(done, "Failed to restore "+path+":", err)
+			}
+		}
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(backupCmd, restoreCmd)
+	for _, c := range []*cobra.Command{backupCmd, restoreCmd} {
+		c.Flags().String(flagBackupFormat, formatNative, "native for the backup format of the database, jsonl for one counter per line")
+	}
+	backupCmd.Flags().String(flagBackupOut, "-", "File to write the backup to, - is stdout")
+	backupCmd.Flags().Uint64(flagBackupSince, 0, "Only back up what changed after this version, printed by the previous backup")
+	backupCmd.Flags().String(flagBackupFrom, "", "Telemetry server of a running instance to back up instead of opening the database, like http://localhost:9090")
+}
+
+// backupDB backs up the database configured with the db-* flags
+func backupDB(w io.Writer, format string, since uint64) (uint64, error) {
+	ctx, db, done := openDB()
+	defer done()
+	if format == formatJSONL {
+		n, err := db.Export(ctx, w)
+		fmt.Fprintln(os.Stderr, "Exported", n, "counters")
+		return 0, err
+	}
+	return db.Backup(ctx, w, since)
+}
+
+// backupFrom backs up a running instance through /db/backup or /db/export of its telemetry server
+func backupFrom(ctx context.Context, w io.Writer, from, format string, since uint64) (uint64, error) {
+	u, err := url.JoinPath(from, "db", "backup")
+	if format == formatJSONL {
+		u, err = url.JoinPath(from, "db", "export")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if format == formatNative {
+		u += "?since=" + strconv.FormatUint(since, 10)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return 0, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return 0, fmt.Errorf("%s answered %s: %s", u, res.Status, msg)
+	}
+	// an aborted response, the backup failed half way, is an unexpected EOF here
+	if _, err := io.Copy(w, res.Body); err != nil {
+		return 0, err
+	}
+	if format == formatJSONL {
+		return 0, nil
+	}
+	return strconv.ParseUint(res.Trailer.Get(serve.BackupSinceHeader), 10, 64)
+}
+
+func restoreFile(ctx context.Context, db *model.DB, path, format string) error {
+	r := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(filepath.Clean(path))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if format == formatJSONL {
+		n, err := db.Import(ctx, r)
+		fmt.Fprintln(os.Stderr, "Imported", n, "counters from", path)
+		return err
+	}
+	return db.Restore(ctx, r)
+}
+
+// createOut returns the writer of the backup. A file is written next to path and renamed when commit is called
+// without an error, that way a failed backup doesn't replace a good one
+func createOut(path string) (io.Writer, func(error) error, error) {
+	if path == "-" {
+		return os.Stdout, func(error) error { return nil }, nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func(failed error) error {
+		err := f.Close()
+		if failed == nil && err == nil {
+			return os.Rename(f.Name(), path)
+		}
+		_ = os.Remove(f.Name())
+		return err
+	}, nil
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jonmol/http-skeleton/cmd/serve"
+	"github.com/jonmol/http-skeleton/model/badger"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	flagNewKeyFile = "new-key-file"
+	flagNewKeyEnv  = "new-key-env"
+)
+
+var rotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key",
+	Short: "Replaces the encryption key of a badger database",
+	Long: `Replaces the encryption key of the badger database at --db-addr. The current key is read from
+--db-badger-encryption-key-file or --db-badger-encryption-key-env, the new one from --new-key-file or
+--new-key-env. Only the data keys badger encrypts with are encrypted again, so it's quick whatever the size.
+A database without encryption is encrypted from now on. The service must be stopped, and started with the new
+key afterwards.
+
+  head -c 32 /dev/urandom > new.key
+  http-skeleton db rotate-key --db-badger-encryption-key-file old.key --new-key-file new.key`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if t := viper.GetString(serve.FieldDBType); t != "badger" {
+			fail(func() {}, "Only badger databases are encrypted, not "+t, nil)
+		}
+		file, _ := cmd.Flags().GetString(flagNewKeyFile)
+		env, _ := cmd.Flags().GetString(flagNewKeyEnv)
+		newKey, err := badger.LoadKey(file, env)
+		if err != nil {
+			fail(func() {}, "Failed to read the new key:", err)
+		} else if newKey == nil {
+			fail(func() {}, fmt.Sprintf("The new key is needed, use --%s or --%s", flagNewKeyFile, flagNewKeyEnv), nil)
+		}
+		var conf badger.Config
+		if err := serve.DecodeConfig(&conf); err != nil {
+			fail(func() {}, "Failed to read the badger config:", err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := badger.RotateKey(ctx, conf, newKey); err != nil {
+			fail(stop, "Failed to rotate the key:", err)
+		}
+		fmt.Println("Rotated the encryption key of", conf.Path+", start the service with the new key")
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(rotateKeyCmd)
+	rotateKeyCmd.Flags().String(flagNewKeyFile, "", "File with the new key, 16, 24 or 32 bytes")
+	rotateKeyCmd.Flags().String(flagNewKeyEnv, "", "Environment variable with the new key, instead of a file")
+}
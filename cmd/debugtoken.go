@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jonmol/http-skeleton/cmd/serve"
+	"github.com/jonmol/http-skeleton/server/middleware"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const flagDebugTTL = "ttl"
+
+// debugTokenCmd creates tokens for the debug header
+var debugTokenCmd = &cobra.Command{
+	Use:   "debug-token",
+	Short: "Creates a token for turning on debug logging for single requests",
+	Long: `Turning on debug logging for the whole service is often too noisy in production. If the service
+is started with --mid-debug-header and --mid-debug-secret, a request with the header set to a token
+created by this command is logged at debug level all the way through, regardless of --log-lvl.
+
+The secret is read the same way as for serve, so use the same config file or environment variables:
+
+  MID_DEBUG_SECRET=... http-skeleton debug-token --ttl 15m
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		secret := viper.GetString(serve.FieldMiddlewareDebugSecret)
+		if secret == "" {
+			fmt.Fprintln(os.Stderr, "No debug secret configured, set", serve.FieldMiddlewareDebugSecret)
+			exit(1)
+		}
+		ttl, _ := cmd.Flags().GetDuration(flagDebugTTL)
+		fmt.Println(middleware.SignDebugToken([]byte(secret), time.Now().Add(ttl)))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(debugTokenCmd)
+	debugTokenCmd.Flags().Duration(flagDebugTTL, time.Hour, "How long the token is valid")
+}
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/jonmol/http-skeleton/util/logging"
@@ -35,9 +36,17 @@ var (
 )
 
 type BaseConfig struct {
-	LogOutputFormat string `mapstructure:"log-format" validate:"omitempty,oneof=text json"`
-	LogMinLevel     string `mapstructure:"log-lvl" validate:"omitempty,oneof=debug info warn error"`
-	LogTarget       string `mapstructure:"log-handle" validate:"omitempty,oneof=stdio stderr"`
+	LogOutputFormat string   `mapstructure:"log-format" validate:"omitempty,oneof=text json"`
+	LogMinLevel     string   `mapstructure:"log-lvl" validate:"omitempty,oneof=debug info warn error"`
+	LogTarget       string   `mapstructure:"log-target" validate:"omitempty,oneof=stdout stderr"`
+	LogSinks        []string `mapstructure:"log-sinks"`
+	LogRedact       []string `mapstructure:"log-redact"`
+
+	LogDedupWindow time.Duration `mapstructure:"log-dedup-window" validate:"gte=0"`
+	LogDedupBurst  int           `mapstructure:"log-dedup-burst" validate:"gte=0"`
+	LogDedupKeys   []string      `mapstructure:"log-dedup-keys"`
+	LogSampleDebug float64       `mapstructure:"log-sample-debug" validate:"gt=0,lte=1"`
+	LogSampleInfo  float64       `mapstructure:"log-sample-info" validate:"gt=0,lte=1"`
 }
 
 // rootCmd represents the base command when called without any subcommands
@@ -63,6 +72,13 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&base.LogMinLevel, "log-lvl", "info", "Minimum log level to display: debug|info|warn|error")
 	rootCmd.PersistentFlags().StringVar(&base.LogOutputFormat, "log-format", "text", "Output logs in text or json")
 	rootCmd.PersistentFlags().StringVar(&base.LogTarget, "log-target", "stdout", "Output logs to stdout or stderr")
+	rootCmd.PersistentFlags().StringArray(FlagLogSinks, nil, "Log outputs, replaces log-target. <stdout|stderr|file:path|syslog[:socket]>[,level=..][,format=..][,file and syslog options], see the README")
+	rootCmd.PersistentFlags().StringSlice(FlagLogRedact, logging.DefaultRedactKeys, "Log attributes with keys containing any of these are masked")
+	rootCmd.PersistentFlags().Duration(FlagLogDedupWindow, 10*time.Second, "Identical log records (level, message and log-dedup-keys) are only logged log-dedup-burst times in this window, 0 turns it off")
+	rootCmd.PersistentFlags().Int(FlagLogDedupBurst, 5, "Identical log records logged per log-dedup-window before the rest are suppressed")
+	rootCmd.PersistentFlags().StringSlice(FlagLogDedupKeys, logging.DefaultDedupKeys, "Log attributes that together with level and message make records identical")
+	rootCmd.PersistentFlags().Float64(FlagLogSampleDebug, 1, "Fraction of the debug log records kept, above 0 and up to 1 which keeps all")
+	rootCmd.PersistentFlags().Float64(FlagLogSampleInfo, 1, "Fraction of the info log records kept, above 0 and up to 1 which keeps all")
 	rootCmd.PersistentFlags().Bool(FlagCfgDump, false, "Prints current config and exits")
 	rootCmd.PersistentFlags().Bool(FlagCfgWrite, false, "Saves current config to disk (target --config) and exits")
 
@@ -76,8 +92,9 @@ func init() {
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		exit(1)
 	}
+	closeLogSinks()
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"strings"
 
+	"github.com/jonmol/http-skeleton/cmd/config"
 	"github.com/jonmol/http-skeleton/cmd/serve"
 	"github.com/jonmol/http-skeleton/util/logging"
 	"github.com/spf13/cobra"
@@ -51,6 +52,8 @@ func init() {
 }
 
 func addFlags() {
+	config.RegisterSecrets(serve.ConfigStructure.Secrets()...)
+
 	for _, flag := range serve.ConfigStructure.Durations {
 		serveCmd.Flags().Duration(flag.Name, flag.Def, flag.Desc)
 	}
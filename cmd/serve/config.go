@@ -4,8 +4,11 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/jonmol/http-skeleton/cmd/config"
+	"github.com/jonmol/http-skeleton/model"
 	"github.com/jonmol/http-skeleton/server"
 )
 
@@ -14,6 +17,45 @@ const (
 	FieldDBType      = "db-type"
 	FieldDBAddr      = "db-addr"
 	FieldDBPass      = "db-pass"
+	FieldDBPrefix    = "db-prefix"
+
+	FieldDBMemorySnapshot = "db-memory-snapshot"
+
+	FieldDBSecondaryType           = "db-secondary-type"
+	FieldDBSecondaryAddr           = "db-secondary-addr"
+	FieldDBSecondaryPass           = "db-secondary-pass"
+	FieldDBSecondaryMemorySnapshot = "db-secondary-memory-snapshot"
+	FieldDBSwitchOver              = "db-switch-over"
+	FieldDBVerifyInterval          = "db-verify-interval"
+
+	FieldDBRedisUser         = "db-redis-user"
+	FieldDBRedisDB           = "db-redis-db"
+	FieldDBRedisMaster       = "db-redis-master"
+	FieldDBRedisSentinelPass = "db-redis-sentinel-pass"
+	FieldDBRedisCluster      = "db-redis-cluster"
+	FieldDBRedisTLS          = "db-redis-tls"
+	FieldDBRedisTLSCA        = "db-redis-tls-ca"
+	FieldDBRedisTLSCert      = "db-redis-tls-cert"
+	FieldDBRedisTLSKey       = "db-redis-tls-key"
+	FieldDBRedisPoolSize     = "db-redis-pool-size"
+	FieldDBRedisMinIdle      = "db-redis-min-idle"
+	FieldDBRedisPoolTimeout  = "db-redis-pool-timeout"
+	FieldDBRedisDialTimeout  = "db-redis-dial-timeout"
+	FieldDBRedisReadTimeout  = "db-redis-read-timeout"
+	FieldDBRedisWriteTimeout = "db-redis-write-timeout"
+
+	FieldDBBadgerInMemory      = "db-badger-in-memory"
+	FieldDBBadgerSyncWrites    = "db-badger-sync-writes"
+	FieldDBBadgerMemTable      = "db-badger-memtable-mb"
+	FieldDBBadgerBlockCache    = "db-badger-block-cache-mb"
+	FieldDBBadgerIndexCache    = "db-badger-index-cache-mb"
+	FieldDBBadgerCompression   = "db-badger-compression"
+	FieldDBBadgerCompactors    = "db-badger-compactors"
+	FieldDBBadgerGCInterval    = "db-badger-gc-interval"
+	FieldDBBadgerGCDiscard     = "db-badger-gc-discard-pct"
+	FieldDBBadgerGCPauseWrites = "db-badger-gc-pause-writes"
+	FieldDBBadgerKeyFile       = "db-badger-encryption-key-file"
+	FieldDBBadgerKeyEnv        = "db-badger-encryption-key-env"
 
 	FieldAddress           = "http-address"
 	FieldPort              = "http-port"
@@ -23,21 +65,59 @@ const (
 	FieldMaxHeaderSize     = "http-max-header-size"
 	FieldWriteTimeout      = "http-write-timeout"
 
-	FieldTelemetry        = "telemtry"
-	FieldTelemetryAddress = "telemetry-address"
-	FieldTelemetryPort    = "telementry-port"
+	FieldTelemetry             = "telemtry"
+	FieldTelemetryAddress      = "telemetry-address"
+	FieldTelemetryPort         = "telementry-port"
+	FieldTelemetryWriteTimeout = "telemetry-write-timeout"
+	FieldTelemetryAdmin        = "telemetry-admin"
+	FieldLogLevelRevert        = "log-lvl-revert"
+
+	FieldOtelExporter       = "otel-exporter"
+	FieldOtelEndpoint       = "otel-endpoint"
+	FieldOtelSampler        = "otel-sampler"
+	FieldOtelSamplePct      = "otel-sample-pct"
+	FieldOtelBatchTimeout   = "otel-batch-timeout"
+	FieldOtelBatchSize      = "otel-batch-size"
+	FieldOtelMetricInterval = "otel-metric-interval"
+	FieldOtelLogs           = "otel-logs"
+	FieldOtelResourceAttrs  = "otel-resource-attributes"
 
 	FieldMiddlewareTraceIDHeader = "mid-trace-id-header"
 	FieldMiddlewareURLPath       = "mid-url-path"
+	FieldMiddlewareTraceparent   = "mid-traceparent"
+	FieldMiddlewareCrashDir      = "mid-crash-dir"
+	FieldMiddlewareTrustedProxy  = "mid-trusted-proxies"
+	FieldMiddlewareDebugHeader   = "mid-debug-header"
+	FieldMiddlewareDebugSecret   = "mid-debug-secret"
+
+	FieldMiddlewareAccessLog       = "mid-access-log"
+	FieldMiddlewareAccessLogTarget = "mid-access-log-target"
+	FieldMiddlewareAccessLogSample = "mid-access-log-sample-pct"
+	FieldMiddlewareAccessLogSlow   = "mid-access-log-slow"
 
 	FieldMiddlewareCors        = "mid-cors"
 	FieldMiddlewareCorsOrigins = "mid-cors-origins"
 	FieldMiddlewareCorsMethods = "mid-cors-methods"
 	FieldMiddlewareCorsHeaders = "mid-cors-headers"
 
-	FieldMiddlewarePromSize  = "mid-prom-size"
-	FieldMiddlewarePromTime  = "mid-prom-timer"
-	FieldMiddlewarePromCount = "mid-prom-counter"
+	FieldMiddlewarePromSize        = "mid-prom-size"
+	FieldMiddlewarePromTime        = "mid-prom-timer"
+	FieldMiddlewarePromCount       = "mid-prom-counter"
+	FieldMiddlewarePromDurBuckets  = "mid-prom-duration-buckets"
+	FieldMiddlewarePromSizeBuckets = "mid-prom-size-buckets"
+	FieldMiddlewarePromNative      = "mid-prom-native"
+	FieldMiddlewarePromExemplars   = "mid-prom-exemplars"
+
+	FieldMiddlewareLimit             = "mid-limit"
+	FieldMiddlewareLimitInitial      = "mid-limit-initial"
+	FieldMiddlewareLimitMin          = "mid-limit-min"
+	FieldMiddlewareLimitMax          = "mid-limit-max"
+	FieldMiddlewareLimitLatency      = "mid-limit-latency"
+	FieldMiddlewareLimitBackoff      = "mid-limit-backoff-pct"
+	FieldMiddlewareLimitPublicShare  = "mid-limit-public-pct"
+	FieldMiddlewareLimitQueue        = "mid-limit-queue"
+	FieldMiddlewareLimitQueueTimeout = "mid-limit-queue-timeout"
+	FieldMiddlewareLimitRetryAfter   = "mid-limit-retry-after"
 )
 
 var ConfigStructure = config.Configs{
@@ -45,33 +125,103 @@ var ConfigStructure = config.Configs{
 		{Name: FieldPort, Desc: "Public facing http port to listen to", Def: server.DefaultPort},
 		{Name: FieldTelemetryPort, Desc: "Telemetry http port to listen to", Def: server.DefaultTelemetryPort},
 		{Name: FieldMaxHeaderSize, Desc: "Max header size of http requests", Def: server.DefaultMaxHeaderBytes},
+		{Name: FieldMiddlewareLimitInitial, Desc: "Concurrency limit to start with", Def: 100},
+		{Name: FieldMiddlewareLimitMin, Desc: "The concurrency limit never goes below this", Def: 10},
+		{Name: FieldMiddlewareLimitMax, Desc: "The concurrency limit never goes above this", Def: 1000},
+		{Name: FieldMiddlewareLimitBackoff, Desc: "Percentage the concurrency limit is reduced to on a slow request", Def: 90},
+		{Name: FieldMiddlewareLimitPublicShare, Desc: "Percentage of the concurrency limit the public endpoints may use, the rest is reserved for private", Def: 80},
+		{Name: FieldMiddlewareLimitQueue, Desc: "How many requests may wait for a free slot before shedding", Def: 50},
+		{Name: FieldOtelSamplePct, Desc: "Percentage of traces to sample with the ratio samplers", Def: 100},
+		{Name: FieldOtelBatchSize, Desc: "Max spans and logs exported at once, 0 for OTEL_BSP_MAX_EXPORT_BATCH_SIZE/OTEL_BLRP_MAX_EXPORT_BATCH_SIZE or the SDK default", Def: 0},
+		{Name: FieldMiddlewareAccessLogSample, Desc: "Percentage of successful requests to write to the access log, errors and slow requests are always written", Def: 100},
+		{Name: FieldDBRedisDB, Desc: "Redis database index, overrides the one in a redis:// URL. Always 0 in a cluster", Def: 0},
+		{Name: FieldDBRedisPoolSize, Desc: "Max redis connections per node, 0 for 10 per CPU", Def: 0},
+		{Name: FieldDBRedisMinIdle, Desc: "Idle redis connections kept open per node", Def: 0},
+		{Name: FieldDBBadgerMemTable, Desc: "Size of a badger memtable in MB", Def: 64},
+		{Name: FieldDBBadgerBlockCache, Desc: "Size of the badger block cache in MB, needed with compression", Def: 256},
+		{Name: FieldDBBadgerIndexCache, Desc: "Size of the badger index cache in MB, 0 to keep all indices in memory or 100 when encrypted", Def: 0},
+		{Name: FieldDBBadgerCompactors, Desc: "Number of goroutines compacting the badger LSM tree, at least 2", Def: 4},
+		{Name: FieldDBBadgerGCDiscard, Desc: "Percentage of a badger value log file that must be garbage for the GC to rewrite it, 1-99, 0 is the default of 50", Def: 50},
+		{Name: FieldDBBadgerGCPauseWrites, Desc: "The badger value log GC is skipped when there were more writes per second than this since the last run, 0 to never skip", Def: 1000},
 	},
 	Durations: []config.DurationConf{
 		{Name: FieldIdleTimeout, Desc: "How long are idle keep-alive connections allowed?", Def: server.DefaultIdleTimeout},
 		{Name: FieldReadTimeout, Desc: "How long to wait for data while reading HTTP requests?", Def: server.DefaultReadTimeout},
 		{Name: FieldReadHeaderTimeout, Desc: "How long to wait for reading the http headers?", Def: server.DefaultReadHeaderTimeout},
 		{Name: FieldWriteTimeout, Desc: "How long are HTTP writes allowed to take?", Def: server.DefaultWriteTimeout},
+		{Name: FieldOtelBatchTimeout, Desc: "Longest time spans and logs wait before they are exported, 0 for OTEL_BSP_SCHEDULE_DELAY/OTEL_BLRP_SCHEDULE_DELAY or the SDK default", Def: 0},
+		{Name: FieldOtelMetricInterval, Desc: "How often metrics are exported, 0 for OTEL_METRIC_EXPORT_INTERVAL or the SDK default", Def: 0},
+		{Name: FieldTelemetryWriteTimeout, Desc: "How long are HTTP writes on the telemetry server allowed to take? Must be longer than the pprof profiles you take", Def: 60 * time.Second},
+		{Name: FieldLogLevelRevert, Desc: "How long a log level changed on the telemetry server /loglevel or with SIGUSR1 lasts before going back to --log-lvl, 0 for forever", Def: 15 * time.Minute},
+		{Name: FieldMiddlewareLimitLatency, Desc: "Requests slower than this are treated as overload and lowers the concurrency limit", Def: 500 * time.Millisecond},
+		{Name: FieldMiddlewareLimitQueueTimeout, Desc: "How long a request may wait for a free slot before shedding", Def: 100 * time.Millisecond},
+		{Name: FieldMiddlewareLimitRetryAfter, Desc: "Retry-After sent to shed requests", Def: time.Second},
+		{Name: FieldMiddlewareAccessLogSlow, Desc: "Requests slower than this are always written to the access log, 0 to turn off", Def: time.Second},
+		{Name: FieldDBVerifyInterval, Desc: "How often the secondary DB is compared with the authoritative one and differences copied, 0 to only do it on start", Def: 5 * time.Minute},
+		{Name: FieldDBRedisPoolTimeout, Desc: "How long to wait for a free redis connection, 0 for the read timeout plus a second", Def: 0},
+		{Name: FieldDBRedisDialTimeout, Desc: "How long connecting to redis may take, 0 for 5s", Def: 0},
+		{Name: FieldDBRedisReadTimeout, Desc: "How long reading a redis reply may take, 0 for 3s", Def: 0},
+		{Name: FieldDBRedisWriteTimeout, Desc: "How long writing a redis command may take, 0 for the read timeout", Def: 0},
+		{Name: FieldDBBadgerGCInterval, Desc: "How often the badger value log GC runs, 0 to turn it off. Without it the value log grows forever", Def: 5 * time.Minute},
 	},
 	Strings: []config.StringConf{
 		{Name: FieldServiceName, Desc: "Name of the service. Used for path and prometheus", Def: "myService"},
 		{Name: FieldAddress, Desc: "Public facing address to bind to, empty for all", Def: ""},
 		{Name: FieldTelemetryAddress, Desc: "Telemetry address to bind to, empty for all", Def: ""},
-		{Name: FieldTelemetry, Desc: "What type of telemetry to use. prometheus|otel|none", Def: "prometheus"},
-		{Name: FieldMiddlewareTraceIDHeader, Desc: "Set traceID header to be able to follow a individual request/session through the logs", Def: ""},
-		{Name: FieldDBType, Desc: "What key value store to use. badger|redis", Def: "badger"},
-		{Name: FieldDBAddr, Desc: "DB address", Def: filepath.Join(os.TempDir(), "http-skeleton-badger")},
-		{Name: FieldDBPass, Desc: "DB password", Def: ""},
+		{Name: FieldTelemetry, Desc: "What type of telemetry to use. prometheus|otel|none. The telemetry server is started with prometheus for /metrics, or with telemetry-admin", Def: "prometheus"},
+		{Name: FieldOtelExporter, Desc: "Where otel sends traces, metrics and logs. grpc|http (OTLP)|stdout", Def: "grpc"},
+		{Name: FieldOtelEndpoint, Desc: "OTLP collector URL, eg http://localhost:4317, http:// turns off TLS. Empty for OTEL_EXPORTER_OTLP_ENDPOINT or localhost", Def: ""},
+		{Name: FieldOtelSampler, Desc: "Trace sampler. parentbased_traceidratio|traceidratio|parentbased_always_on|parentbased_always_off|always_on|always_off, empty for OTEL_TRACES_SAMPLER or parentbased_always_on", Def: ""},
+		{Name: FieldMiddlewareTraceIDHeader, Desc: "Set traceID header to be able to follow a individual request/session through the logs. Used when there is no traceparent", Def: ""},
+		{Name: FieldMiddlewareDebugHeader, Desc: "Header turning on debug logging for a single request, the value is created with the debug-token command. Empty to turn off", Def: ""},
+		{Name: FieldMiddlewareDebugSecret, Desc: "Secret used to sign the debug header", Def: "", Secret: true},
+		{Name: FieldMiddlewareCrashDir, Desc: "Directory to write crash reports to when a handler panics, empty to turn off", Def: ""},
+		{Name: FieldMiddlewareAccessLogTarget, Desc: "Where to write the access log. app (the application log)|stdout|stderr|path to a file, files are written as json", Def: "app"},
+		{Name: FieldDBType, Desc: "What key value store to use. " + strings.Join(model.Drivers(), "|"), Def: "badger"},
+		{Name: FieldDBAddr, Desc: "DB address. For redis host:port, comma separated for Sentinel or Cluster, or a redis:// or rediss:// URL", Def: filepath.Join(os.TempDir(), "http-skeleton-badger")},
+		{Name: FieldDBPass, Desc: "DB password", Def: "", Secret: true},
+		{Name: FieldDBPrefix, Desc: "Prefix of the DB keys, so several services can share a DB. Empty for service-name", Def: ""},
+		{Name: FieldDBMemorySnapshot, Desc: "File the memory database is saved to on shutdown and read from on start, empty to keep nothing", Def: ""},
+		{Name: FieldDBSecondaryType, Desc: "Key value store to write to as well, for moving to another store without downtime. Empty to turn off", Def: ""},
+		{Name: FieldDBSecondaryAddr, Desc: "Secondary DB address", Def: ""},
+		{Name: FieldDBSecondaryPass, Desc: "Secondary DB password", Def: "", Secret: true},
+		{Name: FieldDBSecondaryMemorySnapshot, Desc: "db-memory-snapshot of the secondary DB", Def: ""},
+		{Name: FieldDBRedisUser, Desc: "Redis ACL user, empty for the default user. Overrides the one in a redis:// URL", Def: ""},
+		{Name: FieldDBRedisMaster, Desc: "Redis master name to ask the Sentinels at db-addr for, empty to not use Sentinel", Def: ""},
+		{Name: FieldDBRedisSentinelPass, Desc: "Password of the redis Sentinels", Def: "", Secret: true},
+		{Name: FieldDBRedisTLSCA, Desc: "PEM file with the CA certificates redis is verified with, empty for the system ones. Turns on TLS", Def: ""},
+		{Name: FieldDBRedisTLSCert, Desc: "PEM file with the client certificate for redis, when the server asks for one", Def: ""},
+		{Name: FieldDBRedisTLSKey, Desc: "PEM file with the key of the redis client certificate", Def: ""},
+		{Name: FieldDBBadgerCompression, Desc: "Compression of the badger tables. none|snappy|zstd", Def: "snappy"},
+		{Name: FieldDBBadgerKeyFile, Desc: "File with the key the badger DB is encrypted with, 16, 24 or 32 bytes for AES-128/192/256. Empty for no encryption", Def: ""},
+		{Name: FieldDBBadgerKeyEnv, Desc: "Environment variable with the key the badger DB is encrypted with, instead of a file", Def: ""},
 	},
 	Bools: []config.BoolConf{
+		{Name: FieldTelemetryAdmin, Desc: "Serve pprof, expvar, build info, config, status, log level and database pages on the telemetry server", Def: false},
 		{Name: FieldMiddlewareCors, Desc: "Activate CORS to allow cross domain requests from browsers", Def: true},
+		{Name: FieldDBSwitchOver, Desc: "Make the secondary DB authoritative, the counts are read from it. Both are still written to so it can be switched back", Def: false},
+		{Name: FieldDBRedisCluster, Desc: "Connect to a Redis Cluster, implied by several comma separated addresses in db-addr without db-redis-master", Def: false},
+		{Name: FieldDBRedisTLS, Desc: "Connect to redis with TLS, implied by a rediss:// URL", Def: false},
+		{Name: FieldDBBadgerInMemory, Desc: "Keep the badger DB in memory only, db-addr is ignored and everything is lost on shutdown", Def: false},
+		{Name: FieldDBBadgerSyncWrites, Desc: "Sync every badger write to disk, slower but nothing is lost if the machine crashes", Def: false},
 		{Name: FieldMiddlewareURLPath, Desc: "Add request path to the logs", Def: false},
-		{Name: FieldMiddlewarePromSize, Desc: "Instrument response sizes, requires prometheus turned on to be active", Def: true},
-		{Name: FieldMiddlewarePromTime, Desc: "Instrument response times, requires prometheus turned on to be active", Def: true},
-		{Name: FieldMiddlewarePromCount, Desc: "Instrument request counter, requires prometheus turned on to be active", Def: true},
+		{Name: FieldMiddlewareTraceparent, Desc: "Read and write W3C traceparent headers, start a span per request if otel is used. The trace ID header is then only a fallback", Def: true},
+		{Name: FieldMiddlewarePromSize, Desc: "Instrument response sizes, requires prometheus or otel telemetry to be active", Def: true},
+		{Name: FieldMiddlewarePromTime, Desc: "Instrument response times, requires prometheus or otel telemetry to be active", Def: true},
+		{Name: FieldMiddlewarePromCount, Desc: "Instrument request counter, requires prometheus or otel telemetry to be active", Def: true},
+		{Name: FieldMiddlewarePromNative, Desc: "Add Prometheus native histograms next to the classic buckets", Def: false},
+		{Name: FieldMiddlewarePromExemplars, Desc: "Add the trace ID as exemplar to the Prometheus request metrics, exposed in the OpenMetrics format", Def: false},
+		{Name: FieldMiddlewareAccessLog, Desc: "Write one log line per request to the access log", Def: true},
+		{Name: FieldOtelLogs, Desc: "Send the logs to the otel collector as well, requires otel telemetry with an OTLP exporter", Def: false},
+		{Name: FieldMiddlewareLimit, Desc: "Adaptive concurrency limiting, sheds load with 503 when overloaded. Health endpoints are never limited", Def: true},
 	},
 	StringArrays: []config.StringArrayConf{
 		{Name: FieldMiddlewareCorsOrigins, Desc: "List of allowed domains for CORS. See https://pkg.go.dev/github.com/jub0bs/fcors#FromOrigins for format. At least one to have CORS active.", Def: []string{"https://example.com"}},
 		{Name: FieldMiddlewareCorsMethods, Desc: "List of allowed verbs for CORS requests. One or multiple of GET,HEAD,POST,PUT,PATCH,DELETE,CONNECT,OPTIONS,TRACE", Def: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}},
 		{Name: FieldMiddlewareCorsHeaders, Desc: "List of allowed headers, for example Authorization", Def: []string{}},
+		{Name: FieldOtelResourceAttrs, Desc: "key=value attributes describing the service to otel, eg deployment.environment=prod. Override OTEL_RESOURCE_ATTRIBUTES", Def: []string{}},
+		{Name: FieldMiddlewarePromDurBuckets, Desc: "Prometheus response time buckets in seconds, empty for the Prometheus defaults", Def: []string{}},
+		{Name: FieldMiddlewarePromSizeBuckets, Desc: "Prometheus response size buckets in bytes, empty for 100B to 10MB in steps of 10x", Def: []string{}},
+		{Name: FieldMiddlewareTrustedProxy, Desc: "IPs or CIDRs of proxies allowed to set X-Forwarded-For and Forwarded, used to find the client IP", Def: []string{}},
 	},
 }
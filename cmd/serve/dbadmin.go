@@ -0,0 +1,187 @@
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jonmol/http-skeleton/instrumentation/admin"
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/util/logging"
+)
+
+// BackupSinceHeader is the trailer of /db/backup with the since of the next incremental backup
+const BackupSinceHeader = "Backup-Since"
+
+// addDBHandlers adds /db/backup and /db/export to the telemetry server, the database can't be opened by another
+// process while serving, badger locks its directory, so that's the way to back it up without downtime. They hand out
+// all the data so they are only served on loopback. The returned shutdowns stop what /db/dualwrite started
+func addDBHandlers(ctx context.Context, adm *admin.Admin, db *model.DB) []Shutdown {
+	adm.AddHandler("/db/backup", admin.LoopbackOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var since uint64
+		if s := r.URL.Query().Get("since"); s != "" {
+			var err error
+			if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+				http.Error(w, "since should be a version", http.StatusBadRequest)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Trailer", BackupSinceHeader)
+		next, err := db.Backup(r.Context(), w, since)
+		if err != nil {
+			dbHandlerError(w, "backup", err)
+			return
+		}
+		w.Header().Set(BackupSinceHeader, strconv.FormatUint(next, 10))
+	})))
+
+	adm.AddHandler("/db/export", admin.LoopbackOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		if _, err := db.Export(r.Context(), w); err != nil {
+			dbHandlerError(w, "export", err)
+		}
+	})))
+
+	if !db.DualWrite() {
+		return nil
+	}
+	runs := newBackgroundRuns(ctx)
+	adm.AddHandler("/db/dualwrite", admin.LoopbackOnly(dualWriteHandler(db, runs)))
+	return []Shutdown{runs.shutdown("db-dualwrite-runs")}
+}
+
+// dualWriteHandler shows the dual write status on GET. POST with run=copy or run=verify starts a copy or a
+// verification in the background, switch-over=true|false changes the authoritative database until the next restart
+//
+//	curl -X POST 'localhost:9090/db/dualwrite?run=verify'
+func dualWriteHandler(db *model.DB, runs *backgroundRuns) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			q := r.URL.Query()
+			if so := q.Get("switch-over"); so != "" {
+				b, err := strconv.ParseBool(so)
+				if err != nil {
+					http.Error(w, "switch-over should be true or false", http.StatusBadRequest)
+					return
+				}
+				db.SwitchOver(b)
+			}
+			// the runs outlive the request, they are canceled on shutdown instead
+			switch q.Get("run") {
+			case "":
+			case "copy":
+				runs.start(func(ctx context.Context) { _, _ = db.Copy(ctx) })
+			case "verify":
+				runs.start(func(ctx context.Context) { _, _ = db.Verify(ctx) })
+			default:
+				http.Error(w, "run should be copy or verify", http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(db.DualWriteStatus(r.Context()))
+	})
+}
+
+// backgroundRuns are goroutines started by requests that outlive them, they are canceled and waited for on shutdown
+type backgroundRuns struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newBackgroundRuns(ctx context.Context) *backgroundRuns {
+	ctx, cancel := context.WithCancel(ctx)
+	return &backgroundRuns{ctx: ctx, cancel: cancel}
+}
+
+func (b *backgroundRuns) start(fn func(ctx context.Context)) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		fn(b.ctx)
+	}()
+}
+
+func (b *backgroundRuns) shutdown(name string) Shutdown {
+	return Shutdown{name, func(sctx context.Context) error {
+		b.cancel()
+		done := make(chan struct{})
+		go func() {
+			b.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-sctx.Done():
+			return sctx.Err()
+		}
+	}}
+}
+
+// startSecondarySync copies the counters to the database that isn't authoritative and verifies them, then verifies
+// every interval and copies again if they differ. Failures are logged by the model and tried again next interval
+func startSecondarySync(ctx context.Context, db *model.DB, interval time.Duration) Shutdown {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		logErr := func(msg string, err error) {
+			// canceled on shutdown
+			if err != nil && ctx.Err() == nil {
+				slog.Error(msg, logging.Err(err))
+			}
+		}
+		_, err := db.Copy(ctx)
+		logErr("Failed to copy to the secondary database", err)
+		for {
+			res, err := db.Verify(ctx)
+			logErr("Failed to verify the secondary database", err)
+			if err == nil && !res.Consistent() {
+				_, err = db.Copy(ctx)
+				logErr("Failed to copy to the secondary database", err)
+			}
+			if interval <= 0 {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+	return Shutdown{"db-secondary-sync", func(sctx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-sctx.Done():
+			return sctx.Err()
+		}
+	}}
+}
+
+// dbHandlerError answers 501 if the database doesn't support it. Other errors can happen after the status is sent,
+// then the response is aborted so the client doesn't take a partial backup for a complete one
+func dbHandlerError(w http.ResponseWriter, what string, err error) {
+	if errors.Is(err, errors.ErrUnsupported) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	slog.Error("Database "+what+" failed", logging.Err(err))
+	panic(http.ErrAbortHandler)
+}
@@ -4,9 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -14,15 +20,22 @@ import (
 	"log/slog"
 
 	"github.com/gorilla/mux"
+	"github.com/jonmol/http-skeleton/cmd/config"
+	"github.com/jonmol/http-skeleton/instrumentation/admin"
 	"github.com/jonmol/http-skeleton/instrumentation/otel"
 	"github.com/jonmol/http-skeleton/model"
+	_ "github.com/jonmol/http-skeleton/model/badger" // registers the badger driver
+	_ "github.com/jonmol/http-skeleton/model/memory" // registers the memory driver
+	_ "github.com/jonmol/http-skeleton/model/redis"  // registers the redis driver
 	"github.com/jonmol/http-skeleton/server"
 	"github.com/jonmol/http-skeleton/server/handler"
 	"github.com/jonmol/http-skeleton/server/middleware"
 	"github.com/jonmol/http-skeleton/server/router"
 	"github.com/jonmol/http-skeleton/server/service"
+	"github.com/jonmol/http-skeleton/server/util/request"
 	"github.com/jonmol/http-skeleton/util/logging"
 	"github.com/jub0bs/fcors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
 )
@@ -51,6 +64,7 @@ func (s *Serve) Run() {
 	stop := make(chan os.Signal, 1)
 
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go toggleDebug()
 	sig := <-stop
 
 	slog.Warn("Received stop signal, shutting down", "signalName", sig.String())
@@ -73,30 +87,84 @@ func (s *Serve) Start() {
 	ctx := context.Background()
 	ctx, s.cancel = context.WithCancel(ctx)
 
-	slog.Debug("Serve starting, configs", "configs", viper.AllSettings())
+	slog.Debug("Serve starting, configs", "configs", Settings())
 
-	if viper.GetString(FieldTelemetry) == "prometheus" {
-		s.shutdowFuncs = append(s.shutdowFuncs, startInstrumentationHTTP())
-	} else if viper.GetString(FieldTelemetry) == "otel" {
-		shut, err := otel.SetupOTelSDK(ctx, "testing", "2.1.0")
-		if err != nil {
-			slog.Error("Failed to start OTEL", logging.Err(err))
-			panic("Failed to setup OTEL")
-		}
-
-		s.shutdowFuncs = append(s.shutdowFuncs, Shutdown{name: "otel", fun: shut})
+	// honor traceparent from clients even without the OTEL SDK
+	otel.SetupPropagator()
+	adm := newAdmin()
+	if h := telemetryHandler(adm); h != nil {
+		s.shutdowFuncs = append(s.shutdowFuncs, startInstrumentationHTTP(h))
+	}
+	if viper.GetString(FieldTelemetry) == "otel" {
+		s.shutdowFuncs = append(s.shutdowFuncs, startOTel(ctx))
 	}
 	db := connectDB(ctx)
 	if err := db.EnsureDB(ctx); err != nil {
 		slog.Error("Failed to setup the db!", logging.Err(err))
 		panic(err)
 	}
+	adm.AddComponent("db", func(ctx context.Context) error {
+		if !db.Healthy(ctx) {
+			return errors.New("not healthy")
+		}
+		return nil
+	})
+	if viper.GetBool(FieldTelemetryAdmin) {
+		s.shutdowFuncs = append(s.shutdowFuncs, addDBHandlers(ctx, adm, db)...)
+	}
+	if db.DualWrite() {
+		s.shutdowFuncs = append(s.shutdowFuncs, startSecondarySync(ctx, db, viper.GetDuration(FieldDBVerifyInterval)))
+	}
 
 	s.shutdowFuncs = append(s.shutdowFuncs, Shutdown{"db", db.Close}, startAPIHTTP(db))
 
 	s.sigHUP()
 }
 
+// startOTel sets up the OTEL SDK. If logs are exported the default logger also writes to OTEL until shutdown
+func startOTel(ctx context.Context) Shutdown {
+	conf := otel.Config{
+		ServiceName:        viper.GetString(FieldServiceName),
+		ResourceAttributes: viper.GetStringSlice(FieldOtelResourceAttrs),
+		Exporter:           viper.GetString(FieldOtelExporter),
+		Endpoint:           viper.GetString(FieldOtelEndpoint),
+		Sampler:            viper.GetString(FieldOtelSampler),
+		SampleRatio:        float64(viper.GetInt(FieldOtelSamplePct)) / 100,
+		BatchTimeout:       viper.GetDuration(FieldOtelBatchTimeout),
+		BatchSize:          viper.GetInt(FieldOtelBatchSize),
+		MetricInterval:     viper.GetDuration(FieldOtelMetricInterval),
+		Logs:               viper.GetBool(FieldOtelLogs),
+	}
+	shut, err := otel.SetupOTelSDK(ctx, conf)
+	if err != nil {
+		slog.Error("Failed to start OTEL", logging.Err(err))
+		panic("Failed to setup OTEL")
+	}
+	if !conf.Logs || conf.Exporter == otel.ExporterStdout {
+		return Shutdown{name: "otel", fun: shut}
+	}
+
+	prev := slog.Default()
+	otelLogs := logging.NewRedactHandler(otel.LogHandler(), viper.GetStringSlice(config.FlagLogRedact))
+	slog.SetDefault(slog.New(logging.NewTeeHandler(prev.Handler(), otelLogs)))
+
+	return Shutdown{name: "otel", fun: func(ctx context.Context) error {
+		slog.SetDefault(prev)
+		return shut(ctx)
+	}}
+}
+
+// toggleDebug switches between debug logging and the configured level on SIGUSR1
+func toggleDebug() {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	for range usr1 {
+		revert := viper.GetDuration(FieldLogLevelRevert)
+		lvl := logging.Levels().ToggleDebug(revert)
+		slog.Warn("Received SIGUSR1, log level changed", slog.String("level", lvl.String()), slog.Duration("revertAfter", revert))
+	}
+}
+
 // sigHUP restarts the server, which will re-read all configs
 func (s *Serve) sigHUP() {
 	go func() {
@@ -111,24 +179,67 @@ func (s *Serve) sigHUP() {
 	}()
 }
 
-// startInstrumentationHTTP starts a separate http.Server on port FieldTelemetryPort. The reason for a separate one is to make
-// it less likely to accidentally expose the /metrics path
-func startInstrumentationHTTP() Shutdown {
+// promRegisterer returns the registerer for the server metrics, nil without prometheus telemetry
+func promRegisterer() prometheus.Registerer {
+	if viper.GetString(FieldTelemetry) == "prometheus" {
+		return prometheus.DefaultRegisterer
+	}
+	return nil
+}
+
+// newAdmin sets up the mux of the telemetry server, /metrics is only added with prometheus telemetry
+func newAdmin() *admin.Admin {
+	conf := admin.Config{
+		AppName:     viper.GetString(FieldServiceName),
+		Settings:    Settings,
+		Levels:      logging.Levels(),
+		LevelRevert: viper.GetDuration(FieldLogLevelRevert),
+	}
+	if viper.GetString(FieldTelemetry) == "prometheus" {
+		conf.Metrics = metricsHandler()
+		conf.Registerer = prometheus.DefaultRegisterer
+	}
+	return admin.New(conf)
+}
+
+// metricsHandler serves /metrics, OpenMetrics is needed for the exemplars
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+}
+
+// telemetryHandler returns what the telemetry server serves: the admin mux with --telemetry-admin, else only
+// /metrics with prometheus telemetry. Nil means there's nothing to serve and the server isn't started
+func telemetryHandler(adm *admin.Admin) http.Handler {
+	if viper.GetBool(FieldTelemetryAdmin) {
+		return adm
+	}
+	if viper.GetString(FieldTelemetry) == "prometheus" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metricsHandler())
+		return mux
+	}
+	return nil
+}
+
+// startInstrumentationHTTP starts a separate http.Server on port FieldTelemetryPort with the admin mux. The reason for a
+// separate one is to make it less likely to accidentally expose /metrics, pprof and the configuration
+func startInstrumentationHTTP(adm http.Handler) Shutdown {
 	ser := server.New(viper.GetDuration(FieldReadTimeout),
 		viper.GetDuration(FieldReadHeaderTimeout),
-		viper.GetDuration(FieldWriteTimeout),
+		viper.GetDuration(FieldTelemetryWriteTimeout),
 		viper.GetDuration(FieldIdleTimeout),
 		viper.GetInt(FieldTelemetryPort),
 		viper.GetInt(FieldMaxHeaderSize),
 		viper.GetString(FieldTelemetryAddress),
-	)
+	).Instrument("telemetry", viper.GetString(FieldServiceName), promRegisterer())
 
 	go func() {
-		if err := ser.Start(promhttp.Handler()); err != nil {
+		if err := ser.Start(adm); err != nil {
 			if errors.Is(err, http.ErrServerClosed) {
 				slog.Info("Instrumentation HTTP Server stopped")
 			} else {
-				slog.Error("Failed to start the http server", err)
+				slog.Error("Failed to start the http server", logging.Err(err))
 				panic("Without HTTP it makes little sense to continue")
 			}
 		}
@@ -146,68 +257,253 @@ func startAPIHTTP(db *model.DB) Shutdown {
 		viper.GetInt(FieldPort),
 		viper.GetInt(FieldMaxHeaderSize),
 		viper.GetString(FieldAddress),
-	)
+	).Instrument("api", viper.GetString(FieldServiceName), promRegisterer())
+
+	accessLog, closeAccessLog := openAccessLog()
 
 	// setup routes and start serving HTTP
+	route := setupRouter(db, accessLog)
 	go func() {
-		route := setupRouter(db)
 		if err := ser.Start(route); err != nil {
 			if errors.Is(err, http.ErrServerClosed) {
 				slog.Info("HTTP Server stopped")
 			} else {
-				slog.Error("Failed to start the http server", err)
+				slog.Error("Failed to start the http server", logging.Err(err))
 				panic("Without HTTP it makes little sense to continue")
 			}
 		}
 	}()
-	return Shutdown{"api-http-server", ser.Stop}
+	return Shutdown{"api-http-server", func(ctx context.Context) error {
+		return errors.Join(ser.Stop(ctx), closeAccessLog())
+	}}
+}
+
+// openAccessLog opens the access log sink. A nil logger means the application log. The returned function
+// closes the sink if it's a file. The other sinks are redacted like the application log
+func openAccessLog() (*slog.Logger, func() error) {
+	noClose := func() error { return nil }
+	jsonLog := func(w io.Writer) *slog.Logger {
+		return slog.New(logging.NewRedactHandler(slog.NewJSONHandler(w, nil), viper.GetStringSlice(config.FlagLogRedact)))
+	}
+	switch target := viper.GetString(FieldMiddlewareAccessLogTarget); target {
+	case "", "app":
+		return nil, noClose
+	case "stdout":
+		return jsonLog(os.Stdout), noClose
+	case "stderr":
+		return jsonLog(os.Stderr), noClose
+	default:
+		f, err := os.OpenFile(filepath.Clean(target), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+		if err != nil {
+			slog.Error("Failed to open the access log", logging.Err(err), slog.String("path", target))
+			panic("Failed to open the access log")
+		}
+		return jsonLog(f), f.Close
+	}
 }
 
+// connectDB opens the database registered as --db-type, the drivers are imported above
 func connectDB(ctx context.Context) *model.DB {
 	db := model.NewModel(ctx)
-	switch viper.GetString(FieldDBType) {
-	case "badger":
-		if err := db.OpenBadger(ctx, viper.GetString(FieldDBAddr)); err != nil {
-			panic(fmt.Sprintf("Failed to open %s at %s", viper.GetString(FieldDBType), viper.GetString(FieldDBAddr)))
-		} else {
-			slog.Info("Connected to Badger", slog.String("path", viper.GetString(FieldDBAddr)))
-		}
-	case "redis":
-		if err := db.OpenRedis(ctx, viper.GetString(FieldDBAddr), viper.GetString(FieldDBPass)); err != nil {
-			panic(fmt.Sprintf("Failed to connect to %s at %s", viper.GetString(FieldDBType), viper.GetString(FieldDBAddr)))
-		} else {
-			slog.Info("Connected to Redis", slog.String("path", viper.GetString(FieldDBAddr)))
+	if err := db.Open(ctx, viper.GetString(FieldDBType), DecodeConfig); err != nil {
+		slog.Error("Failed to open the database", logging.Err(err), slog.String("type", viper.GetString(FieldDBType)),
+			slog.String("addr", RedactAddr(viper.GetString(FieldDBAddr))))
+		panic(fmt.Sprintf("Failed to open %s at %s", viper.GetString(FieldDBType), RedactAddr(viper.GetString(FieldDBAddr))))
+	}
+	slog.Info("Connected to the database", slog.String("type", db.Driver()), slog.String("addr", RedactAddr(viper.GetString(FieldDBAddr))))
+
+	if sec := viper.GetString(FieldDBSecondaryType); sec != "" {
+		if err := db.OpenSecondary(ctx, sec, decodeSecondaryConfig); err != nil {
+			slog.Error("Failed to open the secondary database", logging.Err(err), slog.String("type", sec),
+				slog.String("addr", RedactAddr(viper.GetString(FieldDBSecondaryAddr))))
+			panic(fmt.Sprintf("Failed to open %s at %s", sec, RedactAddr(viper.GetString(FieldDBSecondaryAddr))))
 		}
-	default:
-		panic(fmt.Sprintf("Unsupported database %s selected.", viper.GetString(FieldDBType)))
+		db.InstrumentDualWrite(viper.GetString(FieldServiceName), promRegisterer())
+		db.SwitchOver(viper.GetBool(FieldDBSwitchOver))
 	}
+	db.Instrument(viper.GetString(FieldServiceName), promRegisterer())
 	return db
 }
 
-func setupRouter(db *model.DB) *mux.Router {
+// RedactAddr masks the password of a database URL, addresses that aren't URLs are returned as they are
+func RedactAddr(addr string) string {
+	if u, err := url.Parse(addr); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return addr
+}
+
+// Settings returns the configuration for dumps and logs, with the secrets and the passwords of database URLs masked
+func Settings() map[string]any {
+	settings := viper.AllSettings()
+	for _, k := range []string{FieldDBAddr, FieldDBSecondaryAddr} {
+		if addr, ok := settings[k].(string); ok {
+			settings[k] = RedactAddr(addr)
+		}
+	}
+	return config.Redact(settings)
+}
+
+// DecodeConfig fills the config struct of a database driver from the configuration, the key prefix is the service
+// name unless --db-prefix is set
+func DecodeConfig(conf any) error {
+	viper.SetDefault(FieldDBPrefix, dbPrefix())
+	return viper.Unmarshal(conf)
+}
+
+// dbPrefix returns --db-prefix, or the service name if it's empty
+func dbPrefix() string {
+	if p := viper.GetString(FieldDBPrefix); p != "" {
+		return p
+	}
+	return viper.GetString(FieldServiceName)
+}
+
+// decodeSecondaryConfig fills the config struct of the secondary database driver, the db-secondary-* settings are
+// renamed to db-* so the drivers don't need to know which one they are. The db-badger-* tuning and the key prefix are
+// shared with the primary, unless they're set as db-secondary-* in the config file
+func decodeSecondaryConfig(conf any) error {
+	v := viper.New()
+	v.SetDefault(FieldDBPrefix, dbPrefix())
+	for _, k := range viper.AllKeys() {
+		if strings.HasPrefix(k, "db-badger-") {
+			v.SetDefault(k, viper.Get(k))
+		} else if rest, ok := strings.CutPrefix(k, "db-secondary-"); ok {
+			v.Set("db-"+rest, viper.Get(k))
+		}
+	}
+	return v.Unmarshal(conf)
+}
+
+func setupRouter(db *model.DB, accessLog *slog.Logger) *mux.Router {
 	serviceS := service.New(db.Counter)
 	han := handler.New(serviceS)
 
-	mid := router.Middleware{SecuredMiddleware: addSecMiddlewares(), NonSecuredMiddleware: addPublicMiddlewares()}
+	lim := setupLimiter()
+	acc := setupAccessLog(accessLog)
+	mid := router.Middleware{
+		SecuredRecovery:      newRecovery("private"),
+		NonSecuredRecovery:   newRecovery("public"),
+		SecuredMiddleware:    addSecMiddlewares(lim, acc),
+		NonSecuredMiddleware: addPublicMiddlewares(lim, acc),
+	}
 
 	rConf := router.Config{
-		Middleware:  mid,
-		PromCount:   viper.GetBool(FieldMiddlewarePromCount),
-		PromSize:    viper.GetBool(FieldMiddlewarePromSize),
-		PromTiming:  viper.GetBool(FieldMiddlewarePromTime),
-		ServiceName: viper.GetString(FieldServiceName),
+		Middleware:          mid,
+		PromCount:           viper.GetBool(FieldMiddlewarePromCount),
+		PromSize:            viper.GetBool(FieldMiddlewarePromSize),
+		PromTiming:          viper.GetBool(FieldMiddlewarePromTime),
+		PromNative:          viper.GetBool(FieldMiddlewarePromNative),
+		PromExemplars:       viper.GetBool(FieldMiddlewarePromExemplars),
+		PromDurationBuckets: promBuckets(FieldMiddlewarePromDurBuckets),
+		PromSizeBuckets:     promBuckets(FieldMiddlewarePromSizeBuckets),
+		ServiceName:         viper.GetString(FieldServiceName),
 	}
 
-	if viper.GetString(FieldTelemetry) == "prometheus" {
+	switch viper.GetString(FieldTelemetry) {
+	case "prometheus":
 		rConf.PromethusMiddlleWare = true
+	case "otel":
+		rConf.OTelMeterProvider = otel.MeterProvider()
 	}
 	return router.BuildRouter(han, rConf)
 }
 
+// setupLimiter creates the concurrency limiter shared by the private and public endpoints, nil if turned off
+func setupLimiter() *middleware.Limiter {
+	if !viper.GetBool(FieldMiddlewareLimit) {
+		return nil
+	}
+
+	conf := middleware.LimiterConfig{
+		InitialLimit: viper.GetInt(FieldMiddlewareLimitInitial),
+		MinLimit:     viper.GetInt(FieldMiddlewareLimitMin),
+		MaxLimit:     viper.GetInt(FieldMiddlewareLimitMax),
+		Latency:      viper.GetDuration(FieldMiddlewareLimitLatency),
+		Backoff:      float64(viper.GetInt(FieldMiddlewareLimitBackoff)) / 100,
+		PublicShare:  float64(viper.GetInt(FieldMiddlewareLimitPublicShare)) / 100,
+		MaxQueue:     viper.GetInt(FieldMiddlewareLimitQueue),
+		QueueTimeout: viper.GetDuration(FieldMiddlewareLimitQueueTimeout),
+		RetryAfter:   viper.GetDuration(FieldMiddlewareLimitRetryAfter),
+		AppName:      viper.GetString(FieldServiceName),
+	}
+	if viper.GetString(FieldTelemetry) == "prometheus" {
+		conf.Registerer = prometheus.DefaultRegisterer
+	}
+	return middleware.NewLimiter(conf)
+}
+
+// newRecovery creates the panic recovery middleware for a route group, the router adds it before everything else
+func newRecovery(group string) mux.MiddlewareFunc {
+	conf := middleware.RecoveryConfig{
+		AppName:  viper.GetString(FieldServiceName),
+		Group:    group,
+		CrashDir: viper.GetString(FieldMiddlewareCrashDir),
+	}
+	if viper.GetString(FieldTelemetry) == "prometheus" {
+		conf.Registerer = prometheus.DefaultRegisterer
+	}
+	return middleware.NewRecoveryHandler(conf)
+}
+
+// setupAccessLog creates the access log middleware, nil if turned off
+func setupAccessLog(l *slog.Logger) mux.MiddlewareFunc {
+	if !viper.GetBool(FieldMiddlewareAccessLog) {
+		return nil
+	}
+	return middleware.NewAccessLog(middleware.AccessLogConfig{
+		Logger:         l,
+		SampleRate:     float64(viper.GetInt(FieldMiddlewareAccessLogSample)) / 100,
+		Slow:           viper.GetDuration(FieldMiddlewareAccessLogSlow),
+		TrustedProxies: trustedProxies(),
+	})
+}
+
+// promBuckets parses the histogram buckets in the string array flag field
+func promBuckets(field string) []float64 {
+	vals := viper.GetStringSlice(field)
+	buckets := make([]float64, 0, len(vals))
+	for _, v := range vals {
+		b, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			slog.Error("Failed to parse the Prometheus buckets", slog.String("flag", field), logging.Err(err))
+			panic("Invalid Prometheus buckets")
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+func trustedProxies() []netip.Prefix {
+	p, err := request.ParsePrefixes(viper.GetStringSlice(FieldMiddlewareTrustedProxy))
+	if err != nil {
+		slog.Error("Failed to parse the trusted proxies", logging.Err(err))
+		panic("Invalid trusted proxies")
+	}
+	return p
+}
+
+// newContextHandler creates the middleware adding the logger and trace ID to the request context
+func newContextHandler() mux.MiddlewareFunc {
+	opts := []middleware.ContextOption{
+		middleware.WithDebugHeader(viper.GetString(FieldMiddlewareDebugHeader), []byte(viper.GetString(FieldMiddlewareDebugSecret))),
+	}
+	if viper.GetBool(FieldMiddlewareTraceparent) {
+		opts = append(opts, middleware.WithTracing(otel.Tracing()))
+	}
+	return middleware.NewContextHandler(viper.GetString(FieldMiddlewareTraceIDHeader), viper.GetBool(FieldMiddlewareURLPath), opts...)
+}
+
 // addSecMiddlewares adds any middlewares to be used on secure endpoints
-func addSecMiddlewares() []mux.MiddlewareFunc {
-	mid := make([]mux.MiddlewareFunc, 0, 2)
-	mid = append(mid, middleware.NewContextHandler(viper.GetString(FieldMiddlewareTraceIDHeader), viper.GetBool(FieldMiddlewareURLPath)))
+func addSecMiddlewares(lim *middleware.Limiter, acc mux.MiddlewareFunc) []mux.MiddlewareFunc {
+	mid := make([]mux.MiddlewareFunc, 0, 4)
+	if acc != nil {
+		mid = append(mid, acc)
+	}
+	mid = append(mid, newContextHandler())
+	if lim != nil {
+		mid = append(mid, lim.Middleware(middleware.PriorityPrivate))
+	}
 
 	if viper.GetBool(FieldMiddlewareCors) &&
 		len(viper.GetStringSlice(FieldMiddlewareCorsOrigins)) > 0 &&
@@ -245,8 +541,15 @@ func addSecMiddlewares() []mux.MiddlewareFunc {
 	return mid
 }
 
-func addPublicMiddlewares() []mux.MiddlewareFunc {
-	mid := []mux.MiddlewareFunc{middleware.NewContextHandler(viper.GetString(FieldMiddlewareTraceIDHeader), viper.GetBool(FieldMiddlewareURLPath))}
+func addPublicMiddlewares(lim *middleware.Limiter, acc mux.MiddlewareFunc) []mux.MiddlewareFunc {
+	mid := []mux.MiddlewareFunc{}
+	if acc != nil {
+		mid = append(mid, acc)
+	}
+	mid = append(mid, newContextHandler())
+	if lim != nil {
+		mid = append(mid, lim.Middleware(middleware.PriorityPublic))
+	}
 
 	return mid
 }
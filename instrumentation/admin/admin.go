@@ -0,0 +1,290 @@
+// Package admin is the mux of the telemetry server: metrics, pprof, expvar, build info and status pages. None of it
+// should be reachable from the public port
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonmol/http-skeleton/instrumentation"
+	"github.com/jonmol/http-skeleton/util/buildinfo"
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// checkTimeout is how long a component health check may take on /statusz
+const checkTimeout = 2 * time.Second
+
+// started is when the process started, the mux is rebuilt on SIGHUP so it can't keep it
+var started = time.Now()
+
+// Check reports the health of a component, nil means healthy
+type Check func(ctx context.Context) error
+
+// Config configures the admin mux
+type Config struct {
+	// AppName names the service on /statusz and prefixes the build info metric
+	AppName string
+	// Metrics is served on /metrics, nil leaves it out
+	Metrics http.Handler
+	// Settings returns the effective configuration for /configz, secrets must already be redacted. Nil leaves it out
+	Settings func() map[string]any
+	// Registerer gets the <AppName>_build_info metric, nil leaves it out
+	Registerer prometheus.Registerer
+	// Levels is changed on /loglevel, nil leaves it out
+	Levels *logging.LevelControl
+	// LevelRevert is how long a changed log level lasts unless the request says otherwise, 0 is forever
+	LevelRevert time.Duration
+}
+
+// Admin is the http.Handler of the telemetry server
+type Admin struct {
+	mux   *http.ServeMux
+	conf  Config
+	build buildinfo.Info
+
+	mut        sync.RWMutex
+	paths      []string
+	components map[string]Check
+}
+
+// ComponentStatus is the health of a component on /statusz
+type ComponentStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Status is the /statusz page
+type Status struct {
+	Service    string            `json:"service"`
+	Version    string            `json:"version"`
+	Started    time.Time         `json:"started"`
+	Uptime     string            `json:"uptime"`
+	Healthy    bool              `json:"healthy"`
+	Components []ComponentStatus `json:"components"`
+}
+
+// New returns the admin mux serving:
+// /metrics if Config.Metrics is set
+// /debug/pprof/ the net/http/pprof profiles
+// /debug/vars expvar
+// /buildinfo version, commit and Go version of the binary
+// /configz the effective configuration if Config.Settings is set
+// /statusz uptime and the health of the components added with AddComponent
+// /loglevel the log level if Config.Levels is set, see logLevel
+// / lists the above
+func New(conf Config) *Admin {
+	a := &Admin{
+		mux:        http.NewServeMux(),
+		conf:       conf,
+		build:      buildinfo.Get(),
+		components: map[string]Check{},
+	}
+
+	if conf.Metrics != nil {
+		a.handle("/metrics", conf.Metrics)
+	}
+	a.handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
+	a.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	a.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	a.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	a.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	a.handle("/debug/vars", expvar.Handler())
+	a.handle("/buildinfo", http.HandlerFunc(a.buildInfo))
+	if conf.Settings != nil {
+		a.handle("/configz", http.HandlerFunc(a.configz))
+	}
+	a.handle("/statusz", http.HandlerFunc(a.statusz))
+	if conf.Levels != nil {
+		a.handle("/loglevel", loopbackWrites(http.HandlerFunc(a.logLevel)))
+	}
+	a.mux.HandleFunc("/", a.index)
+
+	if conf.Registerer != nil {
+		a.registerBuildInfo()
+	}
+	return a
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// AddComponent adds a component to /statusz, a component with the same name is replaced
+func (a *Admin) AddComponent(name string, check Check) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.components[name] = check
+}
+
+// AddHandler serves h on path, for pages of components set up after New like the database
+func (a *Admin) AddHandler(path string, h http.Handler) {
+	a.handle(path, h)
+}
+
+// LoopbackOnly answers 403 to requests that don't come from the loopback interface, for pages that read or change
+// data. A reverse proxy on the same host looks like loopback, so don't proxy the telemetry server
+func LoopbackOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			http.Error(w, "only served on loopback", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// loopbackWrites serves GET and HEAD to everyone and the methods that change something only on loopback, see
+// LoopbackOnly
+func loopbackWrites(h http.Handler) http.Handler {
+	lo := LoopbackOnly(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		lo.ServeHTTP(w, r)
+	})
+}
+
+// Status checks the components and returns the status
+func (a *Admin) Status(ctx context.Context) Status {
+	a.mut.RLock()
+	checks := make(map[string]Check, len(a.components))
+	names := make([]string, 0, len(a.components))
+	for n, c := range a.components {
+		checks[n] = c
+		names = append(names, n)
+	}
+	a.mut.RUnlock()
+	sort.Strings(names)
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	s := Status{
+		Service:    a.conf.AppName,
+		Version:    a.build.ServiceVersion(),
+		Started:    started,
+		Uptime:     time.Since(started).Round(time.Second).String(),
+		Healthy:    true,
+		Components: make([]ComponentStatus, 0, len(names)),
+	}
+	for _, n := range names {
+		cs := ComponentStatus{Name: n, Healthy: true}
+		if err := checks[n](ctx); err != nil {
+			cs.Healthy = false
+			cs.Error = err.Error()
+			s.Healthy = false
+		}
+		s.Components = append(s.Components, cs)
+	}
+	return s
+}
+
+func (a *Admin) handle(path string, h http.Handler) {
+	a.mut.Lock()
+	a.paths = append(a.paths, path)
+	a.mut.Unlock()
+	a.mux.Handle(path, h)
+}
+
+func (a *Admin) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	a.mut.RLock()
+	paths := strings.Join(a.paths, "\n")
+	a.mut.RUnlock()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%s admin\n\n%s\n", a.conf.AppName, paths)
+}
+
+func (a *Admin) buildInfo(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, a.build)
+}
+
+func (a *Admin) configz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, a.conf.Settings())
+}
+
+func (a *Admin) statusz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.Status(r.Context()))
+}
+
+// logLevel shows the log levels on GET. PUT changes them with the query parameters level, lib to only change
+// the loggers of that package and for to override Config.LevelRevert. DELETE goes back to the configured level.
+// Debug logging for the whole process is a lot of output, so PUT, POST and DELETE are only served on loopback
+//
+//	curl -X PUT 'localhost:9090/loglevel?level=debug&lib=service&for=10m'
+func (a *Admin) logLevel(w http.ResponseWriter, r *http.Request) {
+	ctl := a.conf.Levels
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var lvl slog.Level
+		if err := lvl.UnmarshalText([]byte(r.URL.Query().Get("level"))); err != nil {
+			http.Error(w, "invalid level: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		revert := a.conf.LevelRevert
+		if f := r.URL.Query().Get("for"); f != "" {
+			var err error
+			if revert, err = time.ParseDuration(f); err != nil {
+				http.Error(w, "invalid for: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		lib := r.URL.Query().Get("lib")
+		if lib == "" {
+			ctl.Set(lvl, revert)
+		} else {
+			ctl.SetLib(lib, lvl, revert)
+		}
+		slog.Warn("Log level changed", logging.Lib("admin"), slog.String("level", lvl.String()),
+			slog.String("lib", lib), slog.Duration("revertAfter", revert))
+	case http.MethodDelete:
+		ctl.Reset()
+		slog.Warn("Log level reset", logging.Lib("admin"), slog.String("level", ctl.Level().String()))
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, ctl.State())
+}
+
+// registerBuildInfo adds the <app>_build_info gauge, always 1, with the build as labels. That way the version
+// can be joined onto other metrics and deploys show up on dashboards
+func (a *Admin) registerBuildInfo() {
+	g := instrumentation.Register(a.conf.Registerer, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: a.conf.AppName + "_build_info",
+		Help: "Build information of the running binary, always 1",
+	}, []string{"version", "commit", "goversion"}))
+	g.WithLabelValues(a.build.ServiceVersion(), a.build.Commit, a.build.GoVersion).Set(1)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Error("Failed to write admin response", logging.Lib("admin"), logging.Err(err))
+	}
+}
@@ -0,0 +1,140 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/jonmol/http-skeleton/instrumentation/admin"
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, h http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+	return w
+}
+
+func TestUnitAdmin(t *testing.T) {
+	r := require.New(t)
+	reg := prometheus.NewRegistry()
+	adm := admin.New(admin.Config{
+		AppName:    "app",
+		Settings:   func() map[string]any { return map[string]any{"db-pass": "[redacted]"} },
+		Registerer: reg,
+	})
+
+	r.Equal(http.StatusNotFound, get(t, adm, "/metrics").Code, "no metrics handler given")
+	r.Equal(http.StatusOK, get(t, adm, "/debug/pprof/").Code)
+	r.Contains(get(t, adm, "/debug/vars").Body.String(), "memstats")
+	r.Contains(get(t, adm, "/").Body.String(), "/statusz")
+
+	var build map[string]any
+	r.NoError(json.Unmarshal(get(t, adm, "/buildinfo").Body.Bytes(), &build))
+	r.Equal(runtime.Version(), build["goVersion"])
+
+	r.JSONEq(`{"db-pass": "[redacted]"}`, get(t, adm, "/configz").Body.String())
+
+	adm.AddHandler("/db/export", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	r.Equal(http.StatusTeapot, get(t, adm, "/db/export").Code)
+	r.Contains(get(t, adm, "/").Body.String(), "/db/export", "added handlers should be listed")
+
+	r.Equal(1, testutil.CollectAndCount(reg, "app_build_info"))
+	// rebuilt on SIGHUP
+	r.NotPanics(func() { admin.New(admin.Config{AppName: "app", Registerer: reg}) })
+}
+
+func TestUnitLoopbackOnly(t *testing.T) {
+	r := require.New(t)
+	h := admin.LoopbackOnly(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	for addr, code := range map[string]int{
+		"127.0.0.1:1234": http.StatusTeapot,
+		"[::1]:1234":     http.StatusTeapot,
+		"10.0.0.1:1234":  http.StatusForbidden,
+		"[fe80::1]:1234": http.StatusForbidden,
+		"garbage":        http.StatusForbidden,
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/db/export", http.NoBody)
+		req.RemoteAddr = addr
+		h.ServeHTTP(w, req)
+		r.Equal(code, w.Code, addr)
+	}
+}
+
+func TestUnitAdminStatus(t *testing.T) {
+	r := require.New(t)
+	adm := admin.New(admin.Config{AppName: "app"})
+	adm.AddComponent("db", func(context.Context) error { return nil })
+
+	var s admin.Status
+	r.NoError(json.Unmarshal(get(t, adm, "/statusz").Body.Bytes(), &s))
+	r.Equal("app", s.Service)
+	r.True(s.Healthy)
+	r.Equal([]admin.ComponentStatus{{Name: "db", Healthy: true}}, s.Components)
+
+	adm.AddComponent("cache", func(context.Context) error { return errors.New("down") })
+	s = adm.Status(context.Background())
+	r.False(s.Healthy)
+	r.Equal([]admin.ComponentStatus{{Name: "cache", Error: "down"}, {Name: "db", Healthy: true}}, s.Components)
+	r.Equal(http.StatusNotFound, get(t, adm, "/configz").Code)
+}
+
+func TestUnitAdminLogLevel(t *testing.T) {
+	r := require.New(t)
+	ctl := logging.NewLevelControl(slog.LevelInfo)
+	adm := admin.New(admin.Config{AppName: "app", Levels: ctl, LevelRevert: time.Hour})
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, http.NoBody)
+		req.RemoteAddr = "127.0.0.1:1234"
+		adm.ServeHTTP(w, req)
+		return w
+	}
+
+	r.Equal(http.StatusOK, do(http.MethodPut, "/loglevel?level=debug").Code)
+	r.Equal(slog.LevelDebug, ctl.Level())
+	r.NotNil(ctl.State().RevertAt)
+
+	r.Equal(http.StatusOK, do(http.MethodPut, "/loglevel?level=warn&lib=redis&for=0s").Code)
+	r.Nil(ctl.State().RevertAt)
+
+	var state logging.LevelState
+	r.NoError(json.Unmarshal(do(http.MethodGet, "/loglevel").Body.Bytes(), &state))
+	r.Equal(logging.LevelState{Level: "DEBUG", Configured: "INFO", Libs: map[string]string{"redis": "WARN"}}, state)
+
+	r.Equal(http.StatusBadRequest, do(http.MethodPut, "/loglevel?level=loud").Code)
+	r.Equal(http.StatusBadRequest, do(http.MethodPut, "/loglevel?level=info&for=soon").Code)
+	r.Equal(http.StatusMethodNotAllowed, do(http.MethodPatch, "/loglevel").Code)
+
+	r.Equal(http.StatusOK, do(http.MethodDelete, "/loglevel").Code)
+	r.Equal(slog.LevelInfo, ctl.Level())
+
+	// the level can only be changed from loopback, it can be read from anywhere
+	remote := func(method string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/loglevel?level=debug", http.NoBody)
+		req.RemoteAddr = "10.0.0.1:1234"
+		adm.ServeHTTP(w, req)
+		return w.Code
+	}
+	r.Equal(http.StatusOK, remote(http.MethodGet))
+	r.Equal(http.StatusForbidden, remote(http.MethodPut))
+	r.Equal(http.StatusForbidden, remote(http.MethodDelete))
+	r.Equal(slog.LevelInfo, ctl.Level())
+}
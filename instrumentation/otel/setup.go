@@ -3,24 +3,86 @@ package otel
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
+	"github.com/jonmol/http-skeleton/util/buildinfo"
+	"github.com/jonmol/http-skeleton/util/logging"
+	"go.opentelemetry.io/contrib/bridges/otelslog"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
 	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/log/global"
+	otelmetric "go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
 	"go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
-// setupOTelSDK bootstraps the OpenTelemetry pipeline.
-// If it does not return an error, make sure to call shutdown for proper cleanup.
-func SetupOTelSDK(ctx context.Context, serviceName, serviceVersion string) (shutdown func(context.Context) error, err error) {
-	slog.Warn("This is the default OTEL from https://opentelemetry.io/docs/instrumentation/go/getting-started/. If you want to use OTEL you need to set it up properly for your usecase")
+const (
+	ExporterGRPC   = "grpc"
+	ExporterHTTP   = "http"
+	ExporterStdout = "stdout"
 
+	SamplerAlwaysOn                = "always_on"
+	SamplerAlwaysOff               = "always_off"
+	SamplerTraceIDRatio            = "traceidratio"
+	SamplerParentBasedAlwaysOn     = "parentbased_always_on"
+	SamplerParentBasedAlwaysOff    = "parentbased_always_off"
+	SamplerParentBasedTraceIDRatio = "parentbased_traceidratio"
+
+	scopeName = "github.com/jonmol/http-skeleton"
+)
+
+// Config configures the OTEL SDK. Zero values leave the setting to the standard OTEL_* environment variables, see
+// https://opentelemetry.io/docs/specs/otel/configuration/sdk-environment-variables/, and if not set there to the
+// SDK defaults
+type Config struct {
+	// ServiceName is used unless OTEL_SERVICE_NAME is set
+	ServiceName string
+	// ServiceVersion defaults to the version from the build info
+	ServiceVersion string
+	// ResourceAttributes are key=value pairs added to the resource, they override OTEL_RESOURCE_ATTRIBUTES
+	ResourceAttributes []string
+
+	// Exporter is grpc, http or stdout. The stdout exporter is meant for local testing and doesn't export logs
+	Exporter string
+	// Endpoint is the collector URL, eg http://localhost:4317. A http:// URL turns off TLS. Empty uses
+	// OTEL_EXPORTER_OTLP_ENDPOINT, or localhost
+	Endpoint string
+
+	// Sampler is one of the Sampler constants. Empty uses OTEL_TRACES_SAMPLER, or parentbased_always_on
+	Sampler string
+	// SampleRatio is the share of traces to sample, 0 <= SampleRatio <= 1, used by the ratio samplers
+	SampleRatio float64
+
+	// BatchTimeout is the longest time spans and logs are held before they are exported
+	BatchTimeout time.Duration
+	// BatchSize is the max number of spans and logs exported at once
+	BatchSize int
+	// MetricInterval is how often metrics are exported
+	MetricInterval time.Duration
+
+	// Logs turns on exporting logs, see LogHandler
+	Logs bool
+}
+
+// SetupOTelSDK bootstraps the OpenTelemetry pipeline with traces, metrics and optionally logs exported to conf.Exporter.
+// If it does not return an error, make sure to call shutdown for proper cleanup, it flushes what's left to export.
+func SetupOTelSDK(ctx context.Context, conf Config) (shutdown func(context.Context) error, err error) {
 	var shutdownFuncs []func(context.Context) error
 
 	// shutdown calls cleanup functions registered via shutdownFuncs.
@@ -41,19 +103,29 @@ func SetupOTelSDK(ctx context.Context, serviceName, serviceVersion string) (shut
 		err = errors.Join(inErr, shutdown(ctx))
 	}
 
+	switch conf.Exporter {
+	case ExporterGRPC, ExporterHTTP, ExporterStdout:
+	default:
+		return nil, fmt.Errorf("unknown otel exporter %q", conf.Exporter)
+	}
+
+	sampler, err := newSampler(conf.Sampler, conf.SampleRatio)
+	if err != nil {
+		return nil, err
+	}
+
 	// Set up resource.
-	res, err := newResource(serviceName, serviceVersion)
+	res, err := newResource(ctx, conf)
 	if err != nil {
-		handleErr(err)
-		return
+		return nil, err
 	}
 
-	// Set up propagator.
-	prop := newPropagator()
-	otel.SetTextMapPropagator(prop)
+	// Set up propagator and error handler.
+	SetupPropagator()
+	otel.SetErrorHandler(errorHandler{})
 
 	// Set up trace provider.
-	tracerProvider, err := newTraceProvider(res)
+	tracerProvider, err := newTraceProvider(ctx, conf, res, sampler)
 	if err != nil {
 		handleErr(err)
 		return
@@ -62,7 +134,7 @@ func SetupOTelSDK(ctx context.Context, serviceName, serviceVersion string) (shut
 	otel.SetTracerProvider(tracerProvider)
 
 	// Set up meter provider.
-	meterProvider, err := newMeterProvider(res)
+	meterProvider, err := newMeterProvider(ctx, conf, res)
 	if err != nil {
 		handleErr(err)
 		return
@@ -70,15 +142,107 @@ func SetupOTelSDK(ctx context.Context, serviceName, serviceVersion string) (shut
 	shutdownFuncs = append(shutdownFuncs, meterProvider.Shutdown)
 	otel.SetMeterProvider(meterProvider)
 
+	// Set up logger provider.
+	if conf.Logs && conf.Exporter != ExporterStdout {
+		loggerProvider, er := newLoggerProvider(ctx, conf, res)
+		if er != nil {
+			handleErr(er)
+			return
+		}
+		shutdownFuncs = append(shutdownFuncs, loggerProvider.Shutdown)
+		global.SetLoggerProvider(loggerProvider)
+	}
+
 	return
 }
 
-func newResource(serviceName, serviceVersion string) (*resource.Resource, error) {
-	return resource.Merge(resource.Default(),
-		resource.NewWithAttributes(semconv.SchemaURL,
-			semconv.ServiceName(serviceName),
-			semconv.ServiceVersion(serviceVersion),
-		))
+// LogHandler returns a slog handler sending the logs to the logger provider set up by SetupOTelSDK. Log records
+// get the trace and span ID from the context passed to the logger, so use the context versions of the log functions
+func LogHandler() slog.Handler {
+	return otelslog.NewHandler(scopeName, otelslog.WithLoggerProvider(global.GetLoggerProvider()))
+}
+
+// newResource describes the service. The precedence is, lowest first: detected host and runtime, the service
+// name and version from conf, OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES and last conf.ResourceAttributes
+func newResource(ctx context.Context, conf Config) (*resource.Resource, error) {
+	attrs, err := parseAttributes(conf.ResourceAttributes)
+	if err != nil {
+		return nil, err
+	}
+	version := conf.ServiceVersion
+	if version == "" {
+		version = buildinfo.Get().ServiceVersion()
+	}
+
+	return resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithProcessRuntimeName(),
+		resource.WithProcessRuntimeVersion(),
+		resource.WithAttributes(
+			semconv.ServiceName(conf.ServiceName),
+			semconv.ServiceVersion(version),
+		),
+		resource.WithFromEnv(),
+		resource.WithAttributes(attrs...),
+	)
+}
+
+func parseAttributes(kvs []string) ([]attribute.KeyValue, error) {
+	attrs := make([]attribute.KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		k, v, ok := strings.Cut(kv, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("resource attribute %q isn't key=value", kv)
+		}
+		attrs = append(attrs, attribute.String(k, strings.TrimSpace(v)))
+	}
+	return attrs, nil
+}
+
+// signalURL appends the path of signal to the base URL of the collector, like the exporters do with
+// OTEL_EXPORTER_OTLP_ENDPOINT. With OTLP HTTP each signal has its own path
+func signalURL(endpoint, signal string) string {
+	return strings.TrimSuffix(endpoint, "/") + "/v1/" + signal
+}
+
+// newSampler returns nil if name is empty, the SDK then reads OTEL_TRACES_SAMPLER
+func newSampler(name string, ratio float64) (trace.Sampler, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case SamplerAlwaysOn:
+		return trace.AlwaysSample(), nil
+	case SamplerAlwaysOff:
+		return trace.NeverSample(), nil
+	case SamplerTraceIDRatio:
+		return trace.TraceIDRatioBased(ratio), nil
+	case SamplerParentBasedAlwaysOn:
+		return trace.ParentBased(trace.AlwaysSample()), nil
+	case SamplerParentBasedAlwaysOff:
+		return trace.ParentBased(trace.NeverSample()), nil
+	case SamplerParentBasedTraceIDRatio:
+		return trace.ParentBased(trace.TraceIDRatioBased(ratio)), nil
+	}
+	return nil, fmt.Errorf("unknown otel sampler %q", name)
+}
+
+// SetupPropagator makes W3C trace context and baggage the global propagator. SetupOTelSDK calls it, but it
+// should also be called without the SDK so that traceparent from clients is still honored
+func SetupPropagator() {
+	otel.SetTextMapPropagator(newPropagator())
+}
+
+// Tracing returns the global tracer provider and propagator, the provider is a noop until SetupOTelSDK is called
+func Tracing() (oteltrace.TracerProvider, propagation.TextMapPropagator) {
+	return otel.GetTracerProvider(), otel.GetTextMapPropagator()
+}
+
+// MeterProvider returns the global meter provider, a noop until SetupOTelSDK is called
+func MeterProvider() otelmetric.MeterProvider {
+	return otel.GetMeterProvider()
 }
 
 func newPropagator() propagation.TextMapPropagator {
@@ -88,33 +252,129 @@ func newPropagator() propagation.TextMapPropagator {
 	)
 }
 
-func newTraceProvider(res *resource.Resource) (*trace.TracerProvider, error) {
-	traceExporter, err := stdouttrace.New(
-		stdouttrace.WithPrettyPrint())
+func newTraceProvider(ctx context.Context, conf Config, res *resource.Resource, sampler trace.Sampler) (*trace.TracerProvider, error) {
+	var (
+		traceExporter trace.SpanExporter
+		err           error
+	)
+	switch conf.Exporter {
+	case ExporterGRPC:
+		var opts []otlptracegrpc.Option
+		if conf.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(conf.Endpoint))
+		}
+		traceExporter, err = otlptracegrpc.New(ctx, opts...)
+	case ExporterHTTP:
+		var opts []otlptracehttp.Option
+		if conf.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(signalURL(conf.Endpoint, "traces")))
+		}
+		traceExporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		traceExporter, err = stdouttrace.New()
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	traceProvider := trace.NewTracerProvider(
-		trace.WithBatcher(traceExporter,
-			// Default is 5s. Set to 1s for demonstrative purposes.
-			trace.WithBatchTimeout(time.Second)),
+	// without options the batcher reads OTEL_BSP_*
+	var batchOpts []trace.BatchSpanProcessorOption
+	if conf.BatchTimeout > 0 {
+		batchOpts = append(batchOpts, trace.WithBatchTimeout(conf.BatchTimeout))
+	}
+	if conf.BatchSize > 0 {
+		batchOpts = append(batchOpts, trace.WithMaxExportBatchSize(conf.BatchSize))
+	}
+
+	opts := []trace.TracerProviderOption{
+		trace.WithBatcher(traceExporter, batchOpts...),
 		trace.WithResource(res),
-	)
-	return traceProvider, nil
+	}
+	if sampler != nil {
+		opts = append(opts, trace.WithSampler(sampler))
+	}
+	return trace.NewTracerProvider(opts...), nil
 }
 
-func newMeterProvider(res *resource.Resource) (*metric.MeterProvider, error) {
-	metricExporter, err := stdoutmetric.New()
+func newMeterProvider(ctx context.Context, conf Config, res *resource.Resource) (*metric.MeterProvider, error) {
+	var (
+		metricExporter metric.Exporter
+		err            error
+	)
+	switch conf.Exporter {
+	case ExporterGRPC:
+		var opts []otlpmetricgrpc.Option
+		if conf.Endpoint != "" {
+			opts = append(opts, otlpmetricgrpc.WithEndpointURL(conf.Endpoint))
+		}
+		metricExporter, err = otlpmetricgrpc.New(ctx, opts...)
+	case ExporterHTTP:
+		var opts []otlpmetrichttp.Option
+		if conf.Endpoint != "" {
+			opts = append(opts, otlpmetrichttp.WithEndpointURL(signalURL(conf.Endpoint, "metrics")))
+		}
+		metricExporter, err = otlpmetrichttp.New(ctx, opts...)
+	default:
+		metricExporter, err = stdoutmetric.New()
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	// without options the reader reads OTEL_METRIC_EXPORT_*
+	var readerOpts []metric.PeriodicReaderOption
+	if conf.MetricInterval > 0 {
+		readerOpts = append(readerOpts, metric.WithInterval(conf.MetricInterval))
+	}
+
 	meterProvider := metric.NewMeterProvider(
 		metric.WithResource(res),
-		metric.WithReader(metric.NewPeriodicReader(metricExporter,
-			// Default is 1m. Set to 3s for demonstrative purposes.
-			metric.WithInterval(3*time.Second))),
+		metric.WithReader(metric.NewPeriodicReader(metricExporter, readerOpts...)),
 	)
 	return meterProvider, nil
 }
+
+func newLoggerProvider(ctx context.Context, conf Config, res *resource.Resource) (*sdklog.LoggerProvider, error) {
+	var (
+		logExporter sdklog.Exporter
+		err         error
+	)
+	switch conf.Exporter {
+	case ExporterGRPC:
+		var opts []otlploggrpc.Option
+		if conf.Endpoint != "" {
+			opts = append(opts, otlploggrpc.WithEndpointURL(conf.Endpoint))
+		}
+		logExporter, err = otlploggrpc.New(ctx, opts...)
+	default:
+		var opts []otlploghttp.Option
+		if conf.Endpoint != "" {
+			opts = append(opts, otlploghttp.WithEndpointURL(signalURL(conf.Endpoint, "logs")))
+		}
+		logExporter, err = otlploghttp.New(ctx, opts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// without options the processor reads OTEL_BLRP_*
+	var batchOpts []sdklog.BatchProcessorOption
+	if conf.BatchTimeout > 0 {
+		batchOpts = append(batchOpts, sdklog.WithExportInterval(conf.BatchTimeout))
+	}
+	if conf.BatchSize > 0 {
+		batchOpts = append(batchOpts, sdklog.WithExportMaxBatchSize(conf.BatchSize))
+	}
+
+	return sdklog.NewLoggerProvider(
+		sdklog.WithResource(res),
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(logExporter, batchOpts...)),
+	), nil
+}
+
+// errorHandler sends errors from the SDK, like failed exports, to slog instead of the standard logger
+type errorHandler struct{}
+
+func (errorHandler) Handle(err error) {
+	slog.Warn("OpenTelemetry error", logging.Lib("otel"), logging.Err(err))
+}
@@ -0,0 +1,275 @@
+package otel_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/jonmol/http-skeleton/instrumentation/otel"
+	"github.com/stretchr/testify/require"
+	otelapi "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is a stand-in for an OTEL collector, recording what is exported over OTLP HTTP or gRPC
+type collector struct {
+	mut     sync.Mutex
+	traces  []*coltrace.ExportTraceServiceRequest
+	metrics []*colmetrics.ExportMetricsServiceRequest
+	logs    []*collogs.ExportLogsServiceRequest
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req, resp proto.Message
+	switch r.URL.Path {
+	case "/v1/traces":
+		req, resp = &coltrace.ExportTraceServiceRequest{}, &coltrace.ExportTraceServiceResponse{}
+	case "/v1/metrics":
+		req, resp = &colmetrics.ExportMetricsServiceRequest{}, &colmetrics.ExportMetricsServiceResponse{}
+	case "/v1/logs":
+		req, resp = &collogs.ExportLogsServiceRequest{}, &collogs.ExportLogsServiceResponse{}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.record(req)
+
+	out, _ := proto.Marshal(resp)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(out)
+}
+
+func (c *collector) record(m proto.Message) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	switch req := m.(type) {
+	case *coltrace.ExportTraceServiceRequest:
+		c.traces = append(c.traces, req)
+	case *colmetrics.ExportMetricsServiceRequest:
+		c.metrics = append(c.metrics, req)
+	case *collogs.ExportLogsServiceRequest:
+		c.logs = append(c.logs, req)
+	}
+}
+
+type traceService struct {
+	coltrace.UnimplementedTraceServiceServer
+	c *collector
+}
+
+func (s traceService) Export(_ context.Context, req *coltrace.ExportTraceServiceRequest) (*coltrace.ExportTraceServiceResponse, error) {
+	s.c.record(req)
+	return &coltrace.ExportTraceServiceResponse{}, nil
+}
+
+type metricsService struct {
+	colmetrics.UnimplementedMetricsServiceServer
+	c *collector
+}
+
+func (s metricsService) Export(_ context.Context, req *colmetrics.ExportMetricsServiceRequest) (*colmetrics.ExportMetricsServiceResponse, error) {
+	s.c.record(req)
+	return &colmetrics.ExportMetricsServiceResponse{}, nil
+}
+
+type logsService struct {
+	collogs.UnimplementedLogsServiceServer
+	c *collector
+}
+
+func (s logsService) Export(_ context.Context, req *collogs.ExportLogsServiceRequest) (*collogs.ExportLogsServiceResponse, error) {
+	s.c.record(req)
+	return &collogs.ExportLogsServiceResponse{}, nil
+}
+
+func httpCollector(t *testing.T) (*collector, string) {
+	t.Helper()
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+	return c, srv.URL
+}
+
+func grpcCollector(t *testing.T) (*collector, string) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	c := &collector{}
+	srv := grpc.NewServer()
+	coltrace.RegisterTraceServiceServer(srv, traceService{c: c})
+	colmetrics.RegisterMetricsServiceServer(srv, metricsService{c: c})
+	collogs.RegisterLogsServiceServer(srv, logsService{c: c})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return c, "http://" + lis.Addr().String()
+}
+
+// emit creates a span, a counter and a log line and flushes them to the collector
+func emit(t *testing.T, shutdown func(context.Context) error) {
+	t.Helper()
+	ctx, span := otelapi.Tracer("test").Start(context.Background(), "test-span")
+	counter, err := otelapi.Meter("test").Int64Counter("test_counter")
+	require.NoError(t, err)
+	counter.Add(ctx, 3)
+	slog.New(otel.LogHandler()).InfoContext(ctx, "test-log", "key", "value")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+}
+
+func resourceAttr(attrs []*commonpb.KeyValue, key string) string {
+	for _, a := range attrs {
+		if a.GetKey() == key {
+			return a.GetValue().GetStringValue()
+		}
+	}
+	return ""
+}
+
+func TestUnitExport(t *testing.T) {
+	tests := map[string]func(*testing.T) (*collector, string){
+		otel.ExporterHTTP: httpCollector,
+		otel.ExporterGRPC: grpcCollector,
+	}
+
+	for exporter, start := range tests {
+		t.Run(exporter, func(t *testing.T) {
+			r := require.New(t)
+			c, endpoint := start(t)
+
+			shutdown, err := otel.SetupOTelSDK(context.Background(), otel.Config{
+				ServiceName:        "svc",
+				ServiceVersion:     "1.2.3",
+				ResourceAttributes: []string{"deployment.environment=test"},
+				Exporter:           exporter,
+				Endpoint:           endpoint,
+				Sampler:            otel.SamplerAlwaysOn,
+				Logs:               true,
+			})
+			r.NoError(err)
+			emit(t, shutdown)
+
+			c.mut.Lock()
+			defer c.mut.Unlock()
+			r.Len(c.traces, 1)
+			rs := c.traces[0].GetResourceSpans()[0]
+			r.Equal("svc", resourceAttr(rs.GetResource().GetAttributes(), "service.name"))
+			r.Equal("1.2.3", resourceAttr(rs.GetResource().GetAttributes(), "service.version"))
+			r.Equal("test", resourceAttr(rs.GetResource().GetAttributes(), "deployment.environment"))
+			span := rs.GetScopeSpans()[0].GetSpans()[0]
+			r.Equal("test-span", span.GetName())
+
+			r.NotEmpty(c.metrics)
+			r.Equal("test_counter", c.metrics[0].GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()[0].GetName())
+
+			r.Len(c.logs, 1)
+			rec := c.logs[0].GetResourceLogs()[0].GetScopeLogs()[0].GetLogRecords()[0]
+			r.Equal("test-log", rec.GetBody().GetStringValue())
+			r.Equal(span.GetTraceId(), rec.GetTraceId())
+		})
+	}
+}
+
+func TestUnitExportEnv(t *testing.T) {
+	r := require.New(t)
+	c, endpoint := httpCollector(t)
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", endpoint)
+	t.Setenv("OTEL_SERVICE_NAME", "from-env")
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "team=env,deployment.environment=env")
+	t.Setenv("OTEL_TRACES_SAMPLER", "always_off")
+
+	shutdown, err := otel.SetupOTelSDK(context.Background(), otel.Config{
+		ServiceName:        "svc",
+		ResourceAttributes: []string{"deployment.environment=config"},
+		Exporter:           otel.ExporterHTTP,
+		Sampler:            otel.SamplerAlwaysOn, // the config wins over OTEL_TRACES_SAMPLER
+	})
+	r.NoError(err)
+	emit(t, shutdown)
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	r.Len(c.traces, 1)
+	attrs := c.traces[0].GetResourceSpans()[0].GetResource().GetAttributes()
+	r.Equal("from-env", resourceAttr(attrs, "service.name"))
+	r.Equal("env", resourceAttr(attrs, "team"))
+	r.Equal("config", resourceAttr(attrs, "deployment.environment"))
+	r.NotEmpty(resourceAttr(attrs, "service.version"))
+}
+
+func TestUnitSampler(t *testing.T) {
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	tests := []struct {
+		sampler    string
+		ratio      float64
+		withParent bool
+		sampled    bool
+	}{
+		{sampler: otel.SamplerAlwaysOn, sampled: true},
+		{sampler: otel.SamplerAlwaysOff, withParent: true, sampled: false},
+		{sampler: otel.SamplerTraceIDRatio, ratio: 0, withParent: true, sampled: false},
+		{sampler: otel.SamplerTraceIDRatio, ratio: 1, sampled: true},
+		{sampler: otel.SamplerParentBasedTraceIDRatio, ratio: 0, sampled: false},
+		{sampler: otel.SamplerParentBasedTraceIDRatio, ratio: 0, withParent: true, sampled: true},
+		{sampler: otel.SamplerParentBasedAlwaysOff, withParent: true, sampled: true},
+		{sampler: otel.SamplerParentBasedAlwaysOn, sampled: true},
+	}
+
+	_, endpoint := httpCollector(t)
+	for _, tc := range tests {
+		shutdown, err := otel.SetupOTelSDK(context.Background(), otel.Config{
+			Exporter:    otel.ExporterHTTP,
+			Endpoint:    endpoint,
+			Sampler:     tc.sampler,
+			SampleRatio: tc.ratio,
+		})
+		require.NoError(t, err)
+
+		ctx := context.Background()
+		if tc.withParent {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, parent)
+		}
+		_, span := otelapi.Tracer("test").Start(ctx, "test-span")
+		require.Equal(t, tc.sampled, span.SpanContext().IsSampled(), "%s ratio %v parent %v", tc.sampler, tc.ratio, tc.withParent)
+		span.End()
+		require.NoError(t, shutdown(context.Background()))
+	}
+}
+
+func TestUnitSetupErrors(t *testing.T) {
+	tests := map[string]otel.Config{
+		"exporter":  {Exporter: "carrier-pigeon"},
+		"sampler":   {Exporter: otel.ExporterHTTP, Sampler: "sometimes"},
+		"attribute": {Exporter: otel.ExporterHTTP, ResourceAttributes: []string{"no-value"}},
+	}
+	for name, conf := range tests {
+		_, err := otel.SetupOTelSDK(context.Background(), conf)
+		require.Error(t, err, name)
+	}
+}
@@ -0,0 +1,29 @@
+// Package instrumentation has what the metrics of the other packages share, the telemetry servers are in admin and
+// otel
+package instrumentation
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Register registers c with reg and returns the collector to use. The router is rebuilt on SIGHUP and a database
+// can be opened again, so the same metric can be registered twice. Then the already registered collector is returned
+// and the values keep accumulating. It panics on other errors, and if the registered collector isn't a C
+func Register[C prometheus.Collector](reg prometheus.Registerer, c C) C {
+	err := reg.Register(c)
+	if err == nil {
+		return c
+	}
+	var are prometheus.AlreadyRegisteredError
+	if !errors.As(err, &are) {
+		panic(err)
+	}
+	existing, ok := are.ExistingCollector.(C)
+	if !ok {
+		panic(fmt.Sprintf("the metric is already registered as a %T, not a %T", are.ExistingCollector, c))
+	}
+	return existing
+}
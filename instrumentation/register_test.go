@@ -0,0 +1,28 @@
+package instrumentation_test
+
+import (
+	"testing"
+
+	"github.com/jonmol/http-skeleton/instrumentation"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitRegister(t *testing.T) {
+	r := require.New(t)
+	reg := prometheus.NewRegistry()
+	opts := prometheus.CounterOpts{Name: "app_things_total", Help: "Things"}
+
+	c := instrumentation.Register(reg, prometheus.NewCounter(opts))
+	c.Inc()
+	again := instrumentation.Register(reg, prometheus.NewCounter(opts))
+	again.Inc()
+	r.Same(c, again, "the registered counter should be reused")
+
+	r.Panics(func() {
+		instrumentation.Register(reg, prometheus.NewGauge(prometheus.GaugeOpts{Name: "app_things_total", Help: "Things"}))
+	}, "a gauge isn't the registered counter")
+	r.Panics(func() {
+		instrumentation.Register(reg, prometheus.NewCounter(prometheus.CounterOpts{Name: "bad name", Help: "Bad"}))
+	})
+}
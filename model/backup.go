@@ -0,0 +1,110 @@
+package model
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Counter kinds in the export format
+const (
+	RecordGlobal = "global"
+	RecordWord   = "word"
+)
+
+// Backuper is implemented by backends with a native backup format, like badger's
+type Backuper interface {
+	// Backup writes everything changed after the version since, 0 for a full backup. The returned version is the
+	// since of the next incremental backup
+	Backup(ctx context.Context, w io.Writer, since uint64) (uint64, error)
+	// Restore loads a full or incremental backup, it should only be done while nothing else uses the database
+	Restore(ctx context.Context, r io.Reader) error
+}
+
+// Record is a counter in the export format. It doesn't depend on how the backend stores it, that's what makes it
+// possible to export from one backend and import into another
+type Record struct {
+	Counter string `json:"counter"`
+	Word    string `json:"word,omitempty"`
+	Count   uint64 `json:"count"`
+}
+
+// Exporter is implemented by backends whose counters can be exported and imported
+type Exporter interface {
+	// Export calls fn for every counter
+	Export(ctx context.Context, fn func(Record) error) error
+	// Import sets the counter of the record, replacing what's there
+	Import(ctx context.Context, rec Record) error
+	// Count returns the counter of the record without changing it, 0 if it doesn't exist. The Count of rec is ignored
+	Count(ctx context.Context, rec Record) (uint64, error)
+}
+
+// Backup writes a native backup, see Backuper
+func (db *DB) Backup(ctx context.Context, w io.Writer, since uint64) (uint64, error) {
+	b, ok := db.db.(Backuper)
+	if !ok {
+		return 0, fmt.Errorf("database %s has no native backup, export it instead: %w", db.Driver(), errors.ErrUnsupported)
+	}
+	return b.Backup(ctx, w, since)
+}
+
+// Restore loads a native backup, see Backuper
+func (db *DB) Restore(ctx context.Context, r io.Reader) error {
+	b, ok := db.db.(Backuper)
+	if !ok {
+		return fmt.Errorf("database %s has no native backup, import it instead: %w", db.Driver(), errors.ErrUnsupported)
+	}
+	return b.Restore(ctx, r)
+}
+
+// Export writes the counters as JSON lines, one Record per line, and returns how many were written
+func (db *DB) Export(ctx context.Context, w io.Writer) (int, error) {
+	e, ok := db.db.(Exporter)
+	if !ok {
+		return 0, fmt.Errorf("database %s can't be exported: %w", db.Driver(), errors.ErrUnsupported)
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	n := 0
+	err := e.Export(ctx, func(rec Record) error {
+		n++
+		return enc.Encode(rec)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// Import reads the JSON lines written by Export and returns how many counters were imported. Counters already in
+// the database are replaced, others are kept
+func (db *DB) Import(ctx context.Context, r io.Reader) (int, error) {
+	e, ok := db.db.(Exporter)
+	if !ok {
+		return 0, fmt.Errorf("database %s can't be imported into: %w", db.Driver(), errors.ErrUnsupported)
+	}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	n := 0
+	for {
+		var rec Record
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			return n, nil
+		} else if err != nil {
+			return n, fmt.Errorf("record %d: %w", n+1, err)
+		}
+		switch {
+		case rec.Counter == RecordGlobal && rec.Word == "":
+		case rec.Counter == RecordWord && rec.Word != "":
+		default:
+			return n, fmt.Errorf("record %d: invalid counter %q with word %q", n+1, rec.Counter, rec.Word)
+		}
+		if err := e.Import(ctx, rec); err != nil {
+			return n, fmt.Errorf("record %d: %w", n+1, err)
+		}
+		n++
+	}
+}
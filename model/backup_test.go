@@ -0,0 +1,118 @@
+package model_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/stretchr/testify/require"
+)
+
+// exportingBackend keeps the imported records
+type exportingBackend struct {
+	fakeBackend
+	recs []model.Record
+	fail bool
+}
+
+func (e *exportingBackend) Healthy(context.Context) bool { return !e.fail }
+
+func (e *exportingBackend) Export(_ context.Context, fn func(model.Record) error) error {
+	for _, rec := range e.recs {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *exportingBackend) Import(_ context.Context, rec model.Record) error {
+	if e.fail {
+		return errors.New("down")
+	}
+	e.recs = append(e.recs, rec)
+	return nil
+}
+
+func (e *exportingBackend) Count(_ context.Context, rec model.Record) (uint64, error) {
+	for _, r := range e.recs {
+		if r.Counter == rec.Counter && r.Word == rec.Word {
+			return r.Count, nil
+		}
+	}
+	return 0, nil
+}
+
+func init() {
+	model.Register(model.Driver{
+		Name:      "fakeexporter",
+		NewConfig: func() any { return &fakeConfig{} },
+		Open: func(context.Context, any) (model.Backend, model.Counter, error) {
+			b := &exportingBackend{}
+			return b, b, nil
+		},
+	})
+	model.Register(model.Driver{
+		Name:      "fakefailing",
+		NewConfig: func() any { return &fakeConfig{} },
+		Open: func(context.Context, any) (model.Backend, model.Counter, error) {
+			b := &exportingBackend{fail: true}
+			return b, b, nil
+		},
+	})
+}
+
+func TestUnitExportImport(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	db := model.NewModel(ctx)
+	r.NoError(db.Open(ctx, "fakeexporter", func(any) error { return nil }))
+	b := db.Counter.(*exportingBackend) //nolint:errcheck // it's the backend of the driver
+	b.recs = []model.Record{
+		{Counter: model.RecordGlobal, Count: 3},
+		{Counter: model.RecordWord, Word: "hello", Count: 2},
+	}
+
+	var buf bytes.Buffer
+	n, err := db.Export(ctx, &buf)
+	r.NoError(err)
+	r.Equal(2, n)
+	r.Equal(`{"counter":"global","count":3}
+{"counter":"word","word":"hello","count":2}
+`, buf.String())
+
+	exported := b.recs
+	b.recs = nil
+	n, err = db.Import(ctx, &buf)
+	r.NoError(err)
+	r.Equal(2, n)
+	r.Equal(exported, b.recs)
+
+	for in, msg := range map[string]string{
+		`{"counter":"word","count":1}`:                  `record 1: invalid counter "word" with word ""`,
+		`{"counter":"global","word":"hello","count":1}`: `record 1: invalid counter "global" with word "hello"`,
+		`{"counter":"word","word":"a","count":1,"x":1}`: `record 1: json: unknown field "x"`,
+		"{\"counter\":\"global\",\"count\":1}\n{":       "record 2: unexpected EOF",
+	} {
+		_, err = db.Import(ctx, strings.NewReader(in))
+		r.EqualError(err, msg)
+	}
+}
+
+func TestUnitBackupUnsupported(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	db := model.NewModel(ctx)
+	r.NoError(db.Open(ctx, "fake", func(any) error { return nil }))
+
+	_, err := db.Backup(ctx, &bytes.Buffer{}, 0)
+	r.ErrorIs(err, errors.ErrUnsupported)
+	r.ErrorIs(db.Restore(ctx, &bytes.Buffer{}), errors.ErrUnsupported)
+	_, err = db.Export(ctx, &bytes.Buffer{})
+	r.ErrorIs(err, errors.ErrUnsupported)
+	_, err = db.Import(ctx, &bytes.Buffer{})
+	r.ErrorIs(err, errors.ErrUnsupported)
+}
@@ -0,0 +1,50 @@
+package badger
+
+import (
+	"context"
+	"io"
+
+	"github.com/jonmol/http-skeleton/model"
+)
+
+// maxPendingWrites is the number of pending writes while restoring, the default of the badger CLI
+const maxPendingWrites = 256
+
+// Backup streams the entries newer than since, 0 for all, in badger's backup format. It's safe while serving, but
+// the global counter is backed up at the end of its lease, so a restored counter skips up to 100 numbers
+func (db *DB) Backup(ctx context.Context, w io.Writer, since uint64) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	last, err := db.db.Backup(w, since)
+	if err != nil {
+		return 0, err
+	}
+	if last == 0 {
+		// nothing changed
+		return since, nil
+	}
+	// not last+1 like the badger docs say, the stream skips versions up to and including since
+	return last, nil
+}
+
+// Restore loads a backup, full or incremental. Badger wants no other transactions while loading
+func (db *DB) Restore(ctx context.Context, r io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.db.Load(r, maxPendingWrites)
+}
+
+// Export calls fn for every counter
+func (db *DB) Export(ctx context.Context, fn func(model.Record) error) error {
+	return db.Counter.Export(ctx, fn)
+}
+
+func (db *DB) Import(ctx context.Context, rec model.Record) error {
+	return db.Counter.Import(ctx, rec)
+}
+
+func (db *DB) Count(ctx context.Context, rec model.Record) (uint64, error) {
+	return db.Counter.Count(ctx, rec)
+}
@@ -3,10 +3,14 @@ package badger
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"sync/atomic"
 
 	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/options"
+	"github.com/jonmol/http-skeleton/model"
 	"github.com/jonmol/http-skeleton/model/badger/sillycounter"
 	"github.com/jonmol/http-skeleton/util/logging"
 )
@@ -26,6 +30,9 @@ type CounterModel interface {
 	Model
 	IncGlobal(context.Context) (uint64, error)
 	IncWord(context.Context, string) (uint64, error)
+	Export(context.Context, func(model.Record) error) error
+	Import(context.Context, model.Record) error
+	Count(context.Context, model.Record) (uint64, error)
 }
 
 type DB struct {
@@ -33,12 +40,25 @@ type DB struct {
 	l       *slog.Logger
 	Counter CounterModel
 	path    string
+	conf    Config
+	ns      model.Namespace
+	// sc is Counter, the migrations need more than CounterModel
+	sc *sillycounter.SillyCounter
+
+	// stopGC stops the maintenance loop and gcDone is closed when it has, see maintain
+	stopGC  context.CancelFunc
+	gcDone  chan struct{}
+	metrics atomic.Pointer[metrics]
 }
 
 // Close closes the database. The context is there to adher to the shutdown func
 // definition
 func (db *DB) Close(ctx context.Context) error {
+	if db.db == nil || db.db.IsClosed() {
+		return nil
+	}
 	db.l.Info("Closing the databases")
+	db.stopMaintenance()
 	if err := db.Counter.Close(ctx); err != nil {
 		db.l.Error("Failed to close Counter", logging.Err(err))
 	}
@@ -69,31 +89,125 @@ func (db *DB) Healthy(_ context.Context) bool {
 	return !db.db.IsClosed()
 }
 
-// TearDown doesn't need to cascade on a DB level since it can just Drop all and delete the
-// DB file
+// TearDown drops every key in the namespace, the counters and the schema version, and closes the database. The
+// directory is deleted as well unless other services have keys left in it
 func (db *DB) TearDown(_ context.Context) error {
-	err := db.db.DropAll()
-	err = errors.Join(err, db.db.Close())
-	err = errors.Join(err, os.RemoveAll(db.path))
+	db.stopMaintenance()
+	err := db.db.DropPrefix([]byte(db.ns.Prefix()))
+	empty, eerr := db.empty()
+	err = errors.Join(err, eerr, db.db.Close())
+	if err == nil && empty {
+		err = os.RemoveAll(db.path)
+	}
 	return err
 }
 
-func (db *DB) Open(_ context.Context) error {
-	dbOpts := badger.DefaultOptions(db.path).WithLoggingLevel(badger.INFO).WithLogger(NewLogger())
+// empty is true if there are no keys in the database
+func (db *DB) empty() (bool, error) {
+	empty := true
+	err := db.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{})
+		defer it.Close()
+		it.Rewind()
+		empty = !it.Valid()
+		return nil
+	})
+	return empty, err
+}
+
+// Open opens the database and starts the value log GC if Config.GCInterval is set, it stops when ctx is done or the
+// database is closed
+func (db *DB) Open(ctx context.Context) error {
+	ns, err := model.NewNamespace(db.conf.Prefix)
+	if err != nil {
+		return err
+	}
+	dbOpts, err := db.conf.options()
+	if err != nil {
+		return err
+	}
 	d, err := badger.Open(dbOpts)
 	if err != nil {
 		return err
 	}
-	db.db = d
-	db.Counter = sillycounter.New(d)
+	db.db, db.ns = d, ns
+	sc := sillycounter.New(d, ns)
+	db.Counter, db.sc = sc, sc
+	// there's no value log in memory
+	if db.conf.GCInterval > 0 && !db.conf.InMemory {
+		gcCtx, cancel := context.WithCancel(ctx)
+		db.stopGC, db.gcDone = cancel, make(chan struct{})
+		go db.maintain(gcCtx, sc.Writes)
+	}
 	return nil
 }
 
+// options returns the badger options of the config
+func (c Config) options() (badger.Options, error) {
+	opts := badger.DefaultOptions(c.Path)
+	if c.InMemory {
+		// badger refuses a directory in memory
+		opts = badger.DefaultOptions("").WithInMemory(true)
+	}
+	opts = opts.WithLoggingLevel(badger.INFO).
+		WithLogger(logging.NewPrintf(slog.Default().With(logging.Lib("badger")), slog.LevelInfo)).
+		WithSyncWrites(c.SyncWrites)
+	if c.MemTableMB > 0 {
+		opts = opts.WithMemTableSize(int64(c.MemTableMB) << 20)
+	}
+	if c.BlockCacheMB > 0 {
+		opts = opts.WithBlockCacheSize(int64(c.BlockCacheMB) << 20)
+	}
+	if c.IndexCacheMB > 0 {
+		opts = opts.WithIndexCacheSize(int64(c.IndexCacheMB) << 20)
+	}
+	if c.Compactors > 0 {
+		opts = opts.WithNumCompactors(c.Compactors)
+	}
+	switch c.Compression {
+	case "":
+	case "none":
+		opts = opts.WithCompression(options.None)
+	case "snappy":
+		opts = opts.WithCompression(options.Snappy)
+	case "zstd":
+		opts = opts.WithCompression(options.ZSTD)
+	default:
+		return opts, fmt.Errorf("badger: unknown compression %q, none|snappy|zstd", c.Compression)
+	}
+	key, err := c.encryptionKey()
+	if err != nil {
+		return opts, err
+	}
+	if key != nil {
+		// the data keys are rotated by badger, the key itself with RotateKey
+		opts = opts.WithEncryptionKey(key).WithEncryptionKeyRotationDuration(dataKeyRotation)
+		if c.IndexCacheMB == 0 {
+			// badger panics without one when encrypted
+			opts = opts.WithIndexCacheSize(DefaultEncryptedIndexCacheMB << 20)
+		}
+	}
+	if c.GCInterval > 0 && (c.GCDiscardPct < 0 || c.GCDiscardPct > 99) {
+		return opts, fmt.Errorf("badger: the GC discard percentage must be 1-99, or 0 for %d, got %d", DefaultGCDiscardPct, c.GCDiscardPct)
+	}
+	return opts, nil
+}
+
+// New returns a database in the directory p with the badger defaults and no value log GC
 func New(ctx context.Context, p string) *DB {
+	return NewWithConfig(ctx, Config{Path: p})
+}
+
+// NewWithConfig returns a database tuned by conf
+func NewWithConfig(ctx context.Context, conf Config) *DB {
 	l := slog.With(logging.Lib("model"))
 	m := DB{
 		l:    l,
-		path: p,
+		path: conf.Path,
+		conf: conf,
+	}
+	if conf.InMemory {
+		m.path = ""
 	}
 	m.autoclose(ctx)
 	return &m
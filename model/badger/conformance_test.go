@@ -0,0 +1,26 @@
+package badger_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/model/badger"
+	"github.com/jonmol/http-skeleton/model/modeltest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationConformance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	dir := t.TempDir()
+	modeltest.Run(t, func(t *testing.T) (model.Backend, model.Counter) {
+		// the same directory for every call in a test
+		db := badger.New(context.Background(), filepath.Join(dir, strings.ReplaceAll(t.Name(), "/", "_")))
+		require.NoError(t, db.Open(context.Background()))
+		return db, db.Counter
+	})
+}
@@ -0,0 +1,66 @@
+package badger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jonmol/http-skeleton/model"
+)
+
+// Config is read from the db-* flags. The zero values of the tuning options keep the badger defaults, a zero
+// GCInterval turns the value log GC off
+type Config struct {
+	// Path is the directory of the database
+	Path string `mapstructure:"db-addr"`
+	// Prefix of the keys, model.DefaultPrefix if empty, see model.Namespace
+	Prefix string `mapstructure:"db-prefix"`
+	// InMemory keeps everything in memory and nothing on disk, Path is then ignored
+	InMemory bool `mapstructure:"db-badger-in-memory"`
+	// SyncWrites syncs every write to disk, slower but nothing is lost on a crash
+	SyncWrites bool `mapstructure:"db-badger-sync-writes"`
+	// MemTableMB is the size of a memtable in MB
+	MemTableMB int `mapstructure:"db-badger-memtable-mb"`
+	// BlockCacheMB is the size of the block cache in MB, it's needed with compression
+	BlockCacheMB int `mapstructure:"db-badger-block-cache-mb"`
+	// IndexCacheMB is the size of the index cache in MB, 0 keeps all indices in memory. An encrypted database
+	// must have one, DefaultEncryptedIndexCacheMB is used then
+	IndexCacheMB int `mapstructure:"db-badger-index-cache-mb"`
+	// Compression of the tables, none|snappy|zstd
+	Compression string `mapstructure:"db-badger-compression"`
+	// Compactors is the number of goroutines compacting the LSM tree, at least 2
+	Compactors int `mapstructure:"db-badger-compactors"`
+	// GCInterval is how often the value log GC runs
+	GCInterval time.Duration `mapstructure:"db-badger-gc-interval"`
+	// GCDiscardPct is how much of a value log file must be garbage for it to be rewritten, 1-99. Like the other zero
+	// values 0 keeps the default, DefaultGCDiscardPct
+	GCDiscardPct int `mapstructure:"db-badger-gc-discard-pct"`
+	// GCPauseWrites skips the GC when there were more writes per second than this since the last run, 0 to never
+	// skip
+	GCPauseWrites int `mapstructure:"db-badger-gc-pause-writes"`
+	// KeyFile is a file with the encryption key, see LoadKey
+	KeyFile string `mapstructure:"db-badger-encryption-key-file"`
+	// KeyEnv is the environment variable with the encryption key, see LoadKey
+	KeyEnv string `mapstructure:"db-badger-encryption-key-env"`
+	// EncryptionKey is used instead of KeyFile and KeyEnv when set
+	EncryptionKey []byte `mapstructure:"-"`
+}
+
+func init() {
+	model.Register(model.Driver{
+		Name:         "badger",
+		Capabilities: model.Capabilities{Transactions: true, TTL: true, Scans: true},
+		NewConfig:    func() any { return &Config{} },
+		Open: func(ctx context.Context, conf any) (model.Backend, model.Counter, error) {
+			c, ok := conf.(*Config)
+			if !ok {
+				return nil, nil, fmt.Errorf("badger: unexpected config %T", conf)
+			}
+			db := NewWithConfig(ctx, *c)
+			if err := db.Open(ctx); err != nil {
+				return nil, nil, err
+			}
+			return db, db.Counter, nil
+		},
+	})
+}
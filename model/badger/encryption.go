@@ -0,0 +1,100 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// dataKeyRotation is how often badger replaces the data keys it encrypts with, the badger default
+const dataKeyRotation = 10 * 24 * time.Hour
+
+// DefaultEncryptedIndexCacheMB is the index cache of an encrypted database when Config.IndexCacheMB is 0, badger
+// needs one to keep the decrypted indices
+const DefaultEncryptedIndexCacheMB = 100
+
+// LoadKey reads an encryption key from the file, or from the environment variable env. The key is used as it is
+// and must be 16, 24 or 32 bytes for AES-128, AES-192 or AES-256. Without file and env there's no key, nil is
+// returned
+func LoadKey(file, env string) ([]byte, error) {
+	var key []byte
+	switch {
+	case file != "" && env != "":
+		return nil, errors.New("badger: the encryption key can't come from both a file and an environment variable")
+	case file != "":
+		k, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("badger: failed to read the encryption key: %w", err)
+		}
+		key = k
+	case env != "":
+		k, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, fmt.Errorf("badger: the encryption key variable %s isn't set", env)
+		}
+		key = []byte(k)
+	default:
+		return nil, nil
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("badger: the encryption key is %d bytes, it must be 16, 24 or 32", len(key))
+	}
+}
+
+// encryptionKey returns the key of the config, EncryptionKey or else loaded from the key file or variable
+func (c Config) encryptionKey() ([]byte, error) {
+	if len(c.EncryptionKey) > 0 {
+		return c.EncryptionKey, nil
+	}
+	return LoadKey(c.KeyFile, c.KeyEnv)
+}
+
+// RotateKey replaces the encryption key of the database of conf with newKey. The data is encrypted by data keys,
+// which are in turn encrypted by the encryption key, so only the data keys are encrypted again and it's quick
+// whatever the size of the database. A database without a key is encrypted from now on, what's already written is
+// encrypted when it's compacted or garbage collected. The database must not be open, badger holds a lock on the
+// directory
+func RotateKey(ctx context.Context, conf Config, newKey []byte) error {
+	if conf.InMemory {
+		return errors.New("badger: a database in memory has no key to rotate")
+	}
+	switch len(newKey) {
+	case 16, 24, 32:
+	default:
+		return fmt.Errorf("badger: the new encryption key is %d bytes, it must be 16, 24 or 32", len(newKey))
+	}
+	oldKey, err := conf.encryptionKey()
+	if err != nil {
+		return err
+	}
+
+	// opening it checks the old key and that nothing else has it open
+	db := NewWithConfig(ctx, Config{Path: conf.Path, EncryptionKey: oldKey})
+	if err := db.Open(ctx); err != nil {
+		return fmt.Errorf("badger: failed to open the database with the current key: %w", err)
+	}
+	if err := db.Close(ctx); err != nil {
+		return err
+	}
+
+	opt := badger.KeyRegistryOptions{
+		Dir:                           conf.Path,
+		EncryptionKey:                 oldKey,
+		EncryptionKeyRotationDuration: dataKeyRotation,
+	}
+	kr, err := badger.OpenKeyRegistry(opt)
+	if err != nil {
+		return err
+	}
+	// WriteKeyRegistry writes a new file and renames it, the open one is only read
+	defer kr.Close()
+	opt.EncryptionKey = newKey
+	return badger.WriteKeyRegistry(kr, opt)
+}
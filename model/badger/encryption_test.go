@@ -0,0 +1,81 @@
+package badger_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jonmol/http-skeleton/model/badger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitLoadKey(t *testing.T) {
+	r := require.New(t)
+	file := filepath.Join(t.TempDir(), "key")
+	r.NoError(os.WriteFile(file, []byte("0123456789abcdef"), 0o600))
+	t.Setenv("TEST_BADGER_KEY", "0123456789abcdef0123456789abcdef")
+
+	key, err := badger.LoadKey(file, "")
+	r.NoError(err)
+	r.Equal([]byte("0123456789abcdef"), key)
+	key, err = badger.LoadKey("", "TEST_BADGER_KEY")
+	r.NoError(err)
+	r.Len(key, 32)
+	key, err = badger.LoadKey("", "")
+	r.NoError(err)
+	r.Nil(key)
+
+	_, err = badger.LoadKey(file, "TEST_BADGER_KEY")
+	r.ErrorContains(err, "both")
+	_, err = badger.LoadKey("", "TEST_BADGER_KEY_MISSING")
+	r.ErrorContains(err, "isn't set")
+	_, err = badger.LoadKey(filepath.Join(t.TempDir(), "nope"), "")
+	r.ErrorIs(err, os.ErrNotExist)
+	t.Setenv("TEST_BADGER_KEY", "short")
+	_, err = badger.LoadKey("", "TEST_BADGER_KEY")
+	r.ErrorContains(err, "16, 24 or 32")
+}
+
+func TestIntegrationEncryption(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	r := require.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	oldKey, newKey := []byte("0123456789abcdef"), []byte("fedcba9876543210fedcba9876543210")
+
+	// a plain database is encrypted from the first rotation
+	inc(t, badger.Config{Path: dir}, 1)
+	r.NoError(badger.RotateKey(ctx, badger.Config{Path: dir}, oldKey))
+	r.Error(badger.NewWithConfig(ctx, badger.Config{Path: dir}).Open(ctx), "the key is needed")
+	inc(t, badger.Config{Path: dir, EncryptionKey: oldKey}, 2)
+
+	wrong, current := badger.Config{Path: dir, EncryptionKey: newKey}, badger.Config{Path: dir, EncryptionKey: oldKey}
+	r.ErrorContains(badger.RotateKey(ctx, wrong, oldKey), "current key")
+	r.ErrorContains(badger.RotateKey(ctx, current, []byte("short")), "16, 24 or 32")
+	r.NoError(badger.RotateKey(ctx, badger.Config{Path: dir, EncryptionKey: oldKey}, newKey))
+	r.Error(badger.NewWithConfig(ctx, badger.Config{Path: dir, EncryptionKey: oldKey}).Open(ctx), "the old key is replaced")
+	inc(t, badger.Config{Path: dir, EncryptionKey: newKey}, 3)
+
+	// it's locked while open
+	db := badger.NewWithConfig(ctx, badger.Config{Path: dir, EncryptionKey: newKey})
+	r.NoError(db.Open(ctx))
+	r.Error(badger.RotateKey(ctx, badger.Config{Path: dir, EncryptionKey: newKey}, oldKey))
+	r.NoError(db.Close(ctx))
+}
+
+// inc opens the database, checks that the next count of a word is want and closes it
+func inc(t *testing.T, conf badger.Config, want uint64) {
+	t.Helper()
+	r := require.New(t)
+	ctx := context.Background()
+	db := badger.NewWithConfig(ctx, conf)
+	r.NoError(db.Open(ctx))
+	r.NoError(db.EnsureDB(ctx))
+	n, err := db.Counter.IncWord(ctx, "secret")
+	r.NoError(err)
+	r.Equal(want, n)
+	r.NoError(db.Close(ctx))
+}
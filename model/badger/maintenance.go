@@ -0,0 +1,151 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jonmol/http-skeleton/instrumentation"
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// DefaultGCDiscardPct is used when Config.GCDiscardPct is 0, it's what badger recommends
+const DefaultGCDiscardPct = 50
+
+// Results of a value log GC run in the metrics
+const (
+	gcRewrote = "rewrote"
+	gcClean   = "clean"
+	gcSkipped = "skipped"
+	gcFailed  = "failed"
+)
+
+// metrics are nil until Instrument is called
+type metrics struct {
+	gcRuns     *prometheus.CounterVec
+	gcRewrites prometheus.Counter
+}
+
+// Instrument adds the metrics of the database to reg:
+// <appName>_badger_gc_runs_total{result} value log GC runs, rewrote|clean|skipped (busy)|failed
+// <appName>_badger_gc_rewrites_total value log files rewritten by the GC
+// and the internal metrics badger publishes with expvar, like <appName>_badger_lsm_size_bytes{dir},
+// <appName>_badger_vlog_size_bytes{dir} and <appName>_badger_puts_total. They are global, so with two badger
+// databases in one process they are the sum of both
+func (db *DB) Instrument(appName string, reg prometheus.Registerer) {
+	if reg == nil {
+		return
+	}
+	db.metrics.Store(&metrics{
+		gcRuns: instrumentation.Register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: appName + "_badger_gc_runs_total",
+			Help: "Value log GC runs by result: rewrote, clean, skipped because of load or failed",
+		}, []string{"result"})),
+		gcRewrites: instrumentation.Register(reg, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: appName + "_badger_gc_rewrites_total",
+			Help: "Value log files rewritten by the GC",
+		})),
+	})
+	instrumentation.Register(reg, expvarCollector(appName))
+}
+
+// expvarCollector exports the expvar metrics of badger, see github.com/dgraph-io/badger/v4/y/metrics.go
+func expvarCollector(appName string) prometheus.Collector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(appName+"_badger_"+name, help, labels, nil)
+	}
+	return collectors.NewExpvarCollector(map[string]*prometheus.Desc{
+		"badger_size_bytes_lsm":             desc("lsm_size_bytes", "Size of the LSM tree by directory", "dir"),
+		"badger_size_bytes_vlog":            desc("vlog_size_bytes", "Size of the value log by directory", "dir"),
+		"badger_write_pending_num_memtable": desc("pending_writes", "Writes waiting for the memtable by directory", "dir"),
+		"badger_compaction_current_num_lsm": desc("compacting_tables", "Tables being compacted"),
+		"badger_write_bytes_compaction":     desc("compaction_written_bytes_total", "Bytes written by compactions by level", "level"),
+		"badger_write_bytes_l0":             desc("l0_written_bytes_total", "Bytes written to level 0 of the LSM tree"),
+		"badger_read_bytes_lsm":             desc("lsm_read_bytes_total", "Bytes read from the LSM tree"),
+		"badger_read_bytes_vlog":            desc("vlog_read_bytes_total", "Bytes read from the value log"),
+		"badger_write_bytes_vlog":           desc("vlog_written_bytes_total", "Bytes written to the value log"),
+		"badger_get_num_user":               desc("gets_total", "Gets by the application"),
+		"badger_get_with_result_num_user":   desc("gets_found_total", "Gets by the application that found the key"),
+		"badger_put_num_user":               desc("puts_total", "Puts by the application"),
+		"badger_write_bytes_user":           desc("written_bytes_total", "Bytes written by the application"),
+		"badger_iterator_num_user":          desc("iterators_total", "Iterators created by the application"),
+		"badger_hit_num_lsm_bloom_filter":   desc("bloom_hits_total", "Bloom filter hits by level", "level"),
+		"badger_get_num_memtable":           desc("memtable_gets_total", "Gets served by the memtable"),
+	})
+}
+
+// maintain runs the value log GC every Config.GCInterval until ctx is done. Badger never removes old values from
+// the value log by itself, without the GC it grows forever. A run is skipped when there were more than
+// Config.GCPauseWrites writes per second since the last one, writes is the number of writes so far
+func (db *DB) maintain(ctx context.Context, writes func() uint64) {
+	defer close(db.gcDone)
+	pct := db.conf.GCDiscardPct
+	if pct == 0 {
+		pct = DefaultGCDiscardPct
+	}
+	t := time.NewTicker(db.conf.GCInterval)
+	defer t.Stop()
+	last, lastAt := writes(), time.Now()
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-t.C:
+		}
+		w := writes()
+		rate := float64(w-last) / now.Sub(lastAt).Seconds()
+		last, lastAt = w, now
+		if db.conf.GCPauseWrites > 0 && rate > float64(db.conf.GCPauseWrites) {
+			db.l.Debug("Skipping the value log GC under load", slog.Float64("writesPerSecond", rate))
+			db.gcResult(gcSkipped, 0)
+			continue
+		}
+		rewrites, err := db.RunGC(ctx, float64(pct)/100)
+		switch {
+		case err != nil:
+			db.l.Error("Value log GC failed", logging.Err(err))
+			db.gcResult(gcFailed, rewrites)
+		case rewrites > 0:
+			db.l.Info("Value log GC done", slog.Int("rewrites", rewrites))
+			db.gcResult(gcRewrote, rewrites)
+		default:
+			db.gcResult(gcClean, 0)
+		}
+	}
+}
+
+// RunGC runs the value log GC until there's nothing left to rewrite or ctx is done, and returns the number of value
+// log files rewritten. A file is rewritten if at least discardRatio of it is garbage
+func (db *DB) RunGC(ctx context.Context, discardRatio float64) (int, error) {
+	rewrites := 0
+	for ctx.Err() == nil {
+		err := db.db.RunValueLogGC(discardRatio)
+		if errors.Is(err, badger.ErrNoRewrite) {
+			return rewrites, nil
+		} else if err != nil {
+			return rewrites, err
+		}
+		rewrites++
+	}
+	return rewrites, nil
+}
+
+func (db *DB) gcResult(result string, rewrites int) {
+	if m := db.metrics.Load(); m != nil {
+		m.gcRuns.WithLabelValues(result).Inc()
+		m.gcRewrites.Add(float64(rewrites))
+	}
+}
+
+// stopMaintenance stops the maintenance loop and waits for a running GC to finish
+func (db *DB) stopMaintenance() {
+	if db.stopGC != nil {
+		db.stopGC()
+		<-db.gcDone
+	}
+}
@@ -0,0 +1,134 @@
+package badger_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonmol/http-skeleton/model/badger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationConfig(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	r := require.New(t)
+	ctx := context.Background()
+
+	db := badger.NewWithConfig(ctx, badger.Config{Path: t.TempDir(), Compression: "lz4"})
+	r.ErrorContains(db.Open(ctx), "unknown compression")
+	db = badger.NewWithConfig(ctx, badger.Config{Path: t.TempDir(), GCInterval: time.Minute, GCDiscardPct: 100})
+	r.ErrorContains(db.Open(ctx), "1-99")
+
+	dir := filepath.Join(t.TempDir(), "db")
+	db = badger.NewWithConfig(ctx, badger.Config{Path: dir, Compression: "zstd", MemTableMB: 16, BlockCacheMB: 16,
+		IndexCacheMB: 16, Compactors: 2, SyncWrites: true})
+	r.NoError(db.Open(ctx))
+	r.NoError(db.EnsureDB(ctx))
+	n, err := db.Counter.IncWord(ctx, "tuned")
+	r.NoError(err)
+	r.Equal(uint64(1), n)
+	r.NoError(db.Close(ctx))
+	r.DirExists(dir)
+}
+
+func TestIntegrationInMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	r := require.New(t)
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "db")
+
+	// the GC is turned off in memory, it would fail on every run
+	db := badger.NewWithConfig(ctx, badger.Config{Path: dir, InMemory: true, GCInterval: time.Millisecond})
+	r.NoError(db.Open(ctx))
+	r.NoError(db.EnsureDB(ctx))
+	n, err := db.Counter.IncGlobal(ctx)
+	r.NoError(err)
+	r.Equal(uint64(1), n)
+	r.NoError(db.Close(ctx))
+	_, err = os.Stat(dir)
+	r.ErrorIs(err, os.ErrNotExist)
+}
+
+func TestIntegrationGC(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	r := require.New(t)
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+
+	db := badger.NewWithConfig(ctx, badger.Config{Path: t.TempDir(), GCInterval: 10 * time.Millisecond})
+	r.NoError(db.Open(ctx))
+	db.Instrument("test", reg)
+	r.NoError(db.EnsureDB(ctx))
+	_, err := db.Counter.IncWord(ctx, "gc")
+	r.NoError(err)
+
+	r.Eventually(func() bool { return gcRuns(t, reg, "clean") >= 2 }, 5*time.Second, 10*time.Millisecond)
+	r.Zero(gcRuns(t, reg, "failed"))
+	r.NoError(db.Close(ctx))
+
+	families, err := reg.Gather()
+	r.NoError(err)
+	names := map[string]bool{}
+	for _, f := range families {
+		names[f.GetName()] = true
+	}
+	r.True(names["test_badger_puts_total"], "badger's expvar metrics are exported")
+	r.True(names["test_badger_lsm_size_bytes"])
+}
+
+func TestIntegrationGCPausedUnderLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	r := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	reg := prometheus.NewRegistry()
+
+	db := badger.NewWithConfig(ctx, badger.Config{Path: t.TempDir(), GCInterval: 50 * time.Millisecond, GCPauseWrites: 1})
+	r.NoError(db.Open(ctx))
+	db.Instrument("test", reg)
+	r.NoError(db.EnsureDB(ctx))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			_, _ = db.Counter.IncGlobal(ctx)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	r.Eventually(func() bool { return gcRuns(t, reg, "skipped") >= 2 }, 5*time.Second, 10*time.Millisecond)
+	r.Zero(gcRuns(t, reg, "clean"))
+	cancel()
+	wg.Wait()
+	r.NoError(db.Close(context.Background()))
+}
+
+// gcRuns returns the GC runs with the result
+func gcRuns(t *testing.T, reg *prometheus.Registry, result string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != "test_badger_gc_runs_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			if m.GetLabel()[0].GetValue() == result {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jonmol/http-skeleton/model"
+)
+
+// legacySchemaVersion is where the schema version was kept before the keys were namespaced
+var legacySchemaVersion = []byte("_meta:schemaVersion")
+
+// migrating is per process, badger locks the directory so no other process can have it open
+var migrating sync.Mutex
+
+// Migrations returns the migrations of the badger database
+func (db *DB) Migrations() []model.Migration {
+	return []model.Migration{
+		{Version: 1, Name: "baseline", Up: noop, Down: noop},
+		{Version: 2, Name: "namespaced keys", Up: db.namespaceUp, Down: db.sc.ToLegacy},
+	}
+}
+
+func (db *DB) SchemaVersion(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var v int
+	err := db.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(db.schemaVersionKey())
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			v, err = strconv.Atoi(string(val))
+			return err
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("badger: failed to read the schema version: %w", err)
+	}
+	return v, nil
+}
+
+func (db *DB) SetSchemaVersion(ctx context.Context, version int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(db.schemaVersionKey(), []byte(strconv.Itoa(version)))
+	})
+}
+
+func (db *DB) Lock(_ context.Context) (func(context.Context) error, error) {
+	if !migrating.TryLock() {
+		return nil, model.ErrLocked
+	}
+	return func(context.Context) error {
+		migrating.Unlock()
+		return nil
+	}, nil
+}
+
+func (db *DB) schemaVersionKey() []byte {
+	return []byte(db.ns.Meta("schemaVersion"))
+}
+
+// namespaceUp moves the counters into the namespace, see sillycounter.FromLegacy, and drops the old schema version
+func (db *DB) namespaceUp(ctx context.Context) error {
+	if err := db.sc.FromLegacy(ctx); err != nil {
+		return err
+	}
+	return db.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(legacySchemaVersion)
+	})
+}
+
+func noop(context.Context) error {
+	return nil
+}
@@ -0,0 +1,118 @@
+package badger_test
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	bdg "github.com/dgraph-io/badger/v4"
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/model/badger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationNamespaceMigration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	r := require.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	// the old layout, a service that has migrated already and a key that isn't a counter
+	r.NoError(setKeys(dir, map[string][]byte{
+		"aglobC":                 count(7),
+		"ahello":                 count(3),
+		"afoo:c:x":               count(2),
+		"_meta:schemaVersion":    []byte("1"),
+		"api:c:w:other":          count(5),
+		"api:meta:schemaVersion": []byte("2"),
+		"auth_token":             []byte("secret"),
+	}))
+	open := func() *model.DB {
+		db := model.NewModel(ctx)
+		r.NoError(db.Open(ctx, "badger", func(conf any) error {
+			*conf.(*badger.Config) = badger.Config{Path: dir, Prefix: "svc"}
+			return nil
+		}))
+		r.NoError(db.EnsureDB(ctx))
+		return db
+	}
+
+	db := open()
+	n, err := db.Counter.IncGlobal(ctx)
+	r.NoError(err)
+	r.EqualValues(8, n)
+	n, err = db.Counter.IncWord(ctx, "hello")
+	r.NoError(err)
+	r.EqualValues(4, n)
+	steps, err := db.Migrate(ctx, 1, false)
+	r.NoError(err)
+	r.Len(steps, 1)
+	r.NoError(db.Close(ctx))
+	keys, err := getKeys(dir)
+	r.NoError(err)
+	r.Equal(count(8), keys["aglobC"])
+	r.Equal(count(4), keys["ahello"])
+	r.Equal(count(2), keys["afoo:c:x"], "a word shaped like a namespaced key should be moved back and forth")
+	r.Equal([]byte("secret"), keys["auth_token"])
+	r.Contains(keys, "api:c:w:other")
+	r.Contains(keys, "svc:meta:schemaVersion")
+	r.Len(keys, 7)
+
+	// globC can't go back
+	db = open()
+	_, err = db.Counter.IncWord(ctx, "globC")
+	r.NoError(err)
+	_, err = db.Migrate(ctx, 1, false)
+	r.ErrorContains(err, "globC")
+
+	// the other service keeps its keys, and the directory
+	r.NoError(db.TearDown(ctx))
+	keys, err = getKeys(dir)
+	r.NoError(err)
+	r.Equal(map[string][]byte{
+		"api:c:w:other": count(5), "api:meta:schemaVersion": []byte("2"), "auth_token": []byte("secret"),
+	}, keys)
+}
+
+func count(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
+
+func setKeys(dir string, kv map[string][]byte) error {
+	db, err := bdg.Open(bdg.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(txn *bdg.Txn) error {
+		for k, v := range kv {
+			if err := txn.Set([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func getKeys(dir string) (map[string][]byte, error) {
+	db, err := bdg.Open(bdg.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	kv := map[string][]byte{}
+	err = db.View(func(txn *bdg.Txn) error {
+		it := txn.NewIterator(bdg.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			kv[string(it.Item().KeyCopy(nil))] = v
+		}
+		return nil
+	})
+	return kv, err
+}
@@ -0,0 +1,145 @@
+package sillycounter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jonmol/http-skeleton/model"
+)
+
+// Export calls fn for the global counter and then every word. The global sequence is released to read it, that
+// stores the last number handed out instead of the end of the lease, and is taken again before the words are read
+func (s *SillyCounter) Export(ctx context.Context, fn func(model.Record) error) error {
+	var global uint64
+	err := s.withReleasedSequence(func() error {
+		return s.db.View(func(txn *badger.Txn) error {
+			var err error
+			global, err = readCount(txn, s.global)
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if err := fn(model.Record{Counter: model.RecordGlobal, Count: global}); err != nil {
+		return err
+	}
+
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: s.words, PrefetchValues: true, PrefetchSize: 100})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			k := it.Item().KeyCopy(nil)
+			var n uint64
+			err := it.Item().Value(func(v []byte) error {
+				var err error
+				n, err = decodeCount(k, v)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if err := fn(model.Record{Counter: model.RecordWord, Word: string(k[len(s.words):]), Count: n}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Import sets the counter of rec, for the global counter the sequence is released and taken again
+func (s *SillyCounter) Import(ctx context.Context, rec model.Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	key := s.recordKey(rec)
+	set := func() error {
+		return s.db.Update(func(txn *badger.Txn) error {
+			return txn.Set(key, encodeCount(rec.Count))
+		})
+	}
+	if rec.Counter == model.RecordGlobal {
+		return s.withReleasedSequence(set)
+	}
+	return set()
+}
+
+// Count returns the counter of rec, for the global counter the sequence is released and taken again like in Export
+func (s *SillyCounter) Count(ctx context.Context, rec model.Record) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var n uint64
+	read := func() error {
+		return s.db.View(func(txn *badger.Txn) error {
+			var err error
+			n, err = readCount(txn, s.recordKey(rec))
+			return err
+		})
+	}
+	var err error
+	if rec.Counter == model.RecordGlobal {
+		err = s.withReleasedSequence(read)
+	} else {
+		err = read()
+	}
+	return n, err
+}
+
+func (s *SillyCounter) recordKey(rec model.Record) []byte {
+	return []byte(s.ns.RecordKey(rec))
+}
+
+// withReleasedSequence releases the global sequence, if taken, while fn runs and takes it again afterwards
+func (s *SillyCounter) withReleasedSequence(fn func() error) error {
+	s.gcMut.Lock()
+	defer s.gcMut.Unlock()
+	if s.gc == nil {
+		return fn()
+	}
+	if err := s.gc.Release(); err != nil {
+		return err
+	}
+	err := fn()
+	seq, serr := s.db.GetSequence(s.global, 100)
+	if serr != nil {
+		// nothing can be counted without it, better to fail loudly than to hand out numbers twice
+		s.gc = nil
+		return errors.Join(err, fmt.Errorf("failed to take the global sequence again: %w", serr))
+	}
+	s.gc = seq
+	return err
+}
+
+// readCount returns the stored count of key, 0 if it doesn't exist
+func readCount(txn *badger.Txn, key []byte) (uint64, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var n uint64
+	err = item.Value(func(v []byte) error {
+		n, err = decodeCount(key, v)
+		return err
+	})
+	return n, err
+}
+
+func decodeCount(key, v []byte) (uint64, error) {
+	if len(v) != 8 {
+		return 0, fmt.Errorf("corrupt counter %q", key)
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+func encodeCount(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
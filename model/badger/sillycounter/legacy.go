@@ -0,0 +1,146 @@
+package sillycounter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jonmol/http-skeleton/model"
+)
+
+// The keys before they were namespaced, "a" followed by the word or "aglobC" for the global sequence. A word "globC"
+// was the global counter then
+var (
+	legacyPrefix = []byte("a")
+	legacyGlobal = []byte("aglobC")
+)
+
+// FromLegacy moves the counters from the keys of the old layout into the namespace. Only keys holding a count are
+// moved, others are logged and left alone, and so are the keys of the other namespaces in use, see
+// model.ParseNamespace. Badger locks its directory and the migrations of a process take turns, so the counters go to
+// the first namespace migrating and the others find nothing left to move
+func (s *SillyCounter) FromLegacy(ctx context.Context) error {
+	err := s.move(ctx, legacyPrefix, func(txn *badger.Txn, k, v []byte) ([]byte, bool, error) {
+		if bytes.HasPrefix(k, []byte(s.ns.Prefix())) || bytes.Equal(k, legacyGlobal) {
+			return nil, false, nil
+		}
+		if ns, ok := model.ParseNamespace(string(k)); ok {
+			if _, err := txn.Get([]byte(ns.Meta("schemaVersion"))); err == nil {
+				return nil, false, nil
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return nil, false, err
+			}
+		}
+		if _, err := decodeCount(k, v); err != nil {
+			s.l.Warn("Left a key of the old layout that isn't a counter", slog.String("key", string(k)))
+			return nil, false, nil
+		}
+		return []byte(s.ns.Word(string(k[len(legacyPrefix):]))), true, nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.withReleasedSequence(func() error {
+		return s.db.Update(func(txn *badger.Txn) error {
+			if _, err := txn.Get(legacyGlobal); errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			n, err := readCount(txn, legacyGlobal)
+			if err != nil {
+				return err
+			}
+			if err := txn.Set(s.global, encodeCount(n)); err != nil {
+				return err
+			}
+			return txn.Delete(legacyGlobal)
+		})
+	})
+}
+
+// ToLegacy moves the counters back to the old layout, it fails if there's a word the old layout can't tell from the
+// global counter. The global sequence is released for good, EnsureDB takes it again
+func (s *SillyCounter) ToLegacy(ctx context.Context) error {
+	clash := legacyGlobal[len(legacyPrefix):]
+	err := s.db.View(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte(s.ns.Word(string(clash)))); err == nil {
+			return fmt.Errorf("badger: the word %q would be the global counter in the old layout", clash)
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = s.move(ctx, s.words, func(_ *badger.Txn, k, _ []byte) ([]byte, bool, error) {
+		return append(bytes.Clone(legacyPrefix), k[len(s.words):]...), true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.gcMut.Lock()
+	defer s.gcMut.Unlock()
+	if s.gc != nil {
+		if err := s.gc.Release(); err != nil {
+			return err
+		}
+		s.gc = nil
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		n, err := readCount(txn, s.global)
+		if err != nil {
+			return err
+		}
+		if err := txn.Set(legacyGlobal, encodeCount(n)); err != nil {
+			return err
+		}
+		return txn.Delete(s.global)
+	})
+}
+
+// move moves the keys starting with prefix to the key returned by to, the ones it returns false for are kept. to gets
+// the transaction of the scan to look up other keys
+func (s *SillyCounter) move(ctx context.Context, prefix []byte,
+	to func(txn *badger.Txn, k, v []byte) ([]byte, bool, error),
+) error {
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true, PrefetchSize: 100})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			k := it.Item().KeyCopy(nil)
+			v, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			nk, ok, err := to(txn, k, v)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if err := wb.Set(nk, v); err != nil {
+				return err
+			}
+			if err := wb.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return wb.Flush()
+}
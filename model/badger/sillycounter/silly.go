@@ -2,33 +2,53 @@ package sillycounter
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 
 	"github.com/dgraph-io/badger/v4"
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/server/util/myctx"
 	"github.com/jonmol/http-skeleton/util/logging"
 )
 
-var (
-	prefix        = []byte("a")
-	globalCounter = append(prefix, []byte("globC")...)
-)
-
 type SillyCounter struct {
 	db *badger.DB
-	gc *badger.Sequence
+	ns model.Namespace
 	l  *slog.Logger
+	// global and words are the key of the global counter and the start of the word keys
+	global, words []byte
+
+	// gcMut is write locked while the global sequence is replaced, see Export and Import
+	gcMut sync.RWMutex
+	gc    *badger.Sequence
+
+	// writes counts the increments, the value log GC is paused while it grows fast
+	writes atomic.Uint64
 }
 
-func New(db *badger.DB) *SillyCounter {
+// New returns the counter with the keys in ns
+func New(db *badger.DB, ns model.Namespace) *SillyCounter {
 	return &SillyCounter{
-		db: db,
-		l:  slog.With(logging.Lib("badger.sillycounter")),
+		db:     db,
+		ns:     ns,
+		l:      slog.With(logging.Lib("badger.sillycounter")),
+		global: []byte(ns.Global()),
+		words:  []byte(ns.WordPrefix()),
 	}
 }
 
+// EnsureDB gets the global sequence, only once since every new sequence leases a new range of numbers
 func (s *SillyCounter) EnsureDB(_ context.Context) error {
-	seq, err := s.db.GetSequence(globalCounter, 100)
+	s.gcMut.Lock()
+	defer s.gcMut.Unlock()
+	if s.gc != nil {
+		return nil
+	}
+	seq, err := s.db.GetSequence(s.global, 100)
 	if err != nil {
 		return err
 	}
@@ -37,37 +57,93 @@ func (s *SillyCounter) EnsureDB(_ context.Context) error {
 	return nil
 }
 
-// TearDown deletes all keys in the db with our prefix
+// TearDown deletes the counters of the namespace
 func (s *SillyCounter) TearDown(_ context.Context) error {
-	return s.db.DropPrefix(prefix)
+	return s.db.DropPrefix([]byte(s.ns.Counters()))
 }
 
 func (s *SillyCounter) Close(_ context.Context) error {
+	s.gcMut.Lock()
+	defer s.gcMut.Unlock()
 	if s.gc != nil {
 		return s.gc.Release()
 	}
 	return nil
 }
 
-func (s *SillyCounter) IncGlobal(_ context.Context) (uint64, error) {
+func (s *SillyCounter) IncGlobal(ctx context.Context) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.gcMut.RLock()
 	if s.gc == nil {
+		s.gcMut.RUnlock()
 		return 0, errors.New("global counter nil")
 	}
-	return humanize(s.gc.Next())
+	res, err := humanize(s.gc.Next())
+	s.gcMut.RUnlock()
+	s.writes.Add(1)
+	s.logger(ctx).Debug("Increased the global counter", slog.Uint64("count", res))
+	return res, err
+}
+
+// IncWord increases the counter in a transaction, a sequence per call would hand out the same number to concurrent
+// calls. The value is stored the same way as by a released sequence, the last number handed out
+func (s *SillyCounter) IncWord(ctx context.Context, w string) (uint64, error) {
+	key := []byte(s.ns.Word(w))
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		var res uint64
+		err := s.db.Update(func(txn *badger.Txn) error {
+			item, err := txn.Get(key)
+			if err == nil {
+				err = item.Value(func(v []byte) error {
+					if len(v) != 8 {
+						return fmt.Errorf("corrupt counter %q", w)
+					}
+					res = binary.BigEndian.Uint64(v)
+					return nil
+				})
+			} else if errors.Is(err, badger.ErrKeyNotFound) {
+				err = nil
+			}
+			if err != nil {
+				return err
+			}
+			res++
+			return txn.Set(key, encodeCount(res))
+		})
+		if errors.Is(err, badger.ErrConflict) {
+			continue // another call increased it first
+		} else if err != nil {
+			return 0, err
+		}
+		s.writes.Add(1)
+		s.logger(ctx).Debug("Increased a word counter", slog.Uint64("count", res))
+		return res, nil
+	}
 }
 
-func (s *SillyCounter) IncWord(_ context.Context, w string) (uint64, error) {
-	seq, err := s.db.GetSequence(append(prefix, []byte(w)...), 2)
-	if err != nil {
-		return 0, err
+// Writes returns how many times the counters have been increased since New
+func (s *SillyCounter) Writes() uint64 {
+	return s.writes.Load()
+}
+
+// logger returns the request logger if there is one, so a request with debug logging turned on is followed
+// all the way down here
+func (s *SillyCounter) logger(ctx context.Context) *slog.Logger {
+	if l := myctx.LoggerOr(ctx, nil); l != nil {
+		return l.With(logging.Lib("badger.sillycounter"))
 	}
-	defer func() {
-		err = errors.Join(err, seq.Release())
-	}()
-	res, err := humanize(seq.Next())
-	return res, err
+	return s.l
 }
 
+// humanize makes the sequence start at 1 instead of 0, on errors it's 0 like for the other backends
 func humanize(i uint64, e error) (uint64, error) {
-	return i + 1, e
+	if e != nil {
+		return 0, e
+	}
+	return i + 1, nil
 }
@@ -0,0 +1,435 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonmol/http-skeleton/instrumentation"
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxDifferences is how many differences a verification keeps as examples
+const maxDifferences = 10
+
+// healthTimeout is how long the health check of <appName>_db_other_up may take
+const healthTimeout = 2 * time.Second
+
+// lockStripes is the number of locks the counters are spread over, see dualWrite.lock
+const lockStripes = 64
+
+// backend is one side of the dual write
+type backend struct {
+	driver   Driver
+	db       Backend
+	counter  Counter
+	exporter Exporter
+}
+
+// dualWrite is the state of the dual write mode, see OpenSecondary
+type dualWrite struct {
+	primary, secondary backend
+	switched           atomic.Bool
+	locks              [lockStripes]sync.Mutex
+	metrics            *dualWriteMetrics
+	mirrorErrors       atomic.Uint64
+
+	mut    sync.Mutex
+	copy   CopyStatus
+	verify VerifyStatus
+}
+
+// dualWriteMetrics are nil until InstrumentDualWrite is called
+type dualWriteMetrics struct {
+	mirrorErrors prometheus.Counter
+	copied       prometheus.Counter
+	differences  *prometheus.GaugeVec
+	switched     prometheus.Gauge
+}
+
+// CopyStatus is the progress of the last copy
+type CopyStatus struct {
+	Running  bool      `json:"running"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+	Copied   int       `json:"copied"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Difference is a counter that differs between the backends
+type Difference struct {
+	Record
+	Other uint64 `json:"other"`
+}
+
+// VerifyResult is the outcome of a verification. Missing counters are only in the authoritative backend, extra only
+// in the other one and mismatched in both but with different counts
+type VerifyResult struct {
+	Checked     int          `json:"checked"`
+	Missing     int          `json:"missing"`
+	Mismatched  int          `json:"mismatched"`
+	Extra       int          `json:"extra"`
+	Differences []Difference `json:"differences,omitempty"`
+}
+
+// Consistent is true if the backends have the same counters
+func (v VerifyResult) Consistent() bool {
+	return v.Missing == 0 && v.Mismatched == 0 && v.Extra == 0
+}
+
+// VerifyStatus is the last verification
+type VerifyStatus struct {
+	VerifyResult
+	Running  bool      `json:"running"`
+	Finished time.Time `json:"finished,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// DualWriteStatus is what the dual write is up to, for the telemetry server
+type DualWriteStatus struct {
+	Primary       string       `json:"primary"`
+	Secondary     string       `json:"secondary"`
+	SwitchedOver  bool         `json:"switchedOver"`
+	Authoritative string       `json:"authoritative"`
+	OtherHealthy  bool         `json:"otherHealthy"` // the health of the backend that isn't authoritative
+	MirrorErrors  uint64       `json:"mirrorErrors"`
+	Copy          CopyStatus   `json:"copy"`
+	Verify        VerifyStatus `json:"verify"`
+}
+
+// OpenSecondary opens a second database and turns on dual writes: every increment is done in the authoritative
+// backend, the primary until SwitchOver, and the resulting count is written to the other one. Counts are always
+// read from the authoritative backend, a failed write to the other one is logged and counted but doesn't fail the
+// request. Copy backfills the counters written before, Verify compares them. Both backends must be Exporters
+func (db *DB) OpenSecondary(ctx context.Context, driver string, decode func(conf any) error) error {
+	if db.db == nil {
+		return errors.New("open the primary database first")
+	}
+	primary, ok := db.db.(Exporter)
+	if !ok {
+		return fmt.Errorf("database %s can't be copied from: %w", db.Driver(), errors.ErrUnsupported)
+	}
+	sec := NewModel(ctx)
+	if err := sec.Open(ctx, driver, decode); err != nil {
+		return fmt.Errorf("secondary: %w", err)
+	}
+	secondary, ok := sec.db.(Exporter)
+	if !ok {
+		return errors.Join(fmt.Errorf("database %s can't be copied to: %w", driver, errors.ErrUnsupported), sec.Close(ctx))
+	}
+
+	db.dual = &dualWrite{
+		primary:   backend{driver: db.driver, db: db.db, counter: db.Counter, exporter: primary},
+		secondary: backend{driver: sec.driver, db: sec.db, counter: sec.Counter, exporter: secondary},
+	}
+	db.Counter = &dualCounter{dw: db.dual, l: db.l}
+	db.l.Info("Writing to both databases", slog.String("primary", db.Driver()), slog.String("secondary", driver))
+	return nil
+}
+
+// DualWrite is true if a secondary database is open
+func (db *DB) DualWrite() bool {
+	return db.dual != nil
+}
+
+// InstrumentDualWrite adds the dual write metrics to reg:
+// <appName>_db_mirror_errors_total failed writes to the non-authoritative backend
+// <appName>_db_copied_total counters copied by Copy
+// <appName>_db_verify_differences{kind} missing, mismatched and extra counters found by the last Verify
+// <appName>_db_switched_over 1 when the secondary is authoritative
+// <appName>_db_other_up 1 when the backend that isn't authoritative is healthy, checked on every scrape
+func (db *DB) InstrumentDualWrite(appName string, reg prometheus.Registerer) {
+	if db.dual == nil || reg == nil {
+		return
+	}
+	m := &dualWriteMetrics{
+		mirrorErrors: instrumentation.Register(reg, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: appName + "_db_mirror_errors_total",
+			Help: "Failed writes to the database that isn't authoritative during dual writes",
+		})),
+		copied: instrumentation.Register(reg, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: appName + "_db_copied_total",
+			Help: "Counters copied to the database that isn't authoritative",
+		})),
+		differences: instrumentation.Register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: appName + "_db_verify_differences",
+			Help: "Counters that differed between the databases on the last verification, by kind: missing, mismatched or extra",
+		}, []string{"kind"})),
+		switched: instrumentation.Register(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: appName + "_db_switched_over",
+			Help: "1 if the secondary database is authoritative",
+		})),
+	}
+	instrumentation.Register(reg, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: appName + "_db_other_up",
+		Help: "1 if the database that isn't authoritative during dual writes is healthy",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+		defer cancel()
+		if _, to := db.dual.roles(); to.db.Healthy(ctx) {
+			return 1
+		}
+		return 0
+	}))
+	if db.dual.switched.Load() {
+		m.switched.Set(1)
+	} else {
+		m.switched.Set(0)
+	}
+	db.dual.metrics = m
+}
+
+// SwitchOver makes the secondary authoritative, or the primary again with false. The writes still go to both, so
+// it can be switched back
+func (db *DB) SwitchOver(secondary bool) {
+	if db.dual == nil {
+		return
+	}
+	if db.dual.switched.Swap(secondary) != secondary {
+		from, to := db.dual.roles()
+		db.l.Warn("Switched the authoritative database", slog.String("from", to.driver.Name), slog.String("to", from.driver.Name))
+	}
+	if m := db.dual.metrics; m != nil {
+		if secondary {
+			m.switched.Set(1)
+		} else {
+			m.switched.Set(0)
+		}
+	}
+}
+
+// DualWriteStatus returns the state of the dual writes, the zero value if there's no secondary
+func (db *DB) DualWriteStatus(ctx context.Context) DualWriteStatus {
+	dw := db.dual
+	if dw == nil {
+		return DualWriteStatus{}
+	}
+	from, to := dw.roles()
+	healthy := to.db.Healthy(ctx)
+	dw.mut.Lock()
+	defer dw.mut.Unlock()
+	return DualWriteStatus{
+		Primary:       dw.primary.driver.Name,
+		Secondary:     dw.secondary.driver.Name,
+		SwitchedOver:  dw.switched.Load(),
+		Authoritative: from.driver.Name,
+		OtherHealthy:  healthy,
+		MirrorErrors:  dw.mirrorErrors.Load(),
+		Copy:          dw.copy,
+		Verify:        dw.verify,
+	}
+}
+
+// Copy backfills the counters of the authoritative backend into the other one and returns how many were copied.
+// Every counter is read again while locked, so a concurrent increment isn't overwritten by an older count
+func (db *DB) Copy(ctx context.Context) (int, error) {
+	dw := db.dual
+	if dw == nil {
+		return 0, errors.New("no secondary database")
+	}
+	dw.mut.Lock()
+	if dw.copy.Running {
+		dw.mut.Unlock()
+		return 0, errors.New("already copying")
+	}
+	dw.copy = CopyStatus{Running: true, Started: time.Now()}
+	dw.mut.Unlock()
+
+	from, to := dw.roles()
+	db.l.Info("Copying the counters", slog.String("from", from.driver.Name), slog.String("to", to.driver.Name))
+	n := 0
+	err := from.exporter.Export(ctx, func(rec Record) error {
+		unlock := dw.lock(rec)
+		defer unlock()
+		count, err := from.exporter.Count(ctx, rec)
+		if err != nil {
+			return err
+		}
+		rec.Count = count
+		if err := to.exporter.Import(ctx, rec); err != nil {
+			return err
+		}
+		n++
+		if dw.metrics != nil {
+			dw.metrics.copied.Inc()
+		}
+		dw.mut.Lock()
+		dw.copy.Copied = n
+		dw.mut.Unlock()
+		return nil
+	})
+
+	dw.mut.Lock()
+	dw.copy.Running = false
+	dw.copy.Finished = time.Now()
+	if err != nil {
+		dw.copy.Error = err.Error()
+	}
+	dw.mut.Unlock()
+	if err != nil {
+		return n, fmt.Errorf("copied %d counters before failing: %w", n, err)
+	}
+	db.l.Info("Copied the counters", slog.Int("copied", n))
+	return n, nil
+}
+
+// Verify compares the counters of the backends. Every counter is read from both while locked, so counters being
+// increased don't show up as differences
+func (db *DB) Verify(ctx context.Context) (VerifyResult, error) {
+	dw := db.dual
+	if dw == nil {
+		return VerifyResult{}, errors.New("no secondary database")
+	}
+	dw.mut.Lock()
+	if dw.verify.Running {
+		dw.mut.Unlock()
+		return VerifyResult{}, errors.New("already verifying")
+	}
+	dw.verify.Running = true
+	dw.mut.Unlock()
+
+	res, err := dw.compare(ctx)
+
+	dw.mut.Lock()
+	dw.verify = VerifyStatus{VerifyResult: res, Finished: time.Now()}
+	if err != nil {
+		dw.verify.Error = err.Error()
+	}
+	dw.mut.Unlock()
+	if err != nil {
+		return res, err
+	}
+	if m := dw.metrics; m != nil {
+		m.differences.WithLabelValues("missing").Set(float64(res.Missing))
+		m.differences.WithLabelValues("mismatched").Set(float64(res.Mismatched))
+		m.differences.WithLabelValues("extra").Set(float64(res.Extra))
+	}
+	lvl := slog.LevelInfo
+	if !res.Consistent() {
+		lvl = slog.LevelWarn
+	}
+	db.l.Log(ctx, lvl, "Verified the counters", slog.Int("checked", res.Checked), slog.Int("missing", res.Missing),
+		slog.Int("mismatched", res.Mismatched), slog.Int("extra", res.Extra))
+	return res, nil
+}
+
+// compare goes through the authoritative counters and then the ones only in the other backend
+func (dw *dualWrite) compare(ctx context.Context) (VerifyResult, error) {
+	var res VerifyResult
+	from, to := dw.roles()
+	seen := map[Record]bool{}
+	diff := func(rec Record, other uint64) {
+		if len(res.Differences) < maxDifferences {
+			res.Differences = append(res.Differences, Difference{Record: rec, Other: other})
+		}
+	}
+
+	err := from.exporter.Export(ctx, func(rec Record) error {
+		seen[Record{Counter: rec.Counter, Word: rec.Word}] = true
+		unlock := dw.lock(rec)
+		defer unlock()
+		a, err := from.exporter.Count(ctx, rec)
+		if err != nil {
+			return err
+		}
+		b, err := to.exporter.Count(ctx, rec)
+		if err != nil {
+			return err
+		}
+		res.Checked++
+		rec.Count = a
+		switch {
+		case a == b:
+		case b == 0:
+			res.Missing++
+			diff(rec, b)
+		default:
+			res.Mismatched++
+			diff(rec, b)
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+
+	err = to.exporter.Export(ctx, func(rec Record) error {
+		if seen[Record{Counter: rec.Counter, Word: rec.Word}] || rec.Count == 0 {
+			return nil
+		}
+		unlock := dw.lock(rec)
+		defer unlock()
+		// it may have been added since it was exported
+		a, err := from.exporter.Count(ctx, rec)
+		if err != nil {
+			return err
+		}
+		res.Checked++
+		if a == 0 {
+			res.Extra++
+			diff(Record{Counter: rec.Counter, Word: rec.Word}, rec.Count)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// roles returns the authoritative backend first
+func (dw *dualWrite) roles() (backend, backend) {
+	if dw.switched.Load() {
+		return dw.secondary, dw.primary
+	}
+	return dw.primary, dw.secondary
+}
+
+// lock locks the counter of rec. Increments, copies and verifications of the same counter take turns, that way
+// the other backend never gets an older count after a newer one from this instance
+func (dw *dualWrite) lock(rec Record) func() {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(rec.Counter))
+	_, _ = h.Write([]byte(rec.Word))
+	m := &dw.locks[h.Sum32()%lockStripes]
+	m.Lock()
+	return m.Unlock
+}
+
+// dualCounter is the Counter while writing to both backends
+type dualCounter struct {
+	dw *dualWrite
+	l  *slog.Logger
+}
+
+func (c *dualCounter) IncGlobal(ctx context.Context) (uint64, error) {
+	return c.inc(ctx, Record{Counter: RecordGlobal}, func(c Counter) (uint64, error) { return c.IncGlobal(ctx) })
+}
+
+func (c *dualCounter) IncWord(ctx context.Context, w string) (uint64, error) {
+	return c.inc(ctx, Record{Counter: RecordWord, Word: w}, func(c Counter) (uint64, error) { return c.IncWord(ctx, w) })
+}
+
+// inc increases the counter in the authoritative backend and sets the other one to the result
+func (c *dualCounter) inc(ctx context.Context, rec Record, inc func(Counter) (uint64, error)) (uint64, error) {
+	from, to := c.dw.roles()
+	unlock := c.dw.lock(rec)
+	defer unlock()
+	n, err := inc(from.counter)
+	if err != nil {
+		return n, err
+	}
+	rec.Count = n
+	// the count is already taken, a canceled request shouldn't leave the other backend behind
+	if err := to.exporter.Import(context.WithoutCancel(ctx), rec); err != nil {
+		c.dw.mirrorErrors.Add(1)
+		if c.dw.metrics != nil {
+			c.dw.metrics.mirrorErrors.Inc()
+		}
+		c.l.Warn("Failed to write to the other database", logging.Err(err), slog.String("database", to.driver.Name))
+	}
+	return n, nil
+}
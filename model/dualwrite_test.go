@@ -0,0 +1,202 @@
+package model_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jonmol/http-skeleton/model"
+	_ "github.com/jonmol/http-skeleton/model/memory" // the dual writes are tested with two memory databases
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func noConfig(any) error { return nil }
+
+// openDual returns a memory database with counts in it and an empty secondary memory database
+func openDual(t *testing.T) *model.DB {
+	t.Helper()
+	r := require.New(t)
+	ctx := context.Background()
+	db := model.NewModel(ctx)
+	r.NoError(db.Open(ctx, "memory", noConfig))
+	for _, w := range []string{"a", "b", "a"} {
+		_, err := db.Counter.IncWord(ctx, w)
+		r.NoError(err)
+		_, err = db.Counter.IncGlobal(ctx)
+		r.NoError(err)
+	}
+	r.NoError(db.OpenSecondary(ctx, "memory", noConfig))
+	r.NoError(db.EnsureDB(ctx))
+	t.Cleanup(func() { _ = db.Close(context.Background()) })
+	return db
+}
+
+func TestUnitDualWrite(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	db := openDual(t)
+	reg := prometheus.NewRegistry()
+	db.InstrumentDualWrite("app", reg)
+	r.True(db.DualWrite())
+
+	// the old counters are only in the primary
+	res, err := db.Verify(ctx)
+	r.NoError(err)
+	r.Equal(model.VerifyResult{Checked: 3, Missing: 3, Differences: []model.Difference{
+		{Record: model.Record{Counter: model.RecordGlobal, Count: 3}},
+		{Record: model.Record{Counter: model.RecordWord, Word: "a", Count: 2}},
+		{Record: model.Record{Counter: model.RecordWord, Word: "b", Count: 1}},
+	}}, res)
+
+	// new counts go to both
+	n, err := db.Counter.IncWord(ctx, "c")
+	r.NoError(err)
+	r.EqualValues(1, n)
+	n, err = db.Counter.IncWord(ctx, "a")
+	r.NoError(err)
+	r.EqualValues(3, n, "counts come from the primary")
+	res, err = db.Verify(ctx)
+	r.NoError(err)
+	r.Equal(4, res.Checked)
+	r.Equal(2, res.Missing, "only the global counter and b should be missing")
+	r.Zero(res.Mismatched, "the count of a is set, not increased, in the secondary")
+
+	copied, err := db.Copy(ctx)
+	r.NoError(err)
+	r.Equal(4, copied)
+	res, err = db.Verify(ctx)
+	r.NoError(err)
+	r.True(res.Consistent())
+
+	s := db.DualWriteStatus(ctx)
+	r.Equal("memory", s.Secondary)
+	r.Equal("memory", s.Authoritative)
+	r.True(s.OtherHealthy)
+	r.False(s.SwitchedOver)
+	r.Equal(4, s.Copy.Copied)
+	r.False(s.Copy.Running)
+	r.Equal(4, s.Verify.Checked)
+	r.NoError(testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP app_db_copied_total Counters copied to the database that isn't authoritative
+# TYPE app_db_copied_total counter
+app_db_copied_total 4
+# HELP app_db_verify_differences Counters that differed between the databases on the last verification, by kind: missing, mismatched or extra
+# TYPE app_db_verify_differences gauge
+app_db_verify_differences{kind="extra"} 0
+app_db_verify_differences{kind="mismatched"} 0
+app_db_verify_differences{kind="missing"} 0
+`), "app_db_copied_total", "app_db_verify_differences"))
+}
+
+func TestUnitDualWriteSwitchOver(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	db := openDual(t)
+	reg := prometheus.NewRegistry()
+	db.InstrumentDualWrite("app", reg)
+	_, err := db.Copy(ctx)
+	r.NoError(err)
+
+	db.SwitchOver(true)
+	r.True(db.DualWriteStatus(ctx).SwitchedOver)
+	r.InDelta(1, metricValue(t, reg, "app_db_switched_over"), 0)
+	n, err := db.Counter.IncGlobal(ctx)
+	r.NoError(err)
+	r.EqualValues(4, n, "the secondary should continue where the primary was")
+
+	// and the primary is kept up to date, to be able to switch back
+	db.SwitchOver(false)
+	res, err := db.Verify(ctx)
+	r.NoError(err)
+	r.True(res.Consistent())
+	n, err = db.Counter.IncGlobal(ctx)
+	r.NoError(err)
+	r.EqualValues(5, n)
+}
+
+func TestUnitDualWriteConcurrentCopy(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	db := openDual(t)
+
+	// require can only stop the test from the test goroutine
+	errs := make(chan error, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := db.Counter.IncWord(ctx, "a"); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := db.Counter.IncGlobal(ctx); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	_, err := db.Copy(ctx)
+	r.NoError(err)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		r.NoError(err)
+	}
+
+	res, err := db.Verify(ctx)
+	r.NoError(err)
+	r.True(res.Consistent(), "a copy shouldn't overwrite newer counts: %+v", res)
+}
+
+func TestUnitDualWriteMirrorErrors(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	db := model.NewModel(ctx)
+	r.NoError(db.Open(ctx, "memory", noConfig))
+	r.NoError(db.OpenSecondary(ctx, "fakefailing", noConfig))
+	reg := prometheus.NewRegistry()
+	db.InstrumentDualWrite("app", reg)
+
+	n, err := db.Counter.IncWord(ctx, "a")
+	r.NoError(err, "the other database failing shouldn't fail the request")
+	r.EqualValues(1, n)
+	r.EqualValues(1, db.DualWriteStatus(ctx).MirrorErrors)
+	r.InDelta(1, metricValue(t, reg, "app_db_mirror_errors_total"), 0)
+
+	_, err = db.Copy(ctx)
+	r.ErrorContains(err, "copied 0 counters before failing: down")
+	r.Equal("down", db.DualWriteStatus(ctx).Copy.Error)
+	r.False(db.DualWriteStatus(ctx).OtherHealthy)
+	r.InDelta(0, metricValue(t, reg, "app_db_other_up"), 0)
+	r.True(db.Healthy(ctx), "the other database being down shouldn't make the service unhealthy")
+
+	r.ErrorContains(model.NewModel(ctx).OpenSecondary(ctx, "memory", noConfig), "open the primary database first")
+	db = model.NewModel(ctx)
+	r.NoError(db.Open(ctx, "fake", noConfig))
+	r.ErrorContains(db.OpenSecondary(ctx, "memory", noConfig), "database fake can't be copied from")
+}
+
+// metricValue returns the value of the metric without labels called name
+func metricValue(t *testing.T, reg *prometheus.Registry, name string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		m := f.GetMetric()[0]
+		if m.GetCounter() != nil {
+			return m.GetCounter().GetValue()
+		}
+		return m.GetGauge().GetValue()
+	}
+	t.Fatal(name, "isn't registered")
+	return 0
+}
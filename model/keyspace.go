@@ -0,0 +1,101 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultPrefix is the key prefix of the backends created without one, serve uses --db-prefix or the service name
+const DefaultPrefix = "http-skeleton"
+
+// validPrefix keeps the prefix free from the separator and from characters redis treats specially in SCAN patterns
+// and cluster hash tags
+var validPrefix = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Namespace builds the keys of a key-value backend. Every key starts with the prefix of the service, so several
+// services can share a database, followed by the kind of key, so a word can never be mistaken for another key:
+//
+//	<prefix>:c:global     the global counter
+//	<prefix>:c:w:<word>   a word counter, the word as it is
+//	<prefix>:meta:<name>  the schema version and the migration lock
+//
+// The word is always last, so it can contain anything, even the separator
+type Namespace struct {
+	prefix string
+}
+
+// NewNamespace returns the namespace of prefix, which may contain letters, digits and _.- only. An empty prefix is
+// DefaultPrefix
+func NewNamespace(prefix string) (Namespace, error) {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	if !validPrefix.MatchString(prefix) {
+		return Namespace{}, fmt.Errorf("invalid key prefix %q, only letters, digits and _.- are allowed", prefix)
+	}
+	return Namespace{prefix: prefix + ":"}, nil
+}
+
+// Prefix is the start of all keys in the namespace, everything of the service
+func (n Namespace) Prefix() string {
+	return n.prefix
+}
+
+// Counters is the start of the keys of all counters
+func (n Namespace) Counters() string {
+	return n.prefix + "c:"
+}
+
+// Global is the key of the global counter
+func (n Namespace) Global() string {
+	return n.Counters() + "global"
+}
+
+// Word is the key of the counter of w
+func (n Namespace) Word(w string) string {
+	return n.WordPrefix() + w
+}
+
+// WordPrefix is the start of the keys of the word counters
+func (n Namespace) WordPrefix() string {
+	return n.Counters() + "w:"
+}
+
+// ParseWord returns the word of a key made by Word, false for other keys
+func (n Namespace) ParseWord(key string) (string, bool) {
+	return strings.CutPrefix(key, n.WordPrefix())
+}
+
+// Meta is the key of the metadata name, like the schema version
+func (n Namespace) Meta(name string) string {
+	return n.prefix + "meta:" + name
+}
+
+// RecordKey is the key of the counter of the record
+func (n Namespace) RecordKey(rec Record) string {
+	if rec.Counter == RecordGlobal {
+		return n.Global()
+	}
+	return n.Word(rec.Word)
+}
+
+// namespaced matches the keys of every namespace, the prefix is the first group
+var namespaced = regexp.MustCompile(`^([A-Za-z0-9_.-]+):(?:c|meta):`)
+
+// ParseNamespace returns the namespace of a key shaped like the keys of a Namespace, false for other keys. A key of
+// the old layout can have the same shape, the word "foo:c:x" was kept at "afoo:c:x", so the migrations from the old
+// layout only take it for the key of another service if that namespace is in use, see InUse
+func ParseNamespace(key string) (Namespace, bool) {
+	m := namespaced.FindStringSubmatch(key)
+	if m == nil {
+		return Namespace{}, false
+	}
+	return Namespace{prefix: m[1] + ":"}, true
+}
+
+// InUse are the keys of which at least one exists once a service uses the namespace: the schema version, set from the
+// first migration on, and the migration lock, taken before it
+func (n Namespace) InUse() []string {
+	return []string{n.Meta("schemaVersion"), n.Meta("migrationLock")}
+}
@@ -0,0 +1,23 @@
+package memory_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/model/memory"
+	"github.com/jonmol/http-skeleton/model/modeltest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitConformance(t *testing.T) {
+	dir := t.TempDir()
+	modeltest.Run(t, func(t *testing.T) (model.Backend, model.Counter) {
+		// the same snapshot for every call in a test
+		db := memory.New(context.Background(), filepath.Join(dir, strings.ReplaceAll(t.Name(), "/", "_")+".json"))
+		require.NoError(t, db.Open(context.Background()))
+		return db, db
+	})
+}
@@ -0,0 +1,36 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jonmol/http-skeleton/model"
+)
+
+// Config is read from the db-* flags
+type Config struct {
+	// Snapshot is the file the data is written to on Close and read from on Open, empty to keep nothing
+	Snapshot string `mapstructure:"db-memory-snapshot"`
+	// Prefix is the namespace of the keys, empty for model.DefaultPrefix. Nothing else can reach the data, but a
+	// snapshot can be read by another service
+	Prefix string `mapstructure:"db-prefix"`
+}
+
+func init() {
+	model.Register(model.Driver{
+		Name:         "memory",
+		Capabilities: model.Capabilities{Transactions: true, Scans: true},
+		NewConfig:    func() any { return &Config{} },
+		Open: func(ctx context.Context, conf any) (model.Backend, model.Counter, error) {
+			c, ok := conf.(*Config)
+			if !ok {
+				return nil, nil, fmt.Errorf("memory: unexpected config %T", conf)
+			}
+			db := NewWithConfig(ctx, *c)
+			if err := db.Open(ctx); err != nil {
+				return nil, nil, err
+			}
+			return db, db, nil
+		},
+	})
+}
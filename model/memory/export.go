@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/jonmol/http-skeleton/model"
+)
+
+// Export calls fn for every counter, sorted by key. The counters are copied first so fn can take its time
+func (db *DB) Export(ctx context.Context, fn func(model.Record) error) error {
+	db.mut.Lock()
+	if db.closed {
+		db.mut.Unlock()
+		return ErrClosed
+	}
+	recs := make([]model.Record, 0, len(db.data))
+	for k, v := range db.data {
+		if k == db.ns.Global() {
+			recs = append(recs, model.Record{Counter: model.RecordGlobal, Count: v})
+		} else if w, ok := db.ns.ParseWord(k); ok {
+			recs = append(recs, model.Record{Counter: model.RecordWord, Word: w, Count: v})
+		}
+	}
+	db.mut.Unlock()
+
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].Counter != recs[j].Counter {
+			return recs[i].Counter < recs[j].Counter
+		}
+		return recs[i].Word < recs[j].Word
+	})
+	for _, rec := range recs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) Import(ctx context.Context, rec model.Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.mut.Lock()
+	defer db.mut.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	db.data[db.ns.RecordKey(rec)] = rec.Count
+	return nil
+}
+
+func (db *DB) Count(ctx context.Context, rec model.Record) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	db.mut.Lock()
+	defer db.mut.Unlock()
+	if db.closed {
+		return 0, ErrClosed
+	}
+	return db.data[db.ns.RecordKey(rec)], nil
+}
@@ -0,0 +1,205 @@
+// Package memory is a storage backend keeping everything in memory. It's meant for tests and deployments where
+// losing the counts on restart is fine, optionally a snapshot is written to a file on Close and read back on Open
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/server/util/myctx"
+	"github.com/jonmol/http-skeleton/util/logging"
+)
+
+// ErrClosed is returned when the database is used after Close
+var ErrClosed = errors.New("memory: database closed")
+
+// DB is the in-memory database, it's also the Counter
+type DB struct {
+	l        *slog.Logger
+	snapshot string
+	prefix   string
+	ns       model.Namespace
+
+	mut     sync.Mutex
+	data    map[string]uint64
+	version int
+	closed  bool
+
+	// migrating is held while migrating, see Lock
+	migrating sync.Mutex
+}
+
+// snapshotFile is the format of the snapshot
+type snapshotFile struct {
+	Counters      map[string]uint64 `json:"counters"`
+	SchemaVersion int               `json:"schemaVersion,omitempty"`
+}
+
+// Open reads the snapshot if there is one
+func (db *DB) Open(_ context.Context) error {
+	ns, err := model.NewNamespace(db.prefix)
+	if err != nil {
+		return err
+	}
+	db.mut.Lock()
+	defer db.mut.Unlock()
+	db.ns = ns
+	db.data = map[string]uint64{}
+	db.version = 0
+	db.closed = false
+	if db.snapshot == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(db.snapshot)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("memory: failed to read the snapshot: %w", err)
+	}
+	var s snapshotFile
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("memory: failed to parse the snapshot %s: %w", db.snapshot, err)
+	}
+	for k, v := range s.Counters {
+		db.data[k] = v
+	}
+	db.version = s.SchemaVersion
+	db.l.Info("Read the snapshot", slog.String("path", db.snapshot), slog.Int("keys", len(db.data)))
+	return nil
+}
+
+// nothing to do here
+func (db *DB) EnsureDB(_ context.Context) error {
+	return nil
+}
+
+// TearDown deletes all keys in the namespace and the schema version, the snapshot is removed as well
+func (db *DB) TearDown(_ context.Context) error {
+	db.mut.Lock()
+	defer db.mut.Unlock()
+	for k := range db.data {
+		if strings.HasPrefix(k, db.ns.Prefix()) {
+			delete(db.data, k)
+		}
+	}
+	db.version = 0
+	if db.snapshot != "" {
+		if err := os.Remove(db.snapshot); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the snapshot if configured, after that the database can't be used until opened again
+func (db *DB) Close(_ context.Context) error {
+	db.mut.Lock()
+	defer db.mut.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	if db.snapshot == "" {
+		return nil
+	}
+	db.l.Info("Writing the snapshot", slog.String("path", db.snapshot), slog.Int("keys", len(db.data)))
+	return writeSnapshot(db.snapshot, snapshotFile{Counters: db.data, SchemaVersion: db.version})
+}
+
+func (db *DB) Healthy(_ context.Context) bool {
+	db.mut.Lock()
+	defer db.mut.Unlock()
+	return !db.closed
+}
+
+func (db *DB) IncGlobal(ctx context.Context) (uint64, error) {
+	return db.incr(ctx, db.ns.Global())
+}
+
+func (db *DB) IncWord(ctx context.Context, w string) (uint64, error) {
+	return db.incr(ctx, db.ns.Word(w))
+}
+
+func (db *DB) incr(ctx context.Context, k string) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	db.mut.Lock()
+	if db.closed {
+		db.mut.Unlock()
+		return 0, ErrClosed
+	}
+	db.data[k]++
+	res := db.data[k]
+	db.mut.Unlock()
+
+	db.logger(ctx).Debug("Increased counter", slog.Uint64("count", res))
+	return res, nil
+}
+
+// logger returns the request logger if there is one, so a request with debug logging turned on is followed
+// all the way down here
+func (db *DB) logger(ctx context.Context) *slog.Logger {
+	if l := myctx.LoggerOr(ctx, nil); l != nil {
+		return l.With(logging.Lib("memory"))
+	}
+	return db.l
+}
+
+// writeSnapshot writes to a temporary file first, that way a crash while writing doesn't destroy the last snapshot
+func writeSnapshot(path string, s snapshotFile) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("memory: failed to write the snapshot: %w", err)
+	}
+	_, err = tmp.Write(b)
+	err = errors.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("memory: failed to write the snapshot: %w", err)
+	}
+	return nil
+}
+
+// New returns an in-memory database, with snapshot set it's written there on Close and read on Open
+func New(ctx context.Context, snapshot string) *DB {
+	return NewWithConfig(ctx, Config{Snapshot: snapshot})
+}
+
+// NewWithConfig returns an in-memory database, see Config
+func NewWithConfig(ctx context.Context, conf Config) *DB {
+	db := DB{
+		l:        slog.With(logging.Lib("memory")),
+		snapshot: conf.Snapshot,
+		prefix:   conf.Prefix,
+		data:     map[string]uint64{},
+	}
+	db.autoclose(ctx)
+	return &db
+}
+
+// autoclose closes the DB when the context is canceled, so the snapshot is written also if Close isn't called
+func (db *DB) autoclose(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		if err := db.Close(ctx); err != nil {
+			db.l.Error("Failed to properly close the database", logging.Err(err))
+		}
+	}()
+}
@@ -0,0 +1,116 @@
+package memory_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/model/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitConcurrentIncrements(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+	db := memory.New(ctx, "")
+	r.NoError(db.Open(ctx))
+
+	// require can only stop the test from the test goroutine
+	errs := make(chan error, 50)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := db.IncGlobal(ctx); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := db.IncWord(ctx, "word"); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		r.NoError(err)
+	}
+
+	n, err := db.IncGlobal(ctx)
+	r.NoError(err)
+	r.EqualValues(5001, n)
+	n, err = db.IncWord(ctx, "word")
+	r.NoError(err)
+	r.EqualValues(5001, n)
+}
+
+func TestUnitSnapshot(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	db := memory.New(ctx, path)
+	r.NoError(db.Open(ctx))
+	for i := 0; i < 3; i++ {
+		_, err := db.IncWord(ctx, "hello")
+		r.NoError(err)
+	}
+	r.NoError(db.Close(ctx))
+	r.False(db.Healthy(ctx))
+	_, err := db.IncGlobal(ctx)
+	r.ErrorIs(err, memory.ErrClosed)
+
+	// the counts survive a restart
+	db = memory.New(ctx, path)
+	r.NoError(db.Open(ctx))
+	n, err := db.IncWord(ctx, "hello")
+	r.NoError(err)
+	r.EqualValues(4, n)
+
+	// and are gone after a teardown
+	r.NoError(db.TearDown(ctx))
+	r.NoError(db.Close(ctx))
+	r.NoError(db.Open(ctx))
+	n, err = db.IncWord(ctx, "hello")
+	r.NoError(err)
+	r.EqualValues(1, n)
+}
+
+func TestUnitNamespaceMigration(t *testing.T) {
+	t.Parallel()
+	r := require.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	// a snapshot from before the keys were namespaced, with a word shaped like a namespaced key
+	r.NoError(os.WriteFile(path, []byte(`{"counters":{"aglobalC":7,"ahello":3,"afoo:c:x":2},"schemaVersion":1}`), 0o600))
+
+	db := model.NewModel(ctx)
+	r.NoError(db.Open(ctx, "memory", func(conf any) error {
+		*conf.(*memory.Config) = memory.Config{Snapshot: path, Prefix: "svc"}
+		return nil
+	}))
+	r.NoError(db.EnsureDB(ctx))
+	n, err := db.Counter.IncGlobal(ctx)
+	r.NoError(err)
+	r.EqualValues(8, n)
+	n, err = db.Counter.IncWord(ctx, "globalC")
+	r.NoError(err)
+	r.EqualValues(1, n, "the word globalC isn't the global counter")
+	_, err = db.Migrate(ctx, 1, false)
+	r.ErrorContains(err, "globalC")
+	r.NoError(db.Close(ctx))
+
+	b, err := os.ReadFile(path)
+	r.NoError(err)
+	r.JSONEq(`{"counters":{"svc:c:global":8,"svc:c:w:hello":3,"svc:c:w:foo:c:x":2,"svc:c:w:globalC":1},"schemaVersion":2}`,
+		string(b))
+}
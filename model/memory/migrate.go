@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jonmol/http-skeleton/model"
+)
+
+// Migrations returns the migrations of the in-memory database, the version is kept in the snapshot
+func (db *DB) Migrations() []model.Migration {
+	return []model.Migration{
+		{Version: 1, Name: "baseline", Up: noop, Down: noop},
+		{Version: 2, Name: "namespaced keys", Up: db.namespaceUp, Down: db.namespaceDown},
+	}
+}
+
+// The keys before they were namespaced, see namespaceUp
+const (
+	legacyPrefix = "a"
+	legacyGlobal = legacyPrefix + "globalC"
+)
+
+// namespaceUp moves the counters of a snapshot from the old layout, "a" followed by the word or "aglobalC" for the
+// global counter, into the namespace. A word "globalC" was the global counter then. The schema version is the one of
+// the snapshot, below 2 every key in it is of the old layout, even those shaped like the keys of a namespace. The
+// snapshot is read by this database only, so holding its lock is enough to not split the counters
+func (db *DB) namespaceUp(_ context.Context) error {
+	db.mut.Lock()
+	defer db.mut.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	for k, v := range db.data {
+		if !strings.HasPrefix(k, legacyPrefix) {
+			continue
+		}
+		to := db.ns.Word(strings.TrimPrefix(k, legacyPrefix))
+		if k == legacyGlobal {
+			to = db.ns.Global()
+		}
+		db.data[to] = v
+		delete(db.data, k)
+	}
+	return nil
+}
+
+// namespaceDown moves the counters back to the old layout, it fails if there's a word the old layout can't tell from
+// the global counter
+func (db *DB) namespaceDown(_ context.Context) error {
+	db.mut.Lock()
+	defer db.mut.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	clash := strings.TrimPrefix(legacyGlobal, legacyPrefix)
+	if _, ok := db.data[db.ns.Word(clash)]; ok {
+		return fmt.Errorf("memory: the word %q would be the global counter in the old layout", clash)
+	}
+	for k, v := range db.data {
+		if w, ok := db.ns.ParseWord(k); ok {
+			db.data[legacyPrefix+w] = v
+			delete(db.data, k)
+		} else if k == db.ns.Global() {
+			db.data[legacyGlobal] = v
+			delete(db.data, k)
+		}
+	}
+	return nil
+}
+
+func (db *DB) SchemaVersion(_ context.Context) (int, error) {
+	db.mut.Lock()
+	defer db.mut.Unlock()
+	if db.closed {
+		return 0, ErrClosed
+	}
+	return db.version, nil
+}
+
+func (db *DB) SetSchemaVersion(_ context.Context, version int) error {
+	db.mut.Lock()
+	defer db.mut.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	db.version = version
+	return nil
+}
+
+// Lock only needs to keep out this process, nothing else can reach the data
+func (db *DB) Lock(_ context.Context) (func(context.Context) error, error) {
+	if !db.migrating.TryLock() {
+		return nil, model.ErrLocked
+	}
+	return func(context.Context) error {
+		db.migrating.Unlock()
+		return nil
+	}, nil
+}
+
+func noop(context.Context) error {
+	return nil
+}
@@ -0,0 +1,217 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jonmol/http-skeleton/util/logging"
+)
+
+// LatestVersion migrates to the last migration of the backend
+const LatestVersion = -1
+
+// ErrLocked is returned by Migrator.Lock when another instance is migrating
+var ErrLocked = errors.New("migration lock is held by another instance")
+
+// LockRetry is how often Migrate tries to get the lock while another instance is migrating
+var LockRetry = 500 * time.Millisecond
+
+// Migration changes the data of a backend from Version-1 to Version, Down changes it back. Down can be nil if the
+// migration can't be undone
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context) error
+	Down    func(ctx context.Context) error
+}
+
+// Migrator is implemented by backends whose data has a schema version. Changing the key layout, like the prefix of
+// the counters, is then done by a migration instead of orphaning the old data
+type Migrator interface {
+	// Migrations returns the migrations of the backend ordered by version, starting at 1
+	Migrations() []Migration
+	// SchemaVersion returns the stored version, 0 if nothing has been migrated
+	SchemaVersion(ctx context.Context) (int, error)
+	// SetSchemaVersion stores the version
+	SetSchemaVersion(ctx context.Context, version int) error
+	// Lock keeps other instances from migrating until unlock is called, it returns ErrLocked if another instance
+	// holds the lock. The lock should expire by itself if the instance holding it dies
+	Lock(ctx context.Context) (unlock func(context.Context) error, err error)
+}
+
+// MigrationState is a migration on the status page
+type MigrationState struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+}
+
+// MigrationStatus is the schema version of the backend and its migrations
+type MigrationStatus struct {
+	Current    int              `json:"current"`
+	Latest     int              `json:"latest"`
+	Migrations []MigrationState `json:"migrations"`
+}
+
+// MigrationStep is a migration that was, or with dry run would be, run
+type MigrationStep struct {
+	Version int
+	Name    string
+	// Up is false when the migration is undone
+	Up bool
+}
+
+func (s MigrationStep) String() string {
+	dir := "up"
+	if !s.Up {
+		dir = "down"
+	}
+	return fmt.Sprintf("%s %d %s", dir, s.Version, s.Name)
+}
+
+// MigrationStatus returns the schema version and migrations of the backend, a backend that isn't a Migrator is
+// always at version 0
+func (db *DB) MigrationStatus(ctx context.Context) (MigrationStatus, error) {
+	m, ok := db.db.(Migrator)
+	if !ok {
+		return MigrationStatus{}, nil
+	}
+	migrations := m.Migrations()
+	if err := validateMigrations(migrations); err != nil {
+		return MigrationStatus{}, err
+	}
+	cur, err := m.SchemaVersion(ctx)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+	s := MigrationStatus{Current: cur, Latest: len(migrations), Migrations: make([]MigrationState, 0, len(migrations))}
+	for _, mig := range migrations {
+		s.Migrations = append(s.Migrations, MigrationState{Version: mig.Version, Name: mig.Name, Applied: mig.Version <= cur})
+	}
+	return s, nil
+}
+
+// Migrate runs the migrations up or down to target, LatestVersion for all. Only one instance migrates at a time,
+// the others wait for the lock and then find nothing left to do. With dryRun the steps are returned without running
+// them
+func (db *DB) Migrate(ctx context.Context, target int, dryRun bool) ([]MigrationStep, error) {
+	return db.migrate(ctx, db.db, target, dryRun)
+}
+
+func (db *DB) migrate(ctx context.Context, b Backend, target int, dryRun bool) ([]MigrationStep, error) {
+	m, ok := b.(Migrator)
+	if !ok {
+		return nil, nil
+	}
+	migrations := m.Migrations()
+	if err := validateMigrations(migrations); err != nil {
+		return nil, err
+	}
+	if target == LatestVersion {
+		target = len(migrations)
+	}
+	if target < 0 || target > len(migrations) {
+		return nil, fmt.Errorf("no schema version %d, the latest is %d", target, len(migrations))
+	}
+
+	if dryRun {
+		cur, err := m.SchemaVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return plan(migrations, cur, target)
+	}
+
+	unlock, err := lock(ctx, m, db.l)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// the context may be done, the lock should still be released
+		if err := unlock(context.WithoutCancel(ctx)); err != nil {
+			db.l.Error("Failed to release the migration lock", logging.Err(err))
+		}
+	}()
+
+	// read after locking, another instance may just have migrated
+	cur, err := m.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := plan(migrations, cur, target)
+	if err != nil {
+		return nil, err
+	}
+	for i, s := range steps {
+		mig := migrations[s.Version-1]
+		db.l.Info("Migrating", slog.String("migration", s.String()))
+		run, version := mig.Up, mig.Version
+		if !s.Up {
+			run, version = mig.Down, mig.Version-1
+		}
+		if err := run(ctx); err != nil {
+			return steps[:i], fmt.Errorf("migration %s failed: %w", s, err)
+		}
+		if err := m.SetSchemaVersion(ctx, version); err != nil {
+			return steps[:i], fmt.Errorf("failed to store schema version %d after %s: %w", version, s, err)
+		}
+	}
+	return steps, nil
+}
+
+// lock gets the migration lock, waiting while another instance holds it
+func lock(ctx context.Context, m Migrator, l *slog.Logger) (func(context.Context) error, error) {
+	return WaitForLock(ctx, m.Lock, l)
+}
+
+// WaitForLock calls lock until it doesn't return ErrLocked, every LockRetry. It's for migrations that need a lock
+// of their own, like one shared by all namespaces
+func WaitForLock(ctx context.Context, lock func(context.Context) (func(context.Context) error, error),
+	l *slog.Logger,
+) (func(context.Context) error, error) {
+	for {
+		unlock, err := lock(ctx)
+		if !errors.Is(err, ErrLocked) {
+			return unlock, err
+		}
+		l.Info("Waiting for another instance to finish migrating")
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for the migration lock: %w", ctx.Err())
+		case <-time.After(LockRetry):
+		}
+	}
+}
+
+// plan returns the steps from cur to target
+func plan(migrations []Migration, cur, target int) ([]MigrationStep, error) {
+	if cur > len(migrations) {
+		return nil, fmt.Errorf("the schema version %d is newer than the latest known, %d. Is an older version running?", cur, len(migrations))
+	}
+	var steps []MigrationStep
+	for v := cur + 1; v <= target; v++ {
+		steps = append(steps, MigrationStep{Version: v, Name: migrations[v-1].Name, Up: true})
+	}
+	for v := cur; v > target; v-- {
+		if migrations[v-1].Down == nil {
+			return nil, fmt.Errorf("migration %d %s can't be undone", v, migrations[v-1].Name)
+		}
+		steps = append(steps, MigrationStep{Version: v, Name: migrations[v-1].Name})
+	}
+	return steps, nil
+}
+
+func validateMigrations(migrations []Migration) error {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return fmt.Errorf("migration %q has version %d, expected %d", m.Name, m.Version, i+1)
+		}
+		if m.Up == nil {
+			return fmt.Errorf("migration %d %s has no Up", m.Version, m.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package model_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/stretchr/testify/require"
+)
+
+// migratingBackend records the migrations run, the third one can't be undone
+type migratingBackend struct {
+	fakeBackend
+	mut     sync.Mutex
+	version int
+	ran     []string
+	locked  bool
+	fail    int
+}
+
+func (m *migratingBackend) Migrations() []model.Migration {
+	step := func(s string, v int) func(context.Context) error {
+		return func(context.Context) error {
+			if v == m.fail {
+				return errors.New("boom")
+			}
+			m.ran = append(m.ran, s)
+			return nil
+		}
+	}
+	return []model.Migration{
+		{Version: 1, Name: "one", Up: step("up 1", 1), Down: step("down 1", 1)},
+		{Version: 2, Name: "two", Up: step("up 2", 2), Down: step("down 2", 2)},
+		{Version: 3, Name: "three", Up: step("up 3", 3)},
+	}
+}
+
+func (m *migratingBackend) SchemaVersion(context.Context) (int, error) { return m.version, nil }
+
+func (m *migratingBackend) SetSchemaVersion(_ context.Context, v int) error {
+	m.version = v
+	return nil
+}
+
+func (m *migratingBackend) Lock(context.Context) (func(context.Context) error, error) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if m.locked {
+		return nil, model.ErrLocked
+	}
+	m.locked = true
+	return func(context.Context) error {
+		m.mut.Lock()
+		defer m.mut.Unlock()
+		m.locked = false
+		return nil
+	}, nil
+}
+
+func init() {
+	model.Register(model.Driver{
+		Name:      "fakemigrator",
+		NewConfig: func() any { return &fakeConfig{} },
+		Open: func(context.Context, any) (model.Backend, model.Counter, error) {
+			b := &migratingBackend{}
+			return b, b, nil
+		},
+	})
+}
+
+func openMigrating(t *testing.T) (*model.DB, *migratingBackend) {
+	t.Helper()
+	ctx := context.Background()
+	db := model.NewModel(ctx)
+	require.NoError(t, db.Open(ctx, "fakemigrator", func(any) error { return nil }))
+	return db, db.Counter.(*migratingBackend) //nolint:errcheck // it's the backend of the driver
+}
+
+func TestUnitMigrate(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	db, b := openMigrating(t)
+
+	// dry run changes nothing
+	steps, err := db.Migrate(ctx, 2, true)
+	r.NoError(err)
+	r.Equal([]model.MigrationStep{{Version: 1, Name: "one", Up: true}, {Version: 2, Name: "two", Up: true}}, steps)
+	r.Empty(b.ran)
+	r.Zero(b.version)
+
+	steps, err = db.Migrate(ctx, 2, false)
+	r.NoError(err)
+	r.Len(steps, 2)
+	r.Equal([]string{"up 1", "up 2"}, b.ran)
+	r.Equal(2, b.version)
+	r.False(b.locked, "the lock should be released")
+
+	// EnsureDB migrates to the latest
+	r.NoError(db.EnsureDB(ctx))
+	r.Equal(3, b.version)
+	steps, err = db.Migrate(ctx, model.LatestVersion, false)
+	r.NoError(err)
+	r.Empty(steps, "nothing left to do")
+
+	status, err := db.MigrationStatus(ctx)
+	r.NoError(err)
+	r.Equal(3, status.Current)
+	r.Equal(3, status.Latest)
+	r.Len(status.Migrations, 3)
+	r.True(status.Migrations[2].Applied)
+
+	_, err = db.Migrate(ctx, 1, false)
+	r.ErrorContains(err, "migration 3 three can't be undone")
+	r.Equal(3, b.version)
+
+	b.version = 2
+	b.ran = nil
+	steps, err = db.Migrate(ctx, 0, false)
+	r.NoError(err)
+	r.Equal("down 2 two", steps[0].String())
+	r.Equal([]string{"down 2", "down 1"}, b.ran)
+	r.Zero(b.version)
+
+	_, err = db.Migrate(ctx, 4, false)
+	r.ErrorContains(err, "no schema version 4")
+}
+
+func TestUnitMigrateFailure(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	db, b := openMigrating(t)
+
+	b.fail = 2
+	steps, err := db.Migrate(ctx, model.LatestVersion, false)
+	r.ErrorContains(err, "migration up 2 two failed: boom")
+	r.Len(steps, 1, "only the steps that ran are returned")
+	r.Equal(1, b.version, "the version of the last successful migration is stored")
+	r.False(b.locked)
+
+	b.version = 7
+	r.ErrorContains(db.EnsureDB(ctx), "the schema version 7 is newer than the latest known, 3")
+}
+
+func TestUnitMigrateWaitsForLock(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	db, b := openMigrating(t)
+	model.LockRetry = 10 * time.Millisecond
+
+	unlock, err := b.Lock(ctx)
+	r.NoError(err)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		b.mut.Lock()
+		b.version = 3
+		b.mut.Unlock()
+		_ = unlock(ctx)
+	}()
+	steps, err := db.Migrate(ctx, model.LatestVersion, false)
+	r.NoError(err)
+	r.Empty(steps, "the other instance already migrated")
+
+	unlock, err = b.Lock(ctx)
+	r.NoError(err)
+	defer func() { _ = unlock(ctx) }()
+	short, cancel := context.WithTimeout(ctx, 30*time.Millisecond)
+	defer cancel()
+	_, err = db.Migrate(short, model.LatestVersion, false)
+	r.ErrorIs(err, context.DeadlineExceeded)
+}
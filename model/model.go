@@ -2,19 +2,18 @@ package model
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"strings"
 
-	"github.com/jonmol/http-skeleton/model/badger"
-	"github.com/jonmol/http-skeleton/model/redis"
 	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// db is a high level representation of some database. It contains a few functions that makes sense, but more
-// should be added if your DB is more advanced
-type db interface {
-	// Open connects to the database
-	Open(context.Context) error
-
+// Backend is a high level representation of some database. It contains a few functions that makes sense, but more
+// should be added if your DB is more advanced. Drivers return it opened, see Driver
+type Backend interface {
 	// EnsureDB should be idempotent, making sure the db is in an expected state
 	// ie tables/namespaces/indices etc should exist after a call. it should be safe to run at start, so not
 	// dropping and recreating tables or such
@@ -30,6 +29,12 @@ type db interface {
 	Healthy(ctx context.Context) bool
 }
 
+// Instrumenter is implemented by backends with metrics of their own
+type Instrumenter interface {
+	// Instrument adds the metrics to reg, the names start with appName
+	Instrument(appName string, reg prometheus.Registerer)
+}
+
 // Counter represents a Counter, it could be using MariDB, badger, bolt, redis or any kind of database.
 // it will be transparent to the consumber
 type Counter interface {
@@ -38,46 +43,115 @@ type Counter interface {
 }
 
 type DB struct {
-	db      db
+	db      Backend
+	driver  Driver
 	l       *slog.Logger
 	Counter Counter
+	// dual is set while writing to a secondary database as well, see OpenSecondary
+	dual *dualWrite
 }
 
+// Close closes the database, and the secondary if there is one
 func (db *DB) Close(ctx context.Context) error {
-	return db.db.Close(ctx)
+	err := db.db.Close(ctx)
+	if db.dual != nil {
+		err = errors.Join(err, db.dual.secondary.db.Close(ctx))
+	}
+	return err
 }
 
+// EnsureDB ensures the backends and migrates them to the latest schema version
 func (db *DB) EnsureDB(ctx context.Context) error {
-	return db.db.EnsureDB(ctx)
+	backends := []Backend{db.db}
+	if db.dual != nil {
+		backends = append(backends, db.dual.secondary.db)
+	}
+	for _, b := range backends {
+		if err := b.EnsureDB(ctx); err != nil {
+			return err
+		}
+		steps, err := db.migrate(ctx, b, LatestVersion, false)
+		if err != nil {
+			return fmt.Errorf("failed to migrate the database: %w", err)
+		}
+		if len(steps) > 0 {
+			db.l.Info("Migrated the database", slog.Int("migrations", len(steps)))
+		}
+	}
+	return nil
 }
 
 func (db *DB) TearDown(ctx context.Context) error {
-	return db.db.TearDown(ctx)
+	err := db.db.TearDown(ctx)
+	if db.dual != nil {
+		err = errors.Join(err, db.dual.secondary.db.TearDown(ctx))
+	}
+	return err
 }
 
+// Healthy is true if the database is healthy. With dual writes it's the authoritative one, the other one failing
+// doesn't fail any request so it's only reported by DualWriteStatus and the dual write metrics
 func (db *DB) Healthy(ctx context.Context) bool {
+	if db.dual != nil {
+		from, _ := db.dual.roles()
+		return from.db.Healthy(ctx)
+	}
 	return db.db.Healthy(ctx)
 }
 
-func (db *DB) OpenBadger(ctx context.Context, p string) error {
-	badgerC := badger.New(ctx, p)
-	if err := badgerC.Open(ctx); err != nil {
-		return err
+// Instrument adds the metrics of the backends that have any, see Instrumenter. Nothing is added with a nil reg
+func (db *DB) Instrument(appName string, reg prometheus.Registerer) {
+	if reg == nil {
+		return
+	}
+	backends := []Backend{db.db}
+	if db.dual != nil {
+		backends = append(backends, db.dual.secondary.db)
+	}
+	for _, b := range backends {
+		if i, ok := b.(Instrumenter); ok {
+			i.Instrument(appName, reg)
+		}
 	}
-	db.db = badgerC
-	db.Counter = badgerC.Counter
-
-	return nil
 }
 
-func (db *DB) OpenRedis(ctx context.Context, addr, pass string) error {
-	redisC := redis.New(ctx, addr, pass)
-	if err := redisC.Open(ctx); err != nil {
+// Open opens the database registered as driver. decode fills the config struct of the driver, for instance
+// viper.Unmarshal
+func (db *DB) Open(ctx context.Context, driver string, decode func(conf any) error) error {
+	d, err := LookupDriver(driver)
+	if err != nil {
+		return err
+	}
+	conf := d.NewConfig()
+	if err := decode(conf); err != nil {
+		return fmt.Errorf("failed to read the %s config: %w", driver, err)
+	}
+	b, c, err := d.Open(ctx, conf)
+	if err != nil {
 		return err
 	}
-	db.db = redisC
-	db.Counter = redisC.Counter
+	db.db = b
+	db.driver = d
+	db.Counter = c
+	db.l.Debug("Opened the database", slog.String("driver", driver))
+	return nil
+}
+
+// Driver returns the name of the opened driver
+func (db *DB) Driver() string {
+	return db.driver.Name
+}
 
+// Capabilities returns what the opened backend supports
+func (db *DB) Capabilities() Capabilities {
+	return db.driver.Capabilities
+}
+
+// Require returns an error if the opened backend lacks any of the capabilities in need
+func (db *DB) Require(need Capabilities) error {
+	if missing := db.Capabilities().Missing(need); len(missing) > 0 {
+		return fmt.Errorf("database %s doesn't support %s", db.Driver(), strings.Join(missing, ", "))
+	}
 	return nil
 }
 
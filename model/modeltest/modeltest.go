@@ -0,0 +1,359 @@
+// Package modeltest is the conformance suite for storage backends. Every driver runs it from its own tests, that way
+// the backends behave the same and services don't need to know which one they are using:
+//
+//	func TestIntegrationConformance(t *testing.T) {
+//		modeltest.Run(t, func(t *testing.T) (model.Backend, model.Counter) { ... })
+//	}
+package modeltest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/stretchr/testify/require"
+)
+
+// Opener returns an opened backend, empty the first time it's called in a test. When called again in the same test
+// it must return a backend on the same storage, like the same directory or redis database, that's how TearDown is
+// checked. Anything that needs to be cleaned up afterwards should be added with t.Cleanup, Close is called by the
+// tests
+type Opener func(t *testing.T) (model.Backend, model.Counter)
+
+// concurrency and rounds are the number of goroutines incrementing at the same time and how many times each does it
+const (
+	concurrency = 20
+	rounds      = 25
+)
+
+// Run runs the conformance suite against the backends returned by open
+func Run(t *testing.T, open Opener) {
+	t.Helper()
+	tests := []struct {
+		name string
+		fun  func(*testing.T, Opener)
+	}{
+		{"Counting", testCounting},
+		{"EnsureDBIdempotent", testEnsureDB},
+		{"TearDown", testTearDown},
+		{"Concurrent", testConcurrent},
+		{"ContextCanceled", testContextCanceled},
+		{"Close", testClose},
+		{"Migrator", testMigrator},
+		{"ExportImport", testExportImport},
+		{"BackupRestore", testBackupRestore},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.fun(t, open)
+		})
+	}
+}
+
+// setup opens and ensures a backend, it's closed when the test is done
+func setup(t *testing.T, open Opener) (model.Backend, model.Counter) {
+	t.Helper()
+	b, c := open(t)
+	require.NoError(t, b.EnsureDB(context.Background()))
+	t.Cleanup(func() {
+		_ = b.Close(context.Background())
+	})
+	return b, c
+}
+
+// testCounting checks that the counters start at 1 and that the words are counted separately
+func testCounting(t *testing.T, open Opener) {
+	r := require.New(t)
+	ctx := context.Background()
+	b, c := setup(t, open)
+	r.True(b.Healthy(ctx))
+
+	for i := uint64(1); i <= 3; i++ {
+		n, err := c.IncGlobal(ctx)
+		r.NoError(err)
+		r.Equal(i, n, "the global counter should start at 1 and go up by 1")
+	}
+	n, err := c.IncWord(ctx, "hello")
+	r.NoError(err)
+	r.EqualValues(1, n, "a word counter should start at 1")
+	n, err = c.IncWord(ctx, "hello")
+	r.NoError(err)
+	r.EqualValues(2, n)
+	n, err = c.IncWord(ctx, "world")
+	r.NoError(err)
+	r.EqualValues(1, n, "words should be counted separately")
+}
+
+// testEnsureDB checks that EnsureDB can be run again without losing anything
+func testEnsureDB(t *testing.T, open Opener) {
+	r := require.New(t)
+	ctx := context.Background()
+	b, c := setup(t, open)
+
+	_, err := c.IncGlobal(ctx)
+	r.NoError(err)
+	r.NoError(b.EnsureDB(ctx))
+	r.NoError(b.EnsureDB(ctx))
+	n, err := c.IncGlobal(ctx)
+	r.NoError(err)
+	r.EqualValues(2, n, "EnsureDB should keep the data")
+}
+
+// testTearDown checks that everything is gone after TearDown, and that the backend can be set up again
+func testTearDown(t *testing.T, open Opener) {
+	r := require.New(t)
+	ctx := context.Background()
+	b, c := setup(t, open)
+
+	for i := 0; i < 3; i++ {
+		_, err := c.IncGlobal(ctx)
+		r.NoError(err)
+		_, err = c.IncWord(ctx, "hello")
+		r.NoError(err)
+	}
+	r.NoError(b.TearDown(ctx))
+
+	// TearDown may close the backend, so the storage is opened again
+	b, c = setup(t, open)
+	n, err := c.IncGlobal(ctx)
+	r.NoError(err)
+	r.EqualValues(1, n, "the global counter should start over after TearDown")
+	n, err = c.IncWord(ctx, "hello")
+	r.NoError(err)
+	r.EqualValues(1, n, "the word counters should start over after TearDown")
+	r.NoError(b.TearDown(ctx))
+}
+
+// testConcurrent checks that concurrent increments are neither lost nor returned twice
+func testConcurrent(t *testing.T, open Opener) {
+	r := require.New(t)
+	ctx := context.Background()
+	_, c := setup(t, open)
+
+	var (
+		wg     sync.WaitGroup
+		mut    sync.Mutex
+		global []uint64
+		word   []uint64
+		errs   []error
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				g, gerr := c.IncGlobal(ctx)
+				w, werr := c.IncWord(ctx, "concurrent")
+				mut.Lock()
+				global, word = append(global, g), append(word, w)
+				if gerr != nil || werr != nil {
+					errs = append(errs, fmt.Errorf("global: %v, word: %v", gerr, werr))
+				}
+				mut.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	r.Empty(errs)
+
+	for name, got := range map[string][]uint64{"global": global, "word": word} {
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		for i, n := range got {
+			r.EqualValues(i+1, n, "the %s counter should return every value from 1 to %d once", name, len(got))
+		}
+	}
+}
+
+// testContextCanceled checks that a canceled context is an error and that nothing is counted
+func testContextCanceled(t *testing.T, open Opener) {
+	r := require.New(t)
+	ctx := context.Background()
+	_, c := setup(t, open)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	n, err := c.IncGlobal(canceled)
+	r.Error(err, "a canceled context should fail")
+	r.Zero(n, "the count should be 0 on errors")
+	n, err = c.IncWord(canceled, "hello")
+	r.Error(err, "a canceled context should fail")
+	r.Zero(n, "the count should be 0 on errors")
+
+	expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = c.IncGlobal(expired)
+	r.Error(err, "an expired context should fail")
+
+	n, err = c.IncGlobal(ctx)
+	r.NoError(err)
+	r.EqualValues(1, n, "failed increments shouldn't count")
+}
+
+// testClose checks that a closed backend isn't healthy, fails instead of counting and can be closed twice
+func testClose(t *testing.T, open Opener) {
+	r := require.New(t)
+	ctx := context.Background()
+	b, c := open(t)
+	r.NoError(b.EnsureDB(ctx))
+	_, err := c.IncGlobal(ctx)
+	r.NoError(err)
+
+	r.NoError(b.Close(ctx))
+	r.False(b.Healthy(ctx), "a closed backend shouldn't be healthy")
+	n, err := c.IncGlobal(ctx)
+	r.Error(err, "a closed backend should fail")
+	r.Zero(n, "the count should be 0 on errors")
+	_, err = c.IncWord(ctx, "hello")
+	r.Error(err, "a closed backend should fail")
+	r.NoError(b.Close(ctx), "closing twice should be fine")
+}
+
+// testMigrator checks the schema version and the migration lock of backends implementing model.Migrator
+func testMigrator(t *testing.T, open Opener) {
+	r := require.New(t)
+	ctx := context.Background()
+	b, _ := setup(t, open)
+	m, ok := b.(model.Migrator)
+	if !ok {
+		t.Skip("not a model.Migrator")
+	}
+
+	for i, mig := range m.Migrations() {
+		r.Equal(i+1, mig.Version, "the migrations should be numbered from 1 without gaps")
+		r.NotEmpty(mig.Name)
+		r.NotNil(mig.Up)
+	}
+
+	v, err := m.SchemaVersion(ctx)
+	r.NoError(err)
+	r.Zero(v, "a new backend should be at version 0")
+	r.NoError(m.SetSchemaVersion(ctx, 3))
+	v, err = m.SchemaVersion(ctx)
+	r.NoError(err)
+	r.Equal(3, v)
+
+	unlock, err := m.Lock(ctx)
+	r.NoError(err)
+	_, err = m.Lock(ctx)
+	r.ErrorIs(err, model.ErrLocked, "the lock should only be held once")
+	r.NoError(unlock(ctx))
+	unlock, err = m.Lock(ctx)
+	r.NoError(err, "the lock should be free after unlocking")
+	r.NoError(unlock(ctx))
+
+	r.NoError(b.TearDown(ctx))
+	b, _ = setup(t, open)
+	v, err = b.(model.Migrator).SchemaVersion(ctx)
+	r.NoError(err)
+	r.Zero(v, "TearDown should remove the schema version")
+	r.NoError(b.TearDown(ctx))
+}
+
+// count increases the global counter global times and the word counters as many times as their values
+func count(t *testing.T, c model.Counter, global int, words map[string]int) {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < global; i++ {
+		_, err := c.IncGlobal(ctx)
+		require.NoError(t, err)
+	}
+	for w, n := range words {
+		for i := 0; i < n; i++ {
+			_, err := c.IncWord(ctx, w)
+			require.NoError(t, err)
+		}
+	}
+}
+
+// testExportImport checks that the export of backends implementing model.Exporter has every counter, that Count
+// reads them and that the counters continue where they were after importing into an empty backend
+func testExportImport(t *testing.T, open Opener) {
+	r := require.New(t)
+	ctx := context.Background()
+	b, c := setup(t, open)
+	e, ok := b.(model.Exporter)
+	if !ok {
+		t.Skip("not a model.Exporter")
+	}
+	count(t, c, 4, map[string]int{"hello": 2, "world": 3})
+
+	var recs []model.Record
+	r.NoError(e.Export(ctx, func(rec model.Record) error {
+		recs = append(recs, rec)
+		return nil
+	}))
+	r.ElementsMatch([]model.Record{
+		{Counter: model.RecordGlobal, Count: 4},
+		{Counter: model.RecordWord, Word: "hello", Count: 2},
+		{Counter: model.RecordWord, Word: "world", Count: 3},
+	}, recs)
+	n, err := e.Count(ctx, model.Record{Counter: model.RecordWord, Word: "world"})
+	r.NoError(err)
+	r.EqualValues(3, n)
+	n, err = e.Count(ctx, model.Record{Counter: model.RecordWord, Word: "nope"})
+	r.NoError(err)
+	r.Zero(n, "a missing counter should be 0")
+	n, err = e.Count(ctx, model.Record{Counter: model.RecordGlobal})
+	r.NoError(err)
+	r.EqualValues(4, n)
+	n, err = c.IncGlobal(ctx)
+	r.NoError(err)
+	r.EqualValues(5, n, "exporting and counting shouldn't change the counters")
+
+	r.NoError(b.TearDown(ctx))
+	b, c = setup(t, open)
+	for _, rec := range recs {
+		r.NoError(b.(model.Exporter).Import(ctx, rec))
+	}
+	n, err = c.IncGlobal(ctx)
+	r.NoError(err)
+	r.EqualValues(5, n, "the global counter should continue after the imported count")
+	n, err = c.IncWord(ctx, "world")
+	r.NoError(err)
+	r.EqualValues(4, n, "the word counters should continue after the imported counts")
+	r.NoError(b.TearDown(ctx))
+}
+
+// testBackupRestore checks that a full and an incremental backup of backends implementing model.Backuper can be
+// restored into an empty backend
+func testBackupRestore(t *testing.T, open Opener) {
+	r := require.New(t)
+	ctx := context.Background()
+	b, c := setup(t, open)
+	bu, ok := b.(model.Backuper)
+	if !ok {
+		t.Skip("not a model.Backuper")
+	}
+	count(t, c, 2, map[string]int{"hello": 2})
+	var full, incr bytes.Buffer
+	since, err := bu.Backup(ctx, &full, 0)
+	r.NoError(err)
+	count(t, c, 0, map[string]int{"hello": 1, "world": 1})
+	_, err = bu.Backup(ctx, &incr, since)
+	r.NoError(err)
+	r.NoError(b.TearDown(ctx))
+
+	// restored before EnsureDB, like the db restore command does
+	b, c = open(t)
+	t.Cleanup(func() {
+		_ = b.Close(context.Background())
+	})
+	r.NoError(b.(model.Backuper).Restore(ctx, &full))
+	r.NoError(b.(model.Backuper).Restore(ctx, &incr))
+	r.NoError(b.EnsureDB(ctx))
+	n, err := c.IncWord(ctx, "hello")
+	r.NoError(err)
+	r.EqualValues(4, n, "the full and incremental backups should both be restored")
+	n, err = c.IncWord(ctx, "world")
+	r.NoError(err)
+	r.EqualValues(2, n)
+	n, err = c.IncGlobal(ctx)
+	r.NoError(err)
+	r.Greater(n, uint64(2), "the global counter shouldn't start over")
+	r.NoError(b.TearDown(ctx))
+}
@@ -0,0 +1,118 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newClient returns the client of the config: a failover client with MasterName, a cluster client with Cluster or
+// several addresses and otherwise a plain client. They are all a redis.UniversalClient, so the rest of the code
+// doesn't know which
+func (c Config) newClient() (redis.UniversalClient, error) {
+	opts, err := c.options()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c.MasterName != "" && c.Cluster:
+		return nil, errors.New("redis: Sentinel and Cluster can't be combined")
+	case opts.DB != 0 && (c.Cluster || (c.MasterName == "" && len(opts.Addrs) > 1)):
+		return nil, fmt.Errorf("redis: a cluster only has database 0, not %d", opts.DB)
+	case c.Cluster:
+		return redis.NewClusterClient(opts.Cluster()), nil
+	}
+	return redis.NewUniversalClient(opts), nil
+}
+
+// options returns the client options, from the URL in Addr if it's one and then the other settings
+func (c Config) options() (*redis.UniversalOptions, error) {
+	opts := &redis.UniversalOptions{}
+	if strings.Contains(c.Addr, "://") {
+		u, err := redis.ParseURL(c.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid URL: %w", err)
+		}
+		opts = &redis.UniversalOptions{
+			Addrs:        []string{u.Addr},
+			Username:     u.Username,
+			Password:     u.Password,
+			DB:           u.DB,
+			TLSConfig:    u.TLSConfig,
+			PoolSize:     u.PoolSize,
+			MinIdleConns: u.MinIdleConns,
+			PoolTimeout:  u.PoolTimeout,
+			DialTimeout:  u.DialTimeout,
+			ReadTimeout:  u.ReadTimeout,
+			WriteTimeout: u.WriteTimeout,
+		}
+	} else {
+		for _, a := range strings.Split(c.Addr, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				opts.Addrs = append(opts.Addrs, a)
+			}
+		}
+	}
+
+	override(&opts.Username, c.User)
+	override(&opts.Password, c.Pass)
+	override(&opts.DB, c.DB)
+	override(&opts.MasterName, c.MasterName)
+	override(&opts.SentinelPassword, c.SentinelPass)
+	override(&opts.PoolSize, c.PoolSize)
+	override(&opts.MinIdleConns, c.MinIdleConns)
+	override(&opts.PoolTimeout, c.PoolTimeout)
+	override(&opts.DialTimeout, c.DialTimeout)
+	override(&opts.ReadTimeout, c.ReadTimeout)
+	override(&opts.WriteTimeout, c.WriteTimeout)
+
+	tlsConf, err := c.tlsConfig(opts.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	opts.TLSConfig = tlsConf
+	return opts, nil
+}
+
+// tlsConfig adds the TLS settings to conf, which is nil unless the URL was rediss://. nil is returned without TLS
+func (c Config) tlsConfig(conf *tls.Config) (*tls.Config, error) {
+	if !c.TLS && c.TLSCA == "" && c.TLSCert == "" && conf == nil {
+		return nil, nil
+	}
+	if conf == nil {
+		conf = &tls.Config{}
+	}
+	conf.MinVersion = tls.VersionTLS12
+	if c.TLSCA != "" {
+		pem, err := os.ReadFile(c.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("redis: failed to read the CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("redis: no certificates in the CA file %s", c.TLSCA)
+		}
+		conf.RootCAs = pool
+	}
+	if c.TLSCert != "" || c.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("redis: failed to load the client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// override sets *dst to v unless v is the zero value
+func override[T comparable](dst *T, v T) {
+	var zero T
+	if v != zero {
+		*dst = v
+	}
+}
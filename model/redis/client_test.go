@@ -0,0 +1,126 @@
+package redis_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/model/modeltest"
+	"github.com/jonmol/http-skeleton/model/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitConformanceCluster(t *testing.T) {
+	var (
+		mut     sync.Mutex
+		servers = map[string]*miniredis.Miniredis{}
+	)
+	modeltest.Run(t, func(t *testing.T) (model.Backend, model.Counter) {
+		// miniredis answers CLUSTER SLOTS as a cluster of one node
+		mut.Lock()
+		srv, ok := servers[t.Name()]
+		if !ok {
+			srv = miniredis.RunT(t)
+			servers[t.Name()] = srv
+		}
+		mut.Unlock()
+
+		db := redis.NewWithConfig(context.Background(), redis.Config{Addr: srv.Addr(), Cluster: true})
+		require.NoError(t, db.Open(context.Background()))
+		return db, db.Counter
+	})
+}
+
+func TestUnitURL(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	srv := miniredis.RunT(t)
+	srv.RequireUserAuth("counter", "secret")
+
+	db := redis.NewWithConfig(ctx, redis.Config{Addr: "redis://counter:secret@" + srv.Addr() + "/3"})
+	r.NoError(db.Open(ctx))
+	_, err := db.Counter.IncWord(ctx, "hello")
+	r.NoError(err)
+	r.Equal([]string{"http-skeleton:c:w:hello"}, srv.DB(3).Keys())
+	r.Empty(srv.DB(0).Keys())
+	r.NoError(db.Close(ctx))
+
+	// the settings override the URL
+	db = redis.NewWithConfig(ctx, redis.Config{Addr: "redis://" + srv.Addr() + "/3", User: "counter", Pass: "secret", DB: 4})
+	r.NoError(db.Open(ctx))
+	_, err = db.Counter.IncWord(ctx, "hello")
+	r.NoError(err)
+	r.Equal([]string{"http-skeleton:c:w:hello"}, srv.DB(4).Keys())
+	r.NoError(db.Close(ctx))
+
+	r.Error(redis.NewWithConfig(ctx, redis.Config{Addr: srv.Addr(), User: "counter", Pass: "wrong"}).Open(ctx))
+	r.ErrorContains(redis.NewWithConfig(ctx, redis.Config{Addr: "http://" + srv.Addr()}).Open(ctx), "invalid URL")
+	r.ErrorContains(redis.NewWithConfig(ctx, redis.Config{Addr: srv.Addr(), Cluster: true, DB: 1}).Open(ctx), "database 0")
+	r.ErrorContains(redis.NewWithConfig(ctx, redis.Config{Addr: srv.Addr(), Cluster: true, MasterName: "m"}).Open(ctx),
+		"can't be combined")
+}
+
+func TestUnitTLS(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	cert := selfSigned(t, caFile)
+	srv, err := miniredis.RunTLS(&tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	r.NoError(err)
+	t.Cleanup(srv.Close)
+
+	db := redis.NewWithConfig(ctx, redis.Config{Addr: srv.Addr(), TLSCA: caFile})
+	r.NoError(db.Open(ctx))
+	n, err := db.Counter.IncGlobal(ctx)
+	r.NoError(err)
+	r.Equal(uint64(1), n)
+	r.NoError(db.Close(ctx))
+
+	// rediss:// turns on TLS as well
+	db = redis.NewWithConfig(ctx, redis.Config{Addr: "rediss://" + srv.Addr(), TLSCA: caFile})
+	r.NoError(db.Open(ctx))
+	r.NoError(db.Close(ctx))
+
+	r.Error(redis.NewWithConfig(ctx, redis.Config{Addr: srv.Addr(), TLS: true}).Open(ctx), "not signed by a system CA")
+	r.Error(redis.NewWithConfig(ctx, redis.Config{Addr: srv.Addr(), DialTimeout: time.Second}).Open(ctx), "TLS is needed")
+	r.ErrorContains(redis.NewWithConfig(ctx, redis.Config{Addr: srv.Addr(), TLSCA: filepath.Join(dir, "nope")}).Open(ctx),
+		"failed to read the CA")
+}
+
+// selfSigned returns a certificate for 127.0.0.1 and writes it as the CA to caFile
+func selfSigned(t *testing.T, caFile string) tls.Certificate {
+	t.Helper()
+	r := require.New(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r.NoError(err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "miniredis"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	r.NoError(err)
+	r.NoError(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
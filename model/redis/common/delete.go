@@ -3,28 +3,100 @@ package common
 import (
 	"context"
 	"errors"
+	"sync"
 
 	"github.com/redis/go-redis/v9"
 )
 
-func DeleteAll(ctx context.Context, c *redis.Client, prefix string) (int64, error) {
-	var cursor uint64
-	var err error
+// scanCount is the number of keys asked for per SCAN
+const scanCount = 1000
+
+// DeleteAll deletes all keys starting with prefix. The keys are deleted one by one in a pipeline, in a cluster
+// they may be on different nodes
+func DeleteAll(ctx context.Context, c redis.UniversalClient, prefix string) (int64, error) {
 	var counter int64
+	err := Scan(ctx, c, prefix, func(keys []string) error {
+		cmds, err := c.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for _, k := range keys {
+				p.Del(ctx, k)
+			}
+			return nil
+		})
+		for _, cmd := range cmds {
+			if del, ok := cmd.(*redis.IntCmd); ok {
+				counter += del.Val()
+			}
+		}
+		return err
+	})
+	return counter, err
+}
+
+// Scan calls fn with the keys starting with prefix, a batch at a time. It uses SCAN so the server isn't blocked,
+// keys changed during the scan may be missed or seen twice. In a cluster every master is scanned
+func Scan(ctx context.Context, c redis.UniversalClient, prefix string, fn func(keys []string) error) error {
+	if cc, ok := c.(*redis.ClusterClient); ok {
+		// the masters are scanned concurrently, fn is still called one batch at a time
+		var mut sync.Mutex
+		return cc.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scan(ctx, node, prefix, func(keys []string) error {
+				mut.Lock()
+				defer mut.Unlock()
+				return fn(keys)
+			})
+		})
+	}
+	return scan(ctx, c, prefix, fn)
+}
+
+func scan(ctx context.Context, c redis.UniversalClient, prefix string, fn func(keys []string) error) error {
+	var cursor uint64
 	for {
-		var keys []string
-		keys, cursor, err = c.Scan(ctx, cursor, prefix, 1000).Result()
+		keys, next, err := c.Scan(ctx, cursor, prefix+"*", scanCount).Result()
 		if err != nil {
-			break
+			return err
 		}
-		i, e := c.Del(ctx, keys...).Result()
-		counter += i
-		if e != nil {
-			err = errors.Join(err, e)
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
 		}
-		if cursor == 0 {
-			break
+		if next == 0 {
+			return nil
 		}
+		cursor = next
 	}
-	return counter, err
+}
+
+// Move moves the keys in from to the keys at the same index in to, keys that are gone are skipped. It's DUMP, PTTL,
+// RESTORE and DEL pipelined instead of RENAME, which fails in a cluster when the keys are on different nodes. The
+// type and the expiry of the value are kept
+func Move(ctx context.Context, c redis.UniversalClient, from, to []string) error {
+	dumps := make([]*redis.StringCmd, len(from))
+	ttls := make([]*redis.DurationCmd, len(from))
+	_, err := c.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range from {
+			dumps[i] = p.Dump(ctx, k)
+			ttls[i] = p.PTTL(ctx, k)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	_, err = c.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, dump := range dumps {
+			val, err := dump.Result()
+			if errors.Is(err, redis.Nil) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			// PTTL is negative without an expiry, RESTORE takes 0 for that
+			p.RestoreReplace(ctx, to[i], max(ttls[i].Val(), 0), val)
+			p.Del(ctx, from[i])
+		}
+		return nil
+	})
+	return err
 }
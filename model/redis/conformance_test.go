@@ -0,0 +1,34 @@
+package redis_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/model/modeltest"
+	"github.com/jonmol/http-skeleton/model/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitConformance(t *testing.T) {
+	var (
+		mut     sync.Mutex
+		servers = map[string]*miniredis.Miniredis{}
+	)
+	modeltest.Run(t, func(t *testing.T) (model.Backend, model.Counter) {
+		// an in-process redis per test, the same one for every call in it
+		mut.Lock()
+		srv, ok := servers[t.Name()]
+		if !ok {
+			srv = miniredis.RunT(t)
+			servers[t.Name()] = srv
+		}
+		mut.Unlock()
+
+		db := redis.New(context.Background(), srv.Addr(), "")
+		require.NoError(t, db.Open(context.Background()))
+		return db, db.Counter
+	})
+}
@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/redis/go-redis/v9"
+)
+
+// Config is read from the db-* flags. The zero values keep the go-redis defaults
+type Config struct {
+	// Addr is host:port, several of them comma separated for Sentinel or Cluster, or a redis:// or rediss:// URL.
+	// The settings below override those of the URL
+	Addr string `mapstructure:"db-addr"`
+	Pass string `mapstructure:"db-pass"`
+	// Prefix is the namespace of the keys, so several services can share a database. Empty for model.DefaultPrefix
+	Prefix string `mapstructure:"db-prefix"`
+	// User is the ACL user, empty for the default user
+	User string `mapstructure:"db-redis-user"`
+	// DB is the database index, always 0 in a cluster
+	DB int `mapstructure:"db-redis-db"`
+	// MasterName is the master the Sentinels at Addr are asked for, empty to not use Sentinel
+	MasterName   string `mapstructure:"db-redis-master"`
+	SentinelPass string `mapstructure:"db-redis-sentinel-pass"`
+	// Cluster connects to a Redis Cluster, it's implied by several addresses without MasterName
+	Cluster bool `mapstructure:"db-redis-cluster"`
+
+	// TLS turns on TLS, it's implied by a rediss:// URL and the other TLS settings
+	TLS bool `mapstructure:"db-redis-tls"`
+	// TLSCA is a PEM file with the CA certificates the server is verified with, empty for the system ones
+	TLSCA string `mapstructure:"db-redis-tls-ca"`
+	// TLSCert and TLSKey are PEM files with the client certificate and key, when the server asks for one
+	TLSCert string `mapstructure:"db-redis-tls-cert"`
+	TLSKey  string `mapstructure:"db-redis-tls-key"`
+
+	// PoolSize is the max number of connections per node
+	PoolSize     int           `mapstructure:"db-redis-pool-size"`
+	MinIdleConns int           `mapstructure:"db-redis-min-idle"`
+	PoolTimeout  time.Duration `mapstructure:"db-redis-pool-timeout"`
+	DialTimeout  time.Duration `mapstructure:"db-redis-dial-timeout"`
+	ReadTimeout  time.Duration `mapstructure:"db-redis-read-timeout"`
+	WriteTimeout time.Duration `mapstructure:"db-redis-write-timeout"`
+}
+
+// setLogger sets the go-redis logger the first time a database is opened, it's global so it's only done once
+var setLogger sync.Once
+
+func init() {
+	model.Register(model.Driver{
+		Name:         "redis",
+		Capabilities: model.Capabilities{Transactions: true, TTL: true, Scans: true},
+		NewConfig:    func() any { return &Config{} },
+		Open: func(ctx context.Context, conf any) (model.Backend, model.Counter, error) {
+			c, ok := conf.(*Config)
+			if !ok {
+				return nil, nil, fmt.Errorf("redis: unexpected config %T", conf)
+			}
+			setLogger.Do(func() {
+				// go-redis logs dial failures and the like printf style to stderr
+				redis.SetLogger(logging.NewPrintf(slog.Default().With(logging.Lib("redis")), slog.LevelWarn))
+			})
+			db := NewWithConfig(ctx, *c)
+			if err := db.Open(ctx); err != nil {
+				return nil, nil, err
+			}
+			return db, db.Counter, nil
+		},
+	})
+}
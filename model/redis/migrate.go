@@ -0,0 +1,221 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/model/redis/common"
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/redis/go-redis/v9"
+)
+
+// The keys before they were namespaced, see namespaceUp
+const (
+	legacyPrefix        = "a"
+	legacyGlobal        = legacyPrefix + "globalC"
+	legacySchemaVersion = "_meta:schemaVersion"
+	// legacyLock is taken by every namespace moving the counters of the old layout, see namespaceUp
+	legacyLock = "_meta:migrationLock"
+)
+
+// LockTTL is how long the migration lock is held if the instance holding it dies without releasing it. Migrations
+// taking longer than this risk another instance starting to migrate as well
+var LockTTL = 10 * time.Minute
+
+// unlockScript only deletes the lock if it's still ours, it may have expired and been taken by another instance
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Migrations returns the migrations of the redis database
+func (db *DB) Migrations() []model.Migration {
+	return []model.Migration{
+		{Version: 1, Name: "baseline", Up: noop, Down: noop},
+		{Version: 2, Name: "namespaced keys", Up: db.namespaceUp, Down: db.namespaceDown},
+	}
+}
+
+func (db *DB) SchemaVersion(ctx context.Context) (int, error) {
+	v, err := db.db.Get(ctx, db.ns.Meta("schemaVersion")).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("redis: failed to read the schema version: %w", err)
+	}
+	return strconv.Atoi(v)
+}
+
+func (db *DB) SetSchemaVersion(ctx context.Context, version int) error {
+	return db.db.Set(ctx, db.ns.Meta("schemaVersion"), version, 0).Err()
+}
+
+// Lock sets the lock key to a random token if it isn't set, the key expires after LockTTL
+func (db *DB) Lock(ctx context.Context) (func(context.Context) error, error) {
+	return db.lock(ctx, db.ns.Meta("migrationLock"))
+}
+
+func (db *DB) lock(ctx context.Context, key string) (func(context.Context) error, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(b)
+	ok, err := db.db.SetNX(ctx, key, token, LockTTL).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis: failed to take the migration lock: %w", err)
+	}
+	if !ok {
+		return nil, model.ErrLocked
+	}
+	return func(ctx context.Context) error {
+		return unlockScript.Run(ctx, db.db, []string{key}, token).Err()
+	}, nil
+}
+
+// namespaceUp moves the counters from the keys of the old layout, "a" followed by the word or "aglobalC" for the global
+// counter, into the namespace. A word "globalC" was the global counter then. The database may be shared, so only keys
+// holding a count are moved, others starting with "a" are logged and left alone, and so are the keys of the other
+// namespaces in use, see model.ParseNamespace. The counters go to the first namespace migrating, the others wait for
+// it and find nothing left to move
+func (db *DB) namespaceUp(ctx context.Context) (err error) {
+	unlock, err := model.WaitForLock(ctx, func(ctx context.Context) (func(context.Context) error, error) {
+		return db.lock(ctx, legacyLock)
+	}, db.l)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, unlock(context.WithoutCancel(ctx)))
+	}()
+
+	inUse := map[string]bool{}
+	err = common.Scan(ctx, db.db, legacyPrefix, func(keys []string) error {
+		var candidates []string
+		for _, k := range keys {
+			if strings.HasPrefix(k, db.ns.Prefix()) {
+				continue
+			}
+			if ns, ok := model.ParseNamespace(k); ok {
+				used, err := db.namespaceInUse(ctx, ns, inUse)
+				if err != nil {
+					return err
+				}
+				if used {
+					continue
+				}
+			}
+			candidates = append(candidates, k)
+		}
+		counters, err := db.counters(ctx, candidates)
+		if err != nil {
+			return err
+		}
+		from, to := make([]string, 0, len(counters)), make([]string, 0, len(counters))
+		for _, k := range counters {
+			from = append(from, k)
+			if k == legacyGlobal {
+				to = append(to, db.ns.Global())
+			} else {
+				to = append(to, db.ns.Word(strings.TrimPrefix(k, legacyPrefix)))
+			}
+		}
+		return common.Move(ctx, db.db, from, to)
+	})
+	if err != nil {
+		return err
+	}
+	return db.db.Del(ctx, legacySchemaVersion).Err()
+}
+
+// namespaceInUse checks if one of the keys of ns.InUse exists, the answers are kept in cache
+func (db *DB) namespaceInUse(ctx context.Context, ns model.Namespace, cache map[string]bool) (bool, error) {
+	if used, ok := cache[ns.Prefix()]; ok {
+		return used, nil
+	}
+	// one EXISTS per key, in a cluster they can be on different nodes
+	cmds, err := db.db.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, k := range ns.InUse() {
+			p.Exists(ctx, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	used := false
+	for _, cmd := range cmds {
+		if exists, ok := cmd.(*redis.IntCmd); ok && exists.Val() > 0 {
+			used = true
+		}
+	}
+	cache[ns.Prefix()] = used
+	return used, nil
+}
+
+// counters returns the keys holding a count, the ones that don't are logged. Keys that are gone are skipped
+func (db *DB) counters(ctx context.Context, keys []string) ([]string, error) {
+	gets := make([]*redis.StringCmd, len(keys))
+	// errors like WRONGTYPE are checked per key below
+	_, _ = db.db.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			gets[i] = p.Get(ctx, k)
+		}
+		return nil
+	})
+	counters := make([]string, 0, len(keys))
+	for i, get := range gets {
+		v, err := get.Result()
+		var rerr redis.Error
+		switch {
+		case errors.Is(err, redis.Nil):
+		case errors.As(err, &rerr):
+			db.l.Warn("Left a key of the old layout that isn't a counter", slog.String("key", keys[i]), logging.Err(err))
+		case err != nil:
+			return nil, err
+		default:
+			if _, err := strconv.ParseUint(v, 10, 64); err != nil {
+				db.l.Warn("Left a key of the old layout that isn't a counter", slog.String("key", keys[i]))
+				continue
+			}
+			counters = append(counters, keys[i])
+		}
+	}
+	return counters, nil
+}
+
+// namespaceDown moves the counters back to the old layout, it fails if there's a word the old layout can't tell from
+// the global counter
+func (db *DB) namespaceDown(ctx context.Context) error {
+	clash := strings.TrimPrefix(legacyGlobal, legacyPrefix)
+	if n, err := db.db.Exists(ctx, db.ns.Word(clash)).Result(); err != nil {
+		return err
+	} else if n > 0 {
+		return fmt.Errorf("redis: the word %q would be the global counter in the old layout", clash)
+	}
+	return common.Scan(ctx, db.db, db.ns.Counters(), func(keys []string) error {
+		from, to := make([]string, 0, len(keys)), make([]string, 0, len(keys))
+		for _, k := range keys {
+			if w, ok := db.ns.ParseWord(k); ok {
+				from, to = append(from, k), append(to, legacyPrefix+w)
+			} else if k == db.ns.Global() {
+				from, to = append(from, k), append(to, legacyGlobal)
+			}
+		}
+		return common.Move(ctx, db.db, from, to)
+	})
+}
+
+func noop(context.Context) error {
+	return nil
+}
@@ -0,0 +1,123 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/model/redis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitNamespaces(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	srv := miniredis.RunT(t)
+
+	one := redis.NewWithConfig(ctx, redis.Config{Addr: srv.Addr(), Prefix: "one"})
+	r.NoError(one.Open(ctx))
+	two := redis.NewWithConfig(ctx, redis.Config{Addr: srv.Addr(), Prefix: "two"})
+	r.NoError(two.Open(ctx))
+
+	// the old layout had the word globalC at the key of the global counter
+	n, err := one.Counter.IncGlobal(ctx)
+	r.NoError(err)
+	r.Equal(uint64(1), n)
+	n, err = one.Counter.IncWord(ctx, "globalC")
+	r.NoError(err)
+	r.Equal(uint64(1), n)
+	n, err = two.Counter.IncWord(ctx, "globalC")
+	r.NoError(err)
+	r.Equal(uint64(1), n)
+
+	r.NoError(one.TearDown(ctx))
+	r.Equal([]string{"two:c:w:globalC"}, srv.Keys())
+	r.NoError(one.Close(ctx))
+	r.NoError(two.Close(ctx))
+
+	r.ErrorContains(redis.NewWithConfig(ctx, redis.Config{Addr: srv.Addr(), Prefix: "a*"}).Open(ctx), "invalid key prefix")
+}
+
+func TestUnitNamespaceMigration(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	srv := miniredis.RunT(t)
+	// the old layout, a service that has migrated already and keys of other applications
+	r.NoError(srv.Set("aglobalC", "7"))
+	r.NoError(srv.Set("ahello", "3"))
+	r.NoError(srv.Set("a:b", "2"))
+	r.NoError(srv.Set("afoo:c:x", "4"))
+	r.NoError(srv.Set("attl", "1"))
+	srv.SetTTL("attl", time.Hour)
+	r.NoError(srv.Set("_meta:schemaVersion", "1"))
+	r.NoError(srv.Set("api:c:w:other", "5"))
+	r.NoError(srv.Set("api:meta:schemaVersion", "2"))
+	r.NoError(srv.Set("auth_token", "secret"))
+	srv.SetTTL("auth_token", time.Hour)
+	_, err := srv.Lpush("alist", "x")
+	r.NoError(err)
+
+	open := func(prefix string) *model.DB {
+		db := model.NewModel(ctx)
+		r.NoError(db.Open(ctx, "redis", func(conf any) error {
+			*conf.(*redis.Config) = redis.Config{Addr: srv.Addr(), Prefix: prefix}
+			return nil
+		}))
+		return db
+	}
+
+	// another namespace is moving the counters
+	model.LockRetry = 10 * time.Millisecond
+	r.NoError(srv.Set("_meta:migrationLock", "someone else"))
+	db := open("svc")
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	r.ErrorContains(db.EnsureDB(short), "waiting for the migration lock")
+	srv.Del("_meta:migrationLock")
+
+	r.NoError(db.EnsureDB(ctx))
+	r.ElementsMatch([]string{
+		"svc:c:global", "svc:c:w:hello", "svc:c:w::b", "svc:c:w:foo:c:x", "svc:c:w:ttl", "svc:meta:schemaVersion",
+		"api:c:w:other", "api:meta:schemaVersion", "auth_token", "alist",
+	}, srv.Keys())
+	r.Equal(time.Hour, srv.TTL("svc:c:w:ttl"), "the expiry should be kept")
+	r.Equal(time.Hour, srv.TTL("auth_token"))
+	v, err := srv.Get("auth_token")
+	r.NoError(err)
+	r.Equal("secret", v)
+
+	// a second namespace finds nothing left to move
+	other := open("other")
+	r.NoError(other.EnsureDB(ctx))
+	r.NotContains(srv.Keys(), "other:c:global")
+	r.NoError(other.Close(ctx))
+
+	n, err := db.Counter.IncGlobal(ctx)
+	r.NoError(err)
+	r.Equal(uint64(8), n)
+	n, err = db.Counter.IncWord(ctx, "hello")
+	r.NoError(err)
+	r.Equal(uint64(4), n)
+
+	steps, err := db.Migrate(ctx, 1, false)
+	r.NoError(err)
+	r.Len(steps, 1)
+	r.ElementsMatch([]string{
+		"aglobalC", "ahello", "a:b", "afoo:c:x", "attl", "svc:meta:schemaVersion", "other:meta:schemaVersion",
+		"api:c:w:other", "api:meta:schemaVersion", "auth_token", "alist",
+	}, srv.Keys())
+	v, err = srv.Get("aglobalC")
+	r.NoError(err)
+	r.Equal("8", v)
+
+	// globalC can't go back
+	_, err = db.Migrate(ctx, model.LatestVersion, false)
+	r.NoError(err)
+	_, err = db.Counter.IncWord(ctx, "globalC")
+	r.NoError(err)
+	_, err = db.Migrate(ctx, 1, false)
+	r.ErrorContains(err, "globalC")
+	r.NoError(db.Close(ctx))
+}
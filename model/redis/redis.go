@@ -5,6 +5,8 @@ import (
 	"errors"
 	"log/slog"
 
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/model/redis/common"
 	"github.com/jonmol/http-skeleton/model/redis/sillycounter"
 	"github.com/jonmol/http-skeleton/util/logging"
 	"github.com/redis/go-redis/v9"
@@ -21,23 +23,31 @@ type Model interface {
 	Close(context.Context) error
 }
 type DB struct {
-	db      *redis.Client
+	db      redis.UniversalClient
 	l       *slog.Logger
-	addr    string
-	pass    string
+	conf    Config
+	ns      model.Namespace
 	Counter *sillycounter.SillyCounter
 }
 
+// Close closes the client, closing it again is fine
 func (db *DB) Close(_ context.Context) error {
-	return db.db.Close()
+	if err := db.db.Close(); err != nil && !errors.Is(err, redis.ErrClosed) {
+		return err
+	}
+	return nil
 }
 
 func (db *DB) EnsureDB(_ context.Context) error {
 	return nil
 }
 
-func (db *DB) TearDown(_ context.Context) error {
-	return errors.New("not implemented")
+// TearDown deletes every key in the namespace, the counters and the schema version. The keys of other services
+// sharing the database are kept
+func (db *DB) TearDown(ctx context.Context) error {
+	n, err := common.DeleteAll(ctx, db.db, db.ns.Prefix())
+	db.l.Debug("Deleted in teardown", slog.Int64("deleted", n))
+	return err
 }
 
 func (db *DB) Healthy(ctx context.Context) bool {
@@ -47,18 +57,23 @@ func (db *DB) Healthy(ctx context.Context) bool {
 	return true
 }
 
+// Open connects to a single server, Sentinel or Cluster depending on the config, see Config
 func (db *DB) Open(ctx context.Context) error {
-	client := redis.NewClient(&redis.Options{
-		Addr:     db.addr,
-		Password: db.pass,
-	})
-
-	if st := client.Ping(ctx); st.Err() != nil {
-		return st.Err()
+	ns, err := model.NewNamespace(db.conf.Prefix)
+	if err != nil {
+		return err
+	}
+	client, err := db.conf.newClient()
+	if err != nil {
+		return err
+	}
+	if err := client.Ping(ctx).Err(); err != nil {
+		return errors.Join(err, client.Close())
 	}
 
-	db.Counter = sillycounter.New(client)
+	db.Counter = sillycounter.New(client, ns)
 	db.db = client
+	db.ns = ns
 	return nil
 }
 
@@ -72,13 +87,31 @@ func (db *DB) autoclose(ctx context.Context) {
 	}()
 }
 
+// New returns a database at addr, see Config for what addr can be
 func New(ctx context.Context, addr, pass string) *DB {
+	return NewWithConfig(ctx, Config{Addr: addr, Pass: pass})
+}
+
+// NewWithConfig returns a database connected as conf says
+func NewWithConfig(ctx context.Context, conf Config) *DB {
 	l := slog.With(logging.Lib("model"))
 	db := DB{
 		l:    l,
-		addr: addr,
-		pass: pass,
+		conf: conf,
 	}
 	db.autoclose(ctx)
 	return &db
 }
+
+// Export calls fn for every counter
+func (db *DB) Export(ctx context.Context, fn func(model.Record) error) error {
+	return db.Counter.Export(ctx, fn)
+}
+
+func (db *DB) Import(ctx context.Context, rec model.Record) error {
+	return db.Counter.Import(ctx, rec)
+}
+
+func (db *DB) Count(ctx context.Context, rec model.Record) (uint64, error) {
+	return db.Counter.Count(ctx, rec)
+}
@@ -0,0 +1,64 @@
+package sillycounter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/jonmol/http-skeleton/model/redis/common"
+	"github.com/redis/go-redis/v9"
+)
+
+// Export calls fn for every counter, the values are read with a pipeline of GETs a SCAN batch at a time. Not MGET,
+// in a cluster the keys may be on different nodes
+func (s *SillyCounter) Export(ctx context.Context, fn func(model.Record) error) error {
+	seen := map[string]bool{}
+	return common.Scan(ctx, s.db, s.ns.Counters(), func(keys []string) error {
+		gets := make([]*redis.StringCmd, len(keys))
+		_, err := s.db.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for i, k := range keys {
+				gets[i] = p.Get(ctx, k)
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		for i, k := range keys {
+			str, err := gets[i].Result()
+			// SCAN can return a key twice, and it can be deleted before the GET
+			if seen[k] || errors.Is(err, redis.Nil) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			seen[k] = true
+			n, err := strconv.ParseUint(str, 10, 64)
+			if err != nil {
+				return fmt.Errorf("corrupt counter %q: %w", k, err)
+			}
+			rec := model.Record{Counter: model.RecordGlobal, Count: n}
+			if w, ok := s.ns.ParseWord(k); ok {
+				rec = model.Record{Counter: model.RecordWord, Word: w, Count: n}
+			}
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SillyCounter) Import(ctx context.Context, rec model.Record) error {
+	return s.db.Set(ctx, s.ns.RecordKey(rec), rec.Count, 0).Err()
+}
+
+func (s *SillyCounter) Count(ctx context.Context, rec model.Record) (uint64, error) {
+	n, err := s.db.Get(ctx, s.ns.RecordKey(rec)).Uint64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
@@ -2,34 +2,33 @@ package sillycounter
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
 
+	"github.com/jonmol/http-skeleton/model"
 	"github.com/jonmol/http-skeleton/model/redis/common"
+	"github.com/jonmol/http-skeleton/server/util/myctx"
 	"github.com/jonmol/http-skeleton/util/logging"
 	"github.com/redis/go-redis/v9"
 )
 
-const (
-	prefix        = "a"
-	globalCounter = prefix + "globalC"
-)
-
 type SillyCounter struct {
-	db *redis.Client
+	db redis.UniversalClient
+	ns model.Namespace
 	l  *slog.Logger
 }
 
-func New(db *redis.Client) *SillyCounter {
+// New returns the counter with the keys in ns
+func New(db redis.UniversalClient, ns model.Namespace) *SillyCounter {
 	return &SillyCounter{
 		db: db,
-		l:  slog.With(logging.Lib("badger.sillycounter")),
+		ns: ns,
+		l:  slog.With(logging.Lib("redis.sillycounter")),
 	}
 }
 
-// TearDown deletes all keys in the db with our prefix
+// TearDown deletes the counters of the namespace
 func (s *SillyCounter) TearDown(ctx context.Context) error {
-	i, err := common.DeleteAll(ctx, s.db, prefix)
+	i, err := common.DeleteAll(ctx, s.db, s.ns.Counters())
 	s.l.Debug("Deleted in teardown", slog.Int64("deleted", i))
 	return err
 }
@@ -45,17 +44,27 @@ func (s *SillyCounter) Close(_ context.Context) error {
 }
 
 func (s *SillyCounter) IncGlobal(ctx context.Context) (uint64, error) {
-	return s.incr(ctx, globalCounter)
+	return s.incr(ctx, s.ns.Global())
 }
 
 func (s *SillyCounter) IncWord(ctx context.Context, w string) (uint64, error) {
-	return s.incr(ctx, fmt.Sprintf("%s%s", prefix, w))
+	return s.incr(ctx, s.ns.Word(w))
 }
 
 func (s *SillyCounter) incr(ctx context.Context, k string) (uint64, error) {
 	res, err := s.db.Incr(ctx, k).Result()
+	s.logger(ctx).Debug("Increased counter", slog.Int64("count", res))
 	if res > 0 {
 		return uint64(res), err
 	}
 	return 0, err
 }
+
+// logger returns the request logger if there is one, so a request with debug logging turned on is followed
+// all the way down here
+func (s *SillyCounter) logger(ctx context.Context) *slog.Logger {
+	if l := myctx.LoggerOr(ctx, nil); l != nil {
+		return l.With(logging.Lib("redis.sillycounter"))
+	}
+	return s.l
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Capabilities are what a backend supports beyond the Backend and Counter interfaces, services check them with
+// DB.Require to fail at start instead of on the first request
+type Capabilities struct {
+	// Transactions can update several keys atomically
+	Transactions bool
+	// TTL can expire keys
+	TTL bool
+	// Scans can iterate over keys by prefix
+	Scans bool
+}
+
+// Missing returns the capabilities in need that c doesn't have
+func (c Capabilities) Missing(need Capabilities) []string {
+	var missing []string
+	if need.Transactions && !c.Transactions {
+		missing = append(missing, "transactions")
+	}
+	if need.TTL && !c.TTL {
+		missing = append(missing, "ttl")
+	}
+	if need.Scans && !c.Scans {
+		missing = append(missing, "scans")
+	}
+	return missing
+}
+
+// Driver is a storage backend that can be opened by name, it's registered with Register from the init function
+// of the driver package. The package then only needs to be imported, like database/sql drivers:
+//
+//	import _ "github.com/jonmol/http-skeleton/model/badger"
+type Driver struct {
+	// Name is what --db-type selects
+	Name string
+	// Capabilities are what the backend supports
+	Capabilities Capabilities
+	// NewConfig returns a pointer to the config struct of the driver with its defaults. The fields are filled from
+	// the configuration by their mapstructure tags, like `mapstructure:"db-addr"`
+	NewConfig func() any
+	// Open connects to the database, conf is what NewConfig returned. The backend is closed when ctx is done
+	Open func(ctx context.Context, conf any) (Backend, Counter, error)
+}
+
+var (
+	driversMut sync.RWMutex
+	drivers    = map[string]Driver{}
+)
+
+// Register makes a driver available by its name. It panics if the name is taken, or the driver is incomplete,
+// since that's a programming error
+func Register(d Driver) {
+	driversMut.Lock()
+	defer driversMut.Unlock()
+	if d.Name == "" || d.NewConfig == nil || d.Open == nil {
+		panic(fmt.Sprintf("model: incomplete driver %q", d.Name))
+	}
+	if _, ok := drivers[d.Name]; ok {
+		panic(fmt.Sprintf("model: driver %q registered twice", d.Name))
+	}
+	drivers[d.Name] = d
+}
+
+// Drivers returns the names of the registered drivers, sorted
+func Drivers() []string {
+	driversMut.RLock()
+	defer driversMut.RUnlock()
+	return namesLocked()
+}
+
+// LookupDriver returns the driver registered as name
+func LookupDriver(name string) (Driver, error) {
+	driversMut.RLock()
+	defer driversMut.RUnlock()
+	d, ok := drivers[name]
+	if !ok {
+		return Driver{}, fmt.Errorf("unknown database %q, registered are %s", name, strings.Join(namesLocked(), "|"))
+	}
+	return d, nil
+}
+
+func namesLocked() []string {
+	names := make([]string, 0, len(drivers))
+	for n := range drivers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
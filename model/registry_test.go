@@ -0,0 +1,78 @@
+package model_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jonmol/http-skeleton/model"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeConfig struct {
+	Addr string `mapstructure:"db-addr"`
+}
+
+type fakeBackend struct {
+	addr  string
+	count uint64
+}
+
+func (f *fakeBackend) EnsureDB(context.Context) error { return nil }
+func (f *fakeBackend) TearDown(context.Context) error { return nil }
+func (f *fakeBackend) Close(context.Context) error    { return nil }
+func (f *fakeBackend) Healthy(_ context.Context) bool { return true }
+func (f *fakeBackend) IncGlobal(context.Context) (uint64, error) {
+	f.count++
+	return f.count, nil
+}
+
+func (f *fakeBackend) IncWord(context.Context, string) (uint64, error) {
+	return 1, nil
+}
+
+func init() {
+	model.Register(model.Driver{
+		Name:         "fake",
+		Capabilities: model.Capabilities{Scans: true},
+		NewConfig:    func() any { return &fakeConfig{Addr: "default"} },
+		Open: func(_ context.Context, conf any) (model.Backend, model.Counter, error) {
+			c := conf.(*fakeConfig) //nolint:errcheck // it's the config from NewConfig
+			if c.Addr == "fail" {
+				return nil, nil, errors.New("can't connect")
+			}
+			b := &fakeBackend{addr: c.Addr}
+			return b, b, nil
+		},
+	})
+}
+
+func TestUnitRegistry(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	r.Contains(model.Drivers(), "fake")
+
+	// the config is decoded into the struct of the driver
+	db := model.NewModel(ctx)
+	r.NoError(db.Open(ctx, "fake", func(conf any) error {
+		r.Equal("default", conf.(*fakeConfig).Addr)
+		conf.(*fakeConfig).Addr = "localhost"
+		return nil
+	}))
+	r.Equal("fake", db.Driver())
+	n, err := db.Counter.IncGlobal(ctx)
+	r.NoError(err)
+	r.EqualValues(1, n)
+
+	r.NoError(db.Require(model.Capabilities{Scans: true}))
+	r.EqualError(db.Require(model.Capabilities{Transactions: true, TTL: true, Scans: true}),
+		"database fake doesn't support transactions, ttl")
+
+	r.ErrorContains(model.NewModel(ctx).Open(ctx, "nope", func(any) error { return nil }), `unknown database "nope"`)
+	r.EqualError(model.NewModel(ctx).Open(ctx, "fake", func(conf any) error {
+		conf.(*fakeConfig).Addr = "fail"
+		return nil
+	}), "can't connect")
+
+	r.Panics(func() { model.Register(model.Driver{Name: "fake", NewConfig: func() any { return nil }, Open: nil}) })
+}
@@ -1,6 +1,8 @@
 package dto
 
+// Fields tagged with `log:"redact"` are masked when the struct is logged, use it for anything personal or secret
+
 // parameters for the Hello endpoint
 type InputHello struct {
-	Input string `json:"input" validate:"required,max=200"`
+	Input string `json:"input" validate:"required,max=200" log:"redact"`
 }
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jonmol/http-skeleton/instrumentation"
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The classes of the errors net/http logs on the ErrorLog. Requests with too large headers aren't among them,
+// net/http answers them with 431 without logging
+const (
+	ErrClassTLSHandshake    = "tls_handshake"
+	ErrClassHijack          = "hijack"
+	ErrClassPanic           = "panic"
+	ErrClassAccept          = "accept"
+	ErrClassSuperfluousCall = "superfluous_write_header"
+	ErrClassOther           = "other"
+)
+
+// the connection states counted, hijacked and closed connections are no longer the server's
+var connStates = []http.ConnState{http.StateNew, http.StateActive, http.StateIdle}
+
+// serverMetrics are shared by all servers, they are told apart by the server label
+type serverMetrics struct {
+	errors *prometheus.CounterVec
+	conns  *prometheus.GaugeVec
+}
+
+func newServerMetrics(appName string, reg prometheus.Registerer) *serverMetrics {
+	m := &serverMetrics{
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: appName + "_http_server_errors_total",
+			Help: "Errors logged by the net/http server, like failed TLS handshakes and panics, by class",
+		}, []string{"server", "class"}),
+		conns: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: appName + "_http_server_connections",
+			Help: "Open connections by state: new, active or idle",
+		}, []string{"server", "state"}),
+	}
+	m.errors = instrumentation.Register(reg, m.errors)
+	m.conns = instrumentation.Register(reg, m.conns)
+	return m
+}
+
+// ClassifyError returns the class of a message net/http logs on the ErrorLog
+func ClassifyError(msg string) string {
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "tls handshake error"):
+		return ErrClassTLSHandshake
+	case strings.Contains(lower, "hijack"):
+		return ErrClassHijack
+	case strings.Contains(lower, "panic serving"):
+		return ErrClassPanic
+	case strings.Contains(lower, "accept error"):
+		return ErrClassAccept
+	case strings.Contains(lower, "superfluous response.writeheader"):
+		return ErrClassSuperfluousCall
+	}
+	return ErrClassOther
+}
+
+// errorHook classifies and counts the lines net/http logs on the ErrorLog, the level depends on the class
+func errorHook(name string, metrics *serverMetrics) logging.StdHook {
+	return func(r *slog.Record) {
+		class := ClassifyError(r.Message)
+		if metrics != nil {
+			metrics.errors.WithLabelValues(name, class).Inc()
+		}
+
+		switch class {
+		case ErrClassPanic, ErrClassAccept:
+			r.Level = slog.LevelError
+		case ErrClassTLSHandshake:
+			// mostly scanners and clients giving up, it's in the metrics
+			r.Level = slog.LevelInfo
+		}
+		// panics come with the stack on the following lines, it's kept as an attribute to keep the message stable
+		first, rest, _ := strings.Cut(r.Message, "\n")
+		r.Message = first
+		r.AddAttrs(slog.String("server", name), slog.String("class", class))
+		if class == ErrClassTLSHandshake {
+			// http: TLS handshake error from 1.2.3.4:5678: EOF
+			if _, from, ok := strings.Cut(first, " from "); ok {
+				if addr, err, ok := cutAddr(from); ok {
+					r.Message = "http: TLS handshake error"
+					r.AddAttrs(slog.String("remoteAddr", addr), slog.String("err", err))
+				}
+			}
+		}
+		if rest != "" {
+			r.AddAttrs(slog.String("stack", rest))
+		}
+	}
+}
+
+// cutAddr splits "1.2.3.4:5678: EOF" and "[::1]:5678: EOF" into the address and the error
+func cutAddr(s string) (string, string, bool) {
+	i := strings.Index(s, ": ")
+	for i >= 0 {
+		if _, _, err := net.SplitHostPort(s[:i]); err == nil {
+			return s[:i], s[i+2:], true
+		}
+		next := strings.Index(s[i+2:], ": ")
+		if next < 0 {
+			break
+		}
+		i += 2 + next
+	}
+	return "", "", false
+}
+
+// connTracker keeps the connection state gauges up to date
+type connTracker struct {
+	name    string
+	metrics *serverMetrics
+	// states has the last counted state of each connection
+	states sync.Map
+}
+
+func newConnTracker(name string, m *serverMetrics) *connTracker {
+	t := &connTracker{name: name, metrics: m}
+	for _, s := range connStates {
+		m.conns.WithLabelValues(name, s.String())
+	}
+	return t
+}
+
+func (t *connTracker) connState(c net.Conn, state http.ConnState) {
+	if prev, ok := t.states.Load(c); ok {
+		t.metrics.conns.WithLabelValues(t.name, prev.(http.ConnState).String()).Dec() //nolint:errcheck // only states are stored
+	}
+	switch state {
+	case http.StateNew, http.StateActive, http.StateIdle:
+		t.states.Store(c, state)
+		t.metrics.conns.WithLabelValues(t.name, state.String()).Inc()
+	default:
+		t.states.Delete(c)
+	}
+}
+
+// instrumentation returns the ErrorLog logging to l and, with metrics, the ConnState hook of the server
+func (s *Server) instrumentation(l *slog.Logger) (*log.Logger, func(net.Conn, http.ConnState)) {
+	el := logging.NewPrintf(l, slog.LevelWarn).StdLogger(errorHook(s.name, s.metrics))
+	if s.metrics == nil {
+		return el, nil
+	}
+	return el, newConnTracker(s.name, s.metrics).connState
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is written by the server goroutines and read by the test
+type syncBuffer struct {
+	mut sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.String()
+}
+
+func TestUnitClassifyError(t *testing.T) {
+	r := require.New(t)
+	r.Equal(ErrClassTLSHandshake, ClassifyError("http: TLS handshake error from 127.0.0.1:1234: EOF"))
+	r.Equal(ErrClassPanic, ClassifyError("http: panic serving 127.0.0.1:1234: oops"))
+	r.Equal(ErrClassHijack, ClassifyError("http: response.Write on hijacked connection from main.handler"))
+	r.Equal(ErrClassSuperfluousCall, ClassifyError("http: superfluous response.WriteHeader call from main.handler"))
+	r.Equal(ErrClassAccept, ClassifyError("http: Accept error: too many open files; retrying in 5ms"))
+	r.Equal(ErrClassOther, ClassifyError("something else"))
+}
+
+func TestUnitInstrument(t *testing.T) {
+	r := require.New(t)
+	reg := prometheus.NewRegistry()
+	s := New(time.Second, time.Second, time.Second, time.Second, 0, 1024, "").Instrument("api", "app", reg)
+	var buf syncBuffer
+	errLog, connState := s.instrumentation(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		panic("oops")
+	}))
+	ts.Config.ErrorLog = errLog
+	ts.Config.ConnState = connState
+	ts.StartTLS()
+	defer ts.Close()
+
+	// a client that isn't speaking TLS
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	r.NoError(err)
+	_, err = c.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	r.NoError(err)
+	_, _ = c.Read(make([]byte, 1024))
+	r.NoError(c.Close())
+
+	_, err = ts.Client().Get(ts.URL) //nolint:bodyclose // the handler panics, there is no body
+	r.Error(err)
+
+	r.Eventually(func() bool {
+		return testutil.ToFloat64(s.metrics.errors.WithLabelValues("api", ErrClassPanic)) == 1 &&
+			testutil.ToFloat64(s.metrics.errors.WithLabelValues("api", ErrClassTLSHandshake)) == 1
+	}, time.Second, 10*time.Millisecond)
+	r.Contains(buf.String(), `"msg":"http: TLS handshake error","server":"api","class":"tls_handshake","remoteAddr":"127.0.0.1:`)
+	r.Contains(buf.String(), `"level":"ERROR"`)
+	r.Contains(buf.String(), `"class":"panic"`)
+	r.Contains(buf.String(), `"stack":"goroutine`)
+
+	// all connections are closed, none should be left in any state
+	ts.CloseClientConnections()
+	r.Eventually(func() bool {
+		for _, state := range connStates {
+			if testutil.ToFloat64(s.metrics.conns.WithLabelValues("api", state.String())) != 0 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestUnitConnState(t *testing.T) {
+	r := require.New(t)
+	reg := prometheus.NewRegistry()
+	s := New(time.Second, time.Second, time.Second, time.Minute, 0, 1024, "").Instrument("api", "app", reg)
+	_, connState := s.instrumentation(slog.Default())
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	ts.Config.ConnState = connState
+	ts.Start()
+	defer ts.Close()
+
+	res, err := ts.Client().Get(ts.URL)
+	r.NoError(err)
+	r.NoError(res.Body.Close())
+
+	// the keep alive connection is idle after the request
+	idle := s.metrics.conns.WithLabelValues("api", http.StateIdle.String())
+	r.Eventually(func() bool { return testutil.ToFloat64(idle) == 1 }, time.Second, 10*time.Millisecond)
+	r.Zero(testutil.ToFloat64(s.metrics.conns.WithLabelValues("api", http.StateActive.String())))
+	r.Zero(testutil.ToFloat64(s.metrics.conns.WithLabelValues("api", http.StateNew.String())))
+
+	ts.CloseClientConnections()
+	r.Eventually(func() bool { return testutil.ToFloat64(idle) == 0 }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jonmol/http-skeleton/server/util/myctx"
+	"github.com/jonmol/http-skeleton/server/util/request"
+)
+
+// AccessLogConfig configures the access log middleware
+type AccessLogConfig struct {
+	// Logger is where the access log lines are written, nil means the default logger at the time of the request
+	Logger *slog.Logger
+	// SampleRate is the share of successful requests to log, 0 <= SampleRate <= 1. Errors and slow requests
+	// are always logged
+	SampleRate float64
+	// Slow requests are always logged, 0 turns it off
+	Slow time.Duration
+	// TrustedProxies are the proxies allowed to set X-Forwarded-For and Forwarded
+	TrustedProxies []netip.Prefix
+}
+
+// NewAccessLog returns a middleware writing one log line per request with method, route template, status,
+// bytes, duration, client IP, user agent, authenticated subject and trace ID. Server errors are logged as
+// errors, client errors and slow requests as warnings and the rest as info. Only a sample of the successful
+// requests are logged if AccessLogConfig.SampleRate is below 1.
+//
+// It should be added right after the recovery middleware. A panic is logged as an error with the status the recovery
+// middleware sends, a 500, unless the handler had already sent one
+func NewAccessLog(conf AccessLogConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			aw := &statusWriter{ResponseWriter: w}
+			done := false
+
+			defer func() {
+				// a panic is on its way up to the recovery middleware, it responds with a 500 if nothing was sent
+				panicked := !done
+				if aw.status == 0 && panicked {
+					aw.status = http.StatusInternalServerError
+				} else if aw.status == 0 {
+					aw.status = http.StatusOK
+				}
+				logAccess(r, aw, time.Since(start), panicked, &conf)
+			}()
+
+			next.ServeHTTP(aw, r)
+			done = true
+		})
+	}
+}
+
+func logAccess(r *http.Request, aw *statusWriter, dur time.Duration, panicked bool, conf *AccessLogConfig) {
+	slow := conf.Slow > 0 && dur > conf.Slow
+
+	var lvl slog.Level
+	switch {
+	case aw.status >= http.StatusInternalServerError || panicked:
+		lvl = slog.LevelError
+	case aw.status >= http.StatusBadRequest || slow:
+		lvl = slog.LevelWarn
+	default:
+		if conf.SampleRate < 1 && rand.Float64() >= conf.SampleRate { //nolint:gosec // sampling doesn't need crypto
+			return
+		}
+		lvl = slog.LevelInfo
+	}
+
+	l := conf.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("route", routeTemplate(r)),
+		slog.Int("status", aw.status),
+		slog.Int("bytes", aw.bytes),
+		slog.Duration("duration", dur),
+		slog.String("clientIP", request.ClientIP(r, conf.TrustedProxies)),
+		slog.String("userAgent", r.UserAgent()),
+	}
+	if s := myctx.ScopeFromCtx(r.Context()); s != nil {
+		if sub := s.Subject(); sub != "" {
+			attrs = append(attrs, slog.String("subject", sub))
+		}
+		if t := s.TraceID(); t != "" {
+			attrs = append(attrs, slog.String(traceIDLog, t))
+		}
+	}
+	if slow {
+		attrs = append(attrs, slog.Bool("slow", true))
+	}
+	if panicked {
+		attrs = append(attrs, slog.Bool("panic", true))
+	}
+
+	l.LogAttrs(context.Background(), lvl, "access", attrs...)
+}
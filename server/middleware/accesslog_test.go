@@ -0,0 +1,70 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jonmol/http-skeleton/server/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitAccessLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		sample  float64
+		logged  bool
+		status  int
+		level   string
+	}{
+		{name: "ok", handler: func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("hello")) }, sample: 1, logged: true, status: http.StatusOK, level: "INFO"},
+		{name: "sampled away", handler: func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("hello")) }, sample: 0, logged: false},
+		{name: "client error", handler: func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNotFound) }, sample: 0, logged: true, status: http.StatusNotFound, level: "WARN"},
+		{name: "slow", handler: func(w http.ResponseWriter, _ *http.Request) { time.Sleep(5 * time.Millisecond) }, sample: 0, logged: true, status: http.StatusOK, level: "WARN"},
+		{name: "panic", handler: func(http.ResponseWriter, *http.Request) { panic("boom") }, sample: 0, logged: true, status: http.StatusInternalServerError, level: "ERROR"},
+		{name: "panic after writing", handler: func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusAccepted); panic("boom") }, sample: 0, logged: true, status: http.StatusAccepted, level: "ERROR"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := require.New(t)
+			var buf bytes.Buffer
+			acc := middleware.NewAccessLog(middleware.AccessLogConfig{
+				Logger:     slog.New(slog.NewJSONHandler(&buf, nil)),
+				SampleRate: test.sample,
+				Slow:       time.Millisecond,
+			})
+			recovery := middleware.NewRecoveryHandler(middleware.RecoveryConfig{AppName: "test", Group: "public"})
+			ctxHandler := middleware.NewContextHandler("X-Trace", false)
+
+			router := mux.NewRouter()
+			router.Use(recovery, acc, ctxHandler)
+			router.HandleFunc("/users/{id}", test.handler)
+
+			req := httptest.NewRequest(http.MethodGet, "/users/42", http.NoBody)
+			req.Header.Set("User-Agent", "tester")
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			if !test.logged {
+				r.Empty(buf.String())
+				return
+			}
+
+			var line map[string]any
+			r.NoError(json.Unmarshal(buf.Bytes(), &line))
+			r.Equal("access", line["msg"])
+			r.Equal(test.level, line["level"])
+			r.Equal("/users/{id}", line["route"])
+			r.InDelta(test.status, line["status"], 0)
+			r.Equal("192.0.2.1", line["clientIP"])
+			r.Equal("tester", line["userAgent"])
+			r.NotEmpty(line["traceID"])
+		})
+	}
+}
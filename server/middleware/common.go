@@ -1 +1,59 @@
 package middleware
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// statusWriter keeps track of the status code and the amount of body bytes written. It's the one wrapper of the
+// middlewares, only the body is counted since the headers are only known once written and Write can be called
+// without WriteHeader. Unwrap lets http.ResponseController reach Flush, Hijack and the deadlines of the writer below
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += n
+	return n, err
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// Status returns the status code sent, http.StatusOK if nothing has been written yet
+func (sw *statusWriter) Status() int {
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+	return sw.status
+}
+
+// Written is true once the response has been started, then it's too late to send another status
+func (sw *statusWriter) Written() bool {
+	return sw.status != 0
+}
+
+// routeTemplate returns the path template of the matched route, like /users/{id}, empty if there is none
+func routeTemplate(r *http.Request) string {
+	if cr := mux.CurrentRoute(r); cr != nil {
+		if t, err := cr.GetPathTemplate(); err == nil {
+			return t
+		}
+	}
+	return ""
+}
@@ -0,0 +1,39 @@
+package middleware_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jonmol/http-skeleton/server/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+func TestUnitResponseController(t *testing.T) {
+	r := require.New(t)
+	var buf bytes.Buffer
+	router := mux.NewRouter()
+	router.Use(
+		middleware.NewRecoveryHandler(middleware.RecoveryConfig{AppName: "test", Group: "public"}),
+		middleware.NewAccessLog(middleware.AccessLogConfig{Logger: slog.New(slog.NewJSONHandler(&buf, nil)), SampleRate: 1}),
+		middleware.NewContextHandler("X-Trace", false),
+		middleware.NewPromMiddleware(middleware.PromConfig{AppName: "test", EndpointType: "public", Counted: true, Registerer: prometheus.NewRegistry()}),
+		middleware.NewOTelMetrics(sdkmetric.NewMeterProvider(), true, true, true),
+	)
+	router.HandleFunc("/stream", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("first"))
+		// streaming responses flush through all the wrappers of the middlewares
+		r.NoError(http.NewResponseController(w).Flush())
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream", http.NoBody))
+	r.True(rec.Flushed)
+	r.Equal("first", rec.Body.String())
+	r.Contains(buf.String(), `"status":200`)
+}
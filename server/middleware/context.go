@@ -4,32 +4,59 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/gorilla/mux"
 	"github.com/jonmol/http-skeleton/server/ckeys"
 	"github.com/jonmol/http-skeleton/server/util/myctx"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	traceIDLog = "traceID"
-	pathLog    = "requestPath"
+	traceIDLog     = "traceID"
+	pathLog        = "requestPath"
+	otelTraceIDLog = "trace_id"
+	otelSpanIDLog  = "span_id"
 )
 
+// ContextOption configures optional parts of NewContextHandler
+type ContextOption func(*contextConfig)
+
+type contextConfig struct {
+	debugHeader string
+	debugSecret []byte
+	tracer      trace.Tracer
+	propagator  propagation.TextMapPropagator
+}
+
 // NewContextHandler returns a new middleware for logging and tracing. If headerName is empty and pathLogging is false
 // nothing is done apart from adding a logger to the context.
 //
+// With the WithTracing option the W3C traceparent and tracestate headers are read, a server span named after the
+// route template is started for the request and trace_id and span_id are added to the logger. The headers are also
+// written to the response so the client can find the trace.
+//
 // If headerName is not empty the header is checked, if it has the header and it's UUID it's added to the logger so all
 // logging adds the UUID for the request to the logs. This helps with tracing a single or multiple (in case the client
-// supplies the header in its request) requests
+// supplies the header in its request) requests. If tracing is on this is only used as a fallback when there is no
+// valid trace, ie the client didn't send traceparent and no OTEL SDK is set up.
 //
 // If pathLogging is true all log printing with the logger will add the request path to ease understanding which endpoint
 // is logging.
-func NewContextHandler(headerName string, pathLogging bool) mux.MiddlewareFunc {
+//
+// With the WithDebugHeader option a request with a valid signed debug header gets a logger logging at debug level.
+func NewContextHandler(headerName string, pathLogging bool, opts ...ContextOption) mux.MiddlewareFunc {
 	traceOn := false
 	if headerName != "" {
 		traceOn = true
 	}
+	conf := contextConfig{}
+	for _, o := range opts {
+		o(&conf)
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
@@ -37,11 +64,23 @@ func NewContextHandler(headerName string, pathLogging bool) mux.MiddlewareFunc {
 				// this middleware can be added multiple times. If it is, then we want to make sure it's only adding a
 				// traceID and logger to the context once, so do nothing.
 				next.ServeHTTP(w, r)
+				return
 			}
 
 			l := slog.Default()
-			if traceOn {
-				traceID := r.Header.Get(headerName)
+			traceID := ""
+			var span trace.Span
+			if conf.tracer != nil {
+				ctx, span = conf.startSpan(ctx, r)
+				if sc := span.SpanContext(); sc.IsValid() {
+					traceID = sc.TraceID().String()
+					l = l.With(otelTraceIDLog, traceID, otelSpanIDLog, sc.SpanID().String())
+					conf.propagator.Inject(ctx, propagation.HeaderCarrier(w.Header()))
+				}
+			}
+
+			if traceOn && traceID == "" {
+				traceID = r.Header.Get(headerName)
 				if traceID != "" { // validate it, and reset if not a a uuid
 					uu := uuid.FromStringOrNil(traceID)
 					if uu.IsNil() {
@@ -53,19 +92,42 @@ func NewContextHandler(headerName string, pathLogging bool) mux.MiddlewareFunc {
 					traceID = uuid.Must(uuid.NewV4()).String()
 				}
 				w.Header().Add(headerName, traceID)
-				ctx = context.WithValue(r.Context(), ckeys.TraceID, traceID)
-				l = slog.With(traceIDLog, traceID)
+				l = l.With(traceIDLog, traceID)
 			}
-			if pathLogging {
-				if l != nil {
-					l = l.With(pathLog, r.URL.Path)
-				} else {
-					l = slog.With(pathLog, r.URL.Path)
+
+			if traceID != "" {
+				ctx = context.WithValue(ctx, ckeys.TraceID, traceID)
+				if s := myctx.ScopeFromCtx(ctx); s != nil {
+					s.SetTraceID(traceID)
 				}
 			}
+			if pathLogging {
+				l = l.With(pathLog, r.URL.Path)
+			}
 			ctx = context.WithValue(ctx, ckeys.CtxDone, true)
 			ctx = myctx.WithLogger(ctx, l)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			if conf.debugHeader != "" {
+				if token := r.Header.Get(conf.debugHeader); token != "" {
+					if VerifyDebugToken(conf.debugSecret, token, time.Now()) {
+						ctx = myctx.WithDebug(ctx)
+					} else {
+						l.Warn("Invalid debug token")
+					}
+				}
+			}
+
+			if span == nil {
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			sw := &statusWriter{ResponseWriter: w}
+			done := false
+			defer func() {
+				endSpan(span, sw, done)
+			}()
+			next.ServeHTTP(sw, r.WithContext(ctx))
+			done = true
 		})
 	}
 }
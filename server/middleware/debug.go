@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithDebugHeader turns on debug logging for requests having the header with a valid token signed with secret,
+// see SignDebugToken. That way a single request can be followed through the logs without turning on debug
+// logging for everything
+func WithDebugHeader(header string, secret []byte) ContextOption {
+	if header != "" && len(secret) == 0 {
+		panic("The debug header needs a secret")
+	}
+	return func(c *contextConfig) {
+		c.debugHeader = header
+		c.debugSecret = secret
+	}
+}
+
+// SignDebugToken creates a token for the debug header valid until expires. The format is
+// <unix expiry>.<base64url HMAC-SHA256 of the expiry>
+func SignDebugToken(secret []byte, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + debugMAC(secret, exp)
+}
+
+// VerifyDebugToken checks that the token is signed with secret and hasn't expired
+func VerifyDebugToken(secret []byte, token string, now time.Time) bool {
+	exp, mac, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(debugMAC(secret, exp)))
+}
+
+func debugMAC(secret []byte, msg string) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(msg))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
@@ -0,0 +1,63 @@
+package middleware_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jonmol/http-skeleton/server/middleware"
+	"github.com/jonmol/http-skeleton/server/util/myctx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitDebugToken(t *testing.T) {
+	r := require.New(t)
+	secret := []byte("secret")
+	now := time.Now()
+
+	token := middleware.SignDebugToken(secret, now.Add(time.Minute))
+	r.True(middleware.VerifyDebugToken(secret, token, now))
+	r.False(middleware.VerifyDebugToken(secret, token, now.Add(2*time.Minute)), "expired")
+	r.False(middleware.VerifyDebugToken([]byte("other"), token, now), "wrong secret")
+	r.False(middleware.VerifyDebugToken(secret, "9999999999."+token[len("9999999999."):], now), "tampered expiry")
+	r.False(middleware.VerifyDebugToken(secret, "garbage", now))
+}
+
+func TestUnitDebugHeader(t *testing.T) {
+	secret := []byte("secret")
+	tests := []struct {
+		name   string
+		token  string
+		logged bool
+	}{
+		{name: "no header", token: "", logged: false},
+		{name: "valid", token: middleware.SignDebugToken(secret, time.Now().Add(time.Minute)), logged: true},
+		{name: "expired", token: middleware.SignDebugToken(secret, time.Now().Add(-time.Minute)), logged: false},
+	}
+
+	def := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(def) })
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+			ctxHandler := middleware.NewContextHandler("", false, middleware.WithDebugHeader("X-Debug", secret))
+			h := ctxHandler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				myctx.LoggerFromCtx(r.Context()).Debug("deep down")
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			if test.token != "" {
+				req.Header.Set("X-Debug", test.token)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			require.Equal(t, test.logged, bytes.Contains(buf.Bytes(), []byte("deep down")))
+		})
+	}
+}
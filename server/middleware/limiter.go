@@ -0,0 +1,279 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jonmol/http-skeleton/instrumentation"
+	"github.com/jonmol/http-skeleton/server/util/response"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Priority decides in which order requests are let through when the limiter is saturated. A higher priority
+// is admitted before a lower one and is allowed to use more of the limit
+type Priority int
+
+const (
+	// PriorityPublic is for the public endpoints, they can only use LimiterConfig.PublicShare of the limit
+	PriorityPublic Priority = iota
+	// PriorityPrivate is for the private endpoints, they can use the full limit
+	PriorityPrivate
+	// PriorityCritical is never shed nor queued, meant for health checks and similar
+	PriorityCritical
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityPublic:
+		return "public"
+	case PriorityPrivate:
+		return "private"
+	case PriorityCritical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+// LimiterConfig configures the adaptive concurrency limiter
+type LimiterConfig struct {
+	InitialLimit int           // the limit to start with
+	MinLimit     int           // the limit never goes below this
+	MaxLimit     int           // the limit never goes above this
+	Latency      time.Duration // requests slower than this are treated as a sign of overload
+	Backoff      float64       // the limit is multiplied with this on overload, 0 < Backoff < 1
+	PublicShare  float64       // the share of the limit public traffic is allowed to use, 0 < PublicShare <= 1
+	MaxQueue     int           // how many requests may wait for a free slot, 0 sheds immediately
+	QueueTimeout time.Duration // how long a request may wait in the queue
+	RetryAfter   time.Duration // sent as Retry-After to shed clients
+	AppName      string        // used as metric prefix
+	// Registerer is where the metrics are registered, nil turns them off
+	Registerer prometheus.Registerer
+}
+
+type waiter struct {
+	prio  Priority
+	ready chan struct{}
+}
+
+// Limiter caps the amount of in-flight requests. The limit is adapted with AIMD (additive increase,
+// multiplicative decrease) based on the observed latency: every request faster than LimiterConfig.Latency
+// grows the limit with 1/limit, so roughly by one per full window, and a slower one multiplies it with
+// LimiterConfig.Backoff, at most once per LimiterConfig.Latency so a burst of slow requests in flight together
+// only backs off once. When the limit is reached requests are queued by priority, and once the queue is full
+// or the wait is too long they are shed with 503 and Retry-After.
+//
+// One Limiter is meant to be shared by all route groups since they share the same resources, each group gets
+// its own middleware with Limiter.Middleware and the priority for it.
+type Limiter struct {
+	mut      sync.Mutex
+	conf     LimiterConfig
+	limit    float64
+	inflight int
+	queue    []*waiter
+	// backedOff is when the limit was last cut, see release
+	backedOff time.Time
+
+	mLimit    prometheus.Gauge
+	mInflight *prometheus.GaugeVec
+	mQueue    *prometheus.GaugeVec
+	mShed     *prometheus.CounterVec
+}
+
+// NewLimiter creates a new Limiter, it panics on nonsensical configuration
+func NewLimiter(conf LimiterConfig) *Limiter {
+	if conf.MinLimit < 1 || conf.MaxLimit < conf.MinLimit {
+		panic("The limiter needs 1 <= min limit <= max limit")
+	}
+	if conf.Backoff <= 0 || conf.Backoff >= 1 {
+		panic("The limiter backoff has to be between 0 and 1")
+	}
+	if conf.PublicShare <= 0 || conf.PublicShare > 1 {
+		panic("The limiter public share has to be between 0 and 1")
+	}
+
+	l := &Limiter{
+		conf:  conf,
+		limit: math.Max(float64(conf.MinLimit), math.Min(float64(conf.InitialLimit), float64(conf.MaxLimit))),
+	}
+	if conf.Registerer != nil {
+		l.registerMetrics()
+	}
+	return l
+}
+
+func (l *Limiter) registerMetrics() {
+	reg := l.conf.Registerer
+	l.mLimit = instrumentation.Register(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: fmt.Sprintf("%s_limiter_limit", l.conf.AppName),
+		Help: "The current concurrency limit",
+	}))
+	l.mInflight = instrumentation.Register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: fmt.Sprintf("%s_limiter_inflight", l.conf.AppName),
+		Help: "Requests currently being handled",
+	}, []string{"group"}))
+	l.mQueue = instrumentation.Register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: fmt.Sprintf("%s_limiter_queue", l.conf.AppName),
+		Help: "Requests waiting for a free slot",
+	}, []string{"group"}))
+	l.mShed = instrumentation.Register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: fmt.Sprintf("%s_limiter_shed", l.conf.AppName),
+		Help: "Requests rejected because of overload",
+	}, []string{"group"}))
+	l.mLimit.Set(l.limit)
+}
+
+// Limit returns the current limit
+func (l *Limiter) Limit() int {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return int(l.limit)
+}
+
+// Middleware returns a middleware that limits the requests with the given priority
+func (l *Limiter) Middleware(prio Priority) mux.MiddlewareFunc {
+	retryAfter := strconv.Itoa(int(math.Ceil(l.conf.RetryAfter.Seconds())))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.acquire(r, prio) {
+				l.shed(prio)
+				w.Header().Set("Retry-After", retryAfter)
+				response.JSONErrorResponse(r.Context(), w, response.Unavailable, "service overloaded, try again later")
+				return
+			}
+
+			start := time.Now()
+			defer func() {
+				l.release(prio, time.Since(start))
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// capacity is how many in-flight requests there can be for a request of priority p to be admitted
+func (l *Limiter) capacity(p Priority) int {
+	switch p {
+	case PriorityCritical:
+		return math.MaxInt
+	case PriorityPrivate:
+		return int(l.limit)
+	default:
+		return max(1, int(l.limit*l.conf.PublicShare))
+	}
+}
+
+// acquire returns true when the request may proceed, false if it should be shed. Must be followed by a
+// release if true is returned
+func (l *Limiter) acquire(r *http.Request, p Priority) bool {
+	l.mut.Lock()
+	if len(l.queue) == 0 && l.inflight < l.capacity(p) {
+		l.admit(p)
+		l.mut.Unlock()
+		return true
+	}
+	if len(l.queue) >= l.conf.MaxQueue {
+		l.mut.Unlock()
+		return false
+	}
+
+	w := &waiter{prio: p, ready: make(chan struct{})}
+	l.enqueue(w)
+	l.mut.Unlock()
+
+	timer := time.NewTimer(l.conf.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-w.ready:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	if !l.dequeue(w) {
+		// it was admitted while we were timing out, so it holds a slot
+		return true
+	}
+	return false
+}
+
+// release frees the slot and adapts the limit based on the latency of the request
+func (l *Limiter) release(p Priority, latency time.Duration) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if latency > l.conf.Latency {
+		// the requests in flight during the slow spell all end slow, they're one sign of overload and not many
+		if now := time.Now(); now.Sub(l.backedOff) >= l.conf.Latency {
+			l.limit = math.Max(float64(l.conf.MinLimit), l.limit*l.conf.Backoff)
+			l.backedOff = now
+		}
+	} else if float64(l.inflight) >= l.limit/2 {
+		// only grow when the limit is actually used, otherwise it would grow forever when idle
+		l.limit = math.Min(float64(l.conf.MaxLimit), l.limit+1/l.limit)
+	}
+
+	l.inflight--
+	if l.mInflight != nil {
+		l.mInflight.WithLabelValues(p.String()).Dec()
+		l.mLimit.Set(l.limit)
+	}
+
+	// wake up as many waiters as there is room for, the queue is sorted by priority
+	for len(l.queue) > 0 && l.inflight < l.capacity(l.queue[0].prio) {
+		w := l.queue[0]
+		l.dequeue(w)
+		l.admit(w.prio)
+		close(w.ready)
+	}
+}
+
+// admit must be called with the mutex held
+func (l *Limiter) admit(p Priority) {
+	l.inflight++
+	if l.mInflight != nil {
+		l.mInflight.WithLabelValues(p.String()).Inc()
+	}
+}
+
+// enqueue adds w after all waiters with the same or higher priority. Must be called with the mutex held
+func (l *Limiter) enqueue(w *waiter) {
+	i := len(l.queue)
+	for i > 0 && l.queue[i-1].prio < w.prio {
+		i--
+	}
+	l.queue = append(l.queue, nil)
+	copy(l.queue[i+1:], l.queue[i:])
+	l.queue[i] = w
+	if l.mQueue != nil {
+		l.mQueue.WithLabelValues(w.prio.String()).Inc()
+	}
+}
+
+// dequeue removes w from the queue, returns false if it wasn't there. Must be called with the mutex held
+func (l *Limiter) dequeue(w *waiter) bool {
+	for i, q := range l.queue {
+		if q == w {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			if l.mQueue != nil {
+				l.mQueue.WithLabelValues(w.prio.String()).Dec()
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Limiter) shed(p Priority) {
+	if l.mShed != nil {
+		l.mShed.WithLabelValues(p.String()).Inc()
+	}
+}
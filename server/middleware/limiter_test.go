@@ -0,0 +1,173 @@
+package middleware_test
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonmol/http-skeleton/server/middleware"
+	"github.com/jonmol/http-skeleton/server/util/myctx"
+	"github.com/stretchr/testify/require"
+)
+
+func limiterConf() middleware.LimiterConfig {
+	return middleware.LimiterConfig{
+		InitialLimit: 2,
+		MinLimit:     1,
+		MaxLimit:     2,
+		Latency:      time.Minute,
+		Backoff:      0.5,
+		PublicShare:  0.5,
+		QueueTimeout: 10 * time.Millisecond,
+		RetryAfter:   1500 * time.Millisecond,
+	}
+}
+
+// blockingCall starts a request through h which doesn't finish until the returned function is called
+func blockingCall(t *testing.T, h http.Handler, block chan struct{}) func() {
+	t.Helper()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	started := make(chan struct{})
+	go func() {
+		defer wg.Done()
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req = req.WithContext(myctx.WithLogger(context.Background(), slog.Default()))
+		close(started)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-started
+	time.Sleep(10 * time.Millisecond) // give it time to get a slot
+	return func() {
+		close(block)
+		wg.Wait()
+	}
+}
+
+func call(h http.Handler) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req = req.WithContext(myctx.WithLogger(context.Background(), slog.Default()))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestUnitLimiterPriority(t *testing.T) {
+	r := require.New(t)
+	lim := middleware.NewLimiter(limiterConf())
+
+	block := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		<-block
+		w.WriteHeader(http.StatusOK)
+	})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	public := lim.Middleware(middleware.PriorityPublic)
+	private := lim.Middleware(middleware.PriorityPrivate)
+	critical := lim.Middleware(middleware.PriorityCritical)
+
+	// the public share is one slot out of two, so a second public request is shed while private still fits
+	done := blockingCall(t, public(handler), block)
+
+	resp := call(public(ok))
+	r.Equal(http.StatusServiceUnavailable, resp.Code)
+	r.Equal("2", resp.Header().Get("Retry-After"))
+	r.JSONEq(`{"error":{"code":"unavailable","msg":"service overloaded, try again later"}}`, resp.Body.String())
+
+	r.Equal(http.StatusOK, call(private(ok)).Code)
+	r.Equal(http.StatusOK, call(critical(ok)).Code)
+
+	done()
+	r.Equal(http.StatusOK, call(public(ok)).Code)
+}
+
+func TestUnitLimiterQueue(t *testing.T) {
+	r := require.New(t)
+	conf := limiterConf()
+	conf.MaxLimit = 1
+	conf.MaxQueue = 1
+	conf.QueueTimeout = time.Second
+	lim := middleware.NewLimiter(conf)
+	private := lim.Middleware(middleware.PriorityPrivate)
+
+	block := make(chan struct{})
+	done := blockingCall(t, private(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		<-block
+		w.WriteHeader(http.StatusOK)
+	})), block)
+
+	// the queued request gets the slot as soon as the blocking one is done
+	res := make(chan int)
+	go func() {
+		res <- call(private(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }))).Code
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// the queue is full so this one is shed right away
+	r.Equal(http.StatusServiceUnavailable, call(private(http.NotFoundHandler())).Code)
+
+	done()
+	r.Equal(http.StatusOK, <-res)
+}
+
+func TestUnitLimiterAdapts(t *testing.T) {
+	r := require.New(t)
+	conf := limiterConf()
+	conf.InitialLimit = 8
+	conf.MaxLimit = 10
+	conf.Latency = 5 * time.Millisecond
+	lim := middleware.NewLimiter(conf)
+	private := lim.Middleware(middleware.PriorityPrivate)
+
+	call(private(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})))
+	r.Equal(4, lim.Limit(), "slow requests should back off")
+
+	for i := 0; i < 3; i++ {
+		call(private(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })))
+	}
+	r.Equal(4, lim.Limit(), "the limit shouldn't grow when it isn't used")
+}
+
+func TestUnitLimiterBacksOffOncePerWindow(t *testing.T) {
+	r := require.New(t)
+	conf := limiterConf()
+	conf.InitialLimit = 8
+	conf.MaxLimit = 10
+	conf.Latency = 50 * time.Millisecond
+	lim := middleware.NewLimiter(conf)
+	private := lim.Middleware(middleware.PriorityPrivate)
+
+	// four slow requests finishing together are one slow spell
+	block := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			call(private(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				<-block
+				w.WriteHeader(http.StatusOK)
+			})))
+		}()
+	}
+	time.Sleep(2 * conf.Latency)
+	close(block)
+	wg.Wait()
+	r.Equal(4, lim.Limit(), "a burst of slow requests should back off once")
+
+	// the next window backs off again
+	time.Sleep(conf.Latency)
+	call(private(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(2 * conf.Latency)
+		w.WriteHeader(http.StatusOK)
+	})))
+	r.Equal(2, lim.Limit())
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const meterName = "github.com/jonmol/http-skeleton/server/middleware"
+
+// durationBuckets are the bucket boundaries recommended by the HTTP semantic conventions
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10}
+
+type otelMetrics struct {
+	duration     metric.Float64Histogram
+	requestSize  metric.Int64Histogram
+	responseSize metric.Int64Histogram
+	active       metric.Int64UpDownCounter
+}
+
+// NewOTelMetrics is the OTEL counterpart of NewPromMiddleware, it records the HTTP semantic convention instruments
+// with mp, labelled by method, route template and status code:
+// http.server.request.duration (timed or counted, the count of the histogram is the request counter)
+// http.server.active_requests (counted)
+// http.server.request.body.size and http.server.response.body.size (sized)
+// it will panic if all three are off, then you shouldn't use it
+func NewOTelMetrics(mp metric.MeterProvider, counted, sized, timed bool) mux.MiddlewareFunc {
+	if !counted && !sized && !timed {
+		panic("All three measures are off, turn off the middleware instead!")
+	}
+
+	m, err := newOTelMetrics(mp.Meter(meterName))
+	if err != nil {
+		panic(err)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			scheme := "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+			base := []attribute.KeyValue{semconv.HTTPRequestMethodKey.String(r.Method), semconv.URLScheme(scheme)}
+			if counted {
+				m.active.Add(ctx, 1, metric.WithAttributes(base...))
+				defer m.active.Add(ctx, -1, metric.WithAttributes(base...))
+			}
+
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+
+			attrs := make([]attribute.KeyValue, 0, len(base)+2)
+			attrs = append(attrs, base...)
+			attrs = append(attrs, semconv.HTTPResponseStatusCode(sw.Status()))
+			if route := routeTemplate(r); route != "" {
+				attrs = append(attrs, semconv.HTTPRoute(route))
+			}
+			opt := metric.WithAttributes(attrs...)
+			if timed || counted {
+				m.duration.Record(ctx, time.Since(start).Seconds(), opt)
+			}
+			if sized {
+				if r.ContentLength >= 0 {
+					m.requestSize.Record(ctx, r.ContentLength, opt)
+				}
+				m.responseSize.Record(ctx, int64(sw.bytes), opt)
+			}
+		})
+	}
+}
+
+func newOTelMetrics(meter metric.Meter) (*otelMetrics, error) {
+	var (
+		m   otelMetrics
+		err error
+	)
+	if m.duration, err = meter.Float64Histogram(semconv.HTTPServerRequestDurationName,
+		metric.WithUnit(semconv.HTTPServerRequestDurationUnit),
+		metric.WithDescription(semconv.HTTPServerRequestDurationDescription),
+		metric.WithExplicitBucketBoundaries(durationBuckets...)); err != nil {
+		return nil, err
+	}
+	if m.requestSize, err = meter.Int64Histogram(semconv.HTTPServerRequestBodySizeName,
+		metric.WithUnit(semconv.HTTPServerRequestBodySizeUnit),
+		metric.WithDescription(semconv.HTTPServerRequestBodySizeDescription)); err != nil {
+		return nil, err
+	}
+	if m.responseSize, err = meter.Int64Histogram(semconv.HTTPServerResponseBodySizeName,
+		metric.WithUnit(semconv.HTTPServerResponseBodySizeUnit),
+		metric.WithDescription(semconv.HTTPServerResponseBodySizeDescription)); err != nil {
+		return nil, err
+	}
+	if m.active, err = meter.Int64UpDownCounter(semconv.HTTPServerActiveRequestsName,
+		metric.WithUnit(semconv.HTTPServerActiveRequestsUnit),
+		metric.WithDescription(semconv.HTTPServerActiveRequestsDescription)); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
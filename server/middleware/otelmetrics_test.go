@@ -0,0 +1,87 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jonmol/http-skeleton/server/middleware"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+func collectMetrics(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	out := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			out[m.Name] = m.Data
+		}
+	}
+	return out
+}
+
+func TestUnitOTelMetrics(t *testing.T) {
+	r := require.New(t)
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	router := mux.NewRouter()
+	router.Use(middleware.NewOTelMetrics(mp, true, true, true))
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("hello"))
+	})
+
+	for _, id := range []string{"1", "2"} {
+		req := httptest.NewRequest(http.MethodPost, "/users/"+id, strings.NewReader("body"))
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	metrics := collectMetrics(t, reader)
+	want := attribute.NewSet(
+		semconv.HTTPRequestMethodKey.String(http.MethodPost),
+		semconv.URLScheme("http"),
+		semconv.HTTPResponseStatusCode(http.StatusCreated),
+		semconv.HTTPRoute("/users/{id}"),
+	)
+
+	duration := metrics[semconv.HTTPServerRequestDurationName].(metricdata.Histogram[float64])
+	r.Len(duration.DataPoints, 1, "both requests share the route template")
+	r.Equal(uint64(2), duration.DataPoints[0].Count)
+	r.True(want.Equals(&duration.DataPoints[0].Attributes))
+
+	resp := metrics[semconv.HTTPServerResponseBodySizeName].(metricdata.Histogram[int64])
+	r.Equal(int64(10), resp.DataPoints[0].Sum)
+	req := metrics[semconv.HTTPServerRequestBodySizeName].(metricdata.Histogram[int64])
+	r.Equal(int64(8), req.DataPoints[0].Sum)
+
+	active := metrics[semconv.HTTPServerActiveRequestsName].(metricdata.Sum[int64])
+	r.Equal(int64(0), active.DataPoints[0].Value)
+}
+
+func TestUnitOTelMetricsSelection(t *testing.T) {
+	r := require.New(t)
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	router := mux.NewRouter()
+	router.Use(middleware.NewOTelMetrics(mp, false, true, false))
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+
+	metrics := collectMetrics(t, reader)
+	r.Contains(metrics, semconv.HTTPServerResponseBodySizeName)
+	r.NotContains(metrics, semconv.HTTPServerRequestDurationName)
+	r.NotContains(metrics, semconv.HTTPServerActiveRequestsName)
+
+	r.Panics(func() { middleware.NewOTelMetrics(mp, false, false, false) })
+}
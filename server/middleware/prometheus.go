@@ -1,131 +1,193 @@
 package middleware
 
 import (
-	"bytes"
 	"fmt"
-	"log/slog"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/jonmol/http-skeleton/instrumentation"
+	"github.com/jonmol/http-skeleton/server/util/myctx"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// TrackedWriter is just adding a bytes counter to http.ResponseWriter
-// to be able to
-type TrackedWriter struct {
-	http.ResponseWriter
-	Bytes int
+// DefaultSizeBuckets are the response size buckets used if PromConfig.SizeBuckets is empty, 100B to 10MB
+var DefaultSizeBuckets = prometheus.ExponentialBuckets(100, 10, 6)
+
+// PromConfig configures NewPromMiddleware
+type PromConfig struct {
+	// AppName and EndpointType make up the metric names, <AppName>_<EndpointType>_endpoint_<metric>
+	AppName      string
+	EndpointType string
+	// Counted, Sized and Timed turn on the request counter and in-flight gauge, the response size histogram
+	// and the response time histogram
+	Counted bool
+	Sized   bool
+	Timed   bool
+	// Routes maps route templates to their methods. The series for them are created up front so that they
+	// show up with 0 before the first request
+	Routes map[string][]string
+	// DurationBuckets are the response time buckets in seconds, prometheus.DefBuckets if empty
+	DurationBuckets []float64
+	// SizeBuckets are the response size buckets in bytes, DefaultSizeBuckets if empty
+	SizeBuckets []float64
+	// NativeHistograms adds native histograms next to the classic buckets, they need to be turned on in
+	// Prometheus as well
+	NativeHistograms bool
+	// Exemplars adds the trace ID of the request as an exemplar, it's only exposed in the OpenMetrics format
+	Exemplars bool
+	// Registerer defaults to prometheus.DefaultRegisterer
+	Registerer prometheus.Registerer
 }
 
-func (t *TrackedWriter) Write(b []byte) (int, error) {
-	n, err := t.ResponseWriter.Write(b)
-	t.Bytes += n
-	return n, err
-}
-
-func (t *TrackedWriter) WriteHeader(code int) {
-	t.ResponseWriter.WriteHeader(code)
-	var buf bytes.Buffer
-	err := t.Header().Write(&buf)
-	if err != nil {
-		slog.Error("Failed to write response code", logging.Err(err))
-	}
-	t.Bytes += buf.Len()
-}
+var promLabels = []string{"route", "method", "status"}
 
-// NewPromMiddleware creates a new Prometheus middleware. It excludes routes with {pathVariables} as they
-// become "infinitely" many with for instance userID in the path. It can meassure 3 values:
-// request count per endpoint (counter)
-// response size per endpoint (gauge)
-// response time per endpoint (gauge)
+// NewPromMiddleware creates a new Prometheus middleware. The metrics are labelled by route template, like
+// /users/{id}, method and status class, like 2xx, that way routes with path variables don't become "infinitely"
+// many series. It can meassure these values:
+// request count per endpoint (counter) and requests in flight (gauge)
+// response size per endpoint (histogram)
+// response time per endpoint in seconds (histogram)
 // one or two can be disabled, but it will panic if all three are, then you shouldn't use it
-func NewPromMiddleware(appname, endpointType string, counted, sized, timed bool, paths []string) mux.MiddlewareFunc {
-	if !counted && !sized && !timed {
+func NewPromMiddleware(conf PromConfig) mux.MiddlewareFunc {
+	if !conf.Counted && !conf.Sized && !conf.Timed {
 		panic("All three measures are off, turn off the middleware instead!")
 	}
-
-	// only instrument paths without path variables
-	validP := make([]string, 0, len(paths))
-	for _, p := range paths {
-		if !strings.Contains(p, "{") {
-			validP = append(validP, p)
-		}
-	}
-
-	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: fmt.Sprintf("%s_%s_endpoint_counter", appname, endpointType),
-		Help: "Counter for the endpoints",
-	},
-		[]string{"endpoint"},
-	)
-
-	sizes := prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name: fmt.Sprintf("%s_%s_endpoint_sizes", appname, endpointType),
-		Help: "The size of the responses",
-	}, []string{"endpoint"})
-
-	times := prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name: fmt.Sprintf("%s_%s_endpoint_times", appname, endpointType),
-		Help: "The time it takes to send a response",
-	}, []string{"endpoint"})
-
-	registerMetrics(times, sizes, counter, timed, sized, counted, validP)
+	m := newPromMetrics(&conf)
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			inc(counter, counted, r.URL.Path)
+			route := routeTemplate(r)
+			if route == "" {
+				route = "unknown"
+			}
+			if conf.Counted {
+				g := m.inflight.WithLabelValues(route, r.Method)
+				g.Inc()
+				defer g.Dec()
+			}
+
+			ctx, scope := myctx.WithScope(r.Context())
 			d := time.Now()
-			tw := &TrackedWriter{ResponseWriter: w}
-			if sized {
-				next.ServeHTTP(tw, r)
-			} else {
-				next.ServeHTTP(w, r)
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			labels := prometheus.Labels{"route": route, "method": r.Method, "status": statusClass(sw.Status())}
+			var exemplar prometheus.Labels
+			if conf.Exemplars {
+				if t := scope.TraceID(); t != "" {
+					exemplar = prometheus.Labels{"trace_id": t}
+				}
+			}
+
+			if conf.Counted {
+				add(m.counter.With(labels), exemplar)
+			}
+			if conf.Timed {
+				observe(m.times.With(labels), time.Since(d).Seconds(), exemplar)
+			}
+			if conf.Sized {
+				observe(m.sizes.With(labels), float64(sw.bytes), exemplar)
 			}
-			observe(times, timed, r.URL.Path, time.Since(d))
-			observe(sizes, sized, r.URL.Path, tw.Bytes)
 		})
 	}
 }
 
-func inc(counter *prometheus.CounterVec, active bool, path string) {
-	if active {
-		if c, err := counter.GetMetricWith(prometheus.Labels{"endpoint": path}); err == nil {
-			c.Inc()
-		}
-	}
+type promMetrics struct {
+	counter  *prometheus.CounterVec
+	inflight *prometheus.GaugeVec
+	sizes    *prometheus.HistogramVec
+	times    *prometheus.HistogramVec
 }
 
-func observe[V int | time.Duration](h *prometheus.HistogramVec, active bool, path string, val V) {
-	if active {
-		if c, err := h.GetMetricWith(prometheus.Labels{"endpoint": path}); err == nil {
-			c.Observe(float64(val))
+func newPromMetrics(conf *PromConfig) *promMetrics {
+	reg := conf.Registerer
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	name := func(metric string) string {
+		return fmt.Sprintf("%s_%s_endpoint_%s", conf.AppName, conf.EndpointType, metric)
+	}
+	histOpts := func(metric, help string, buckets []float64) prometheus.HistogramOpts {
+		opts := prometheus.HistogramOpts{Name: name(metric), Help: help, Buckets: buckets}
+		if conf.NativeHistograms {
+			opts.NativeHistogramBucketFactor = 1.1
+			opts.NativeHistogramMaxBucketNumber = 100
+			opts.NativeHistogramMinResetDuration = time.Hour
 		}
+		return opts
 	}
-}
 
-func registerMetrics(t, s *prometheus.HistogramVec, c *prometheus.CounterVec, ta, sa, ca bool, paths []string) {
-	if ca {
-		prometheus.DefaultRegisterer.MustRegister(c)
+	sizeBuckets := conf.SizeBuckets
+	if len(sizeBuckets) == 0 {
+		sizeBuckets = DefaultSizeBuckets
 	}
-	if sa {
-		prometheus.DefaultRegisterer.MustRegister(s)
+	durationBuckets := conf.DurationBuckets
+	if len(durationBuckets) == 0 {
+		durationBuckets = prometheus.DefBuckets
 	}
-	if ta {
-		prometheus.DefaultRegisterer.MustRegister(t)
+
+	m := &promMetrics{
+		counter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: name("counter"),
+			Help: "Counter for the endpoints",
+		}, promLabels),
+		inflight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: name("inflight"),
+			Help: "Requests being handled right now",
+		}, promLabels[:2]),
+		sizes: prometheus.NewHistogramVec(histOpts("sizes", "The size of the response bodies in bytes", sizeBuckets), promLabels),
+		// it was <app>_<type>_endpoint_times in nanoseconds, renamed with the unit so old dashboards don't misread it
+		times: prometheus.NewHistogramVec(histOpts("duration_seconds", "The time it takes to send a response in seconds", durationBuckets), promLabels),
 	}
 
-	for _, p := range paths {
-		if ca {
-			c.With(prometheus.Labels{"endpoint": p})
-		}
-		if sa {
-			s.With(prometheus.Labels{"endpoint": p})
-		}
-		if ta {
-			t.With(prometheus.Labels{"endpoint": p})
+	if conf.Counted {
+		m.counter = instrumentation.Register(reg, m.counter)
+		m.inflight = instrumentation.Register(reg, m.inflight)
+	}
+	if conf.Sized {
+		m.sizes = instrumentation.Register(reg, m.sizes)
+	}
+	if conf.Timed {
+		m.times = instrumentation.Register(reg, m.times)
+	}
+
+	for route, methods := range conf.Routes {
+		for _, method := range methods {
+			l := prometheus.Labels{"route": route, "method": method, "status": statusClass(http.StatusOK)}
+			if conf.Counted {
+				m.counter.With(l)
+				m.inflight.WithLabelValues(route, method)
+			}
+			if conf.Sized {
+				m.sizes.With(l)
+			}
+			if conf.Timed {
+				m.times.With(l)
+			}
 		}
 	}
+	return m
+}
+
+// statusClass turns 404 into 4xx
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+func add(c prometheus.Counter, exemplar prometheus.Labels) {
+	if ea, ok := c.(prometheus.ExemplarAdder); ok && exemplar != nil {
+		ea.AddWithExemplar(1, exemplar)
+		return
+	}
+	c.Inc()
+}
+
+func observe(o prometheus.Observer, val float64, exemplar prometheus.Labels) {
+	if eo, ok := o.(prometheus.ExemplarObserver); ok && exemplar != nil {
+		eo.ObserveWithExemplar(val, exemplar)
+		return
+	}
+	o.Observe(val)
 }
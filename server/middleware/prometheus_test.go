@@ -0,0 +1,111 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jonmol/http-skeleton/server/middleware"
+	"github.com/jonmol/http-skeleton/server/util/myctx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func promRouter(t *testing.T, conf middleware.PromConfig) *mux.Router {
+	t.Helper()
+	router := mux.NewRouter()
+	router.Use(middleware.NewPromMiddleware(conf))
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// stand-in for the context middleware
+			if s := myctx.ScopeFromCtx(r.Context()); s != nil {
+				s.SetTraceID("4bf92f3577b34da6a3ce929d0e0e4736")
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		_, _ = w.Write([]byte("hello"))
+	})
+	return router
+}
+
+func TestUnitPromMiddleware(t *testing.T) {
+	r := require.New(t)
+	reg := prometheus.NewRegistry()
+	conf := middleware.PromConfig{
+		AppName: "app", EndpointType: "public", Counted: true, Sized: true, Timed: true,
+		Routes:     map[string][]string{"/users/{id}": {http.MethodGet}, "/other": {http.MethodPost}},
+		Registerer: reg,
+	}
+	router := promRouter(t, conf)
+
+	for _, id := range []string{"1", "2", "missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/"+id, http.NoBody))
+	}
+
+	expected := `
+# HELP app_public_endpoint_counter Counter for the endpoints
+# TYPE app_public_endpoint_counter counter
+app_public_endpoint_counter{method="GET",route="/users/{id}",status="2xx"} 2
+app_public_endpoint_counter{method="GET",route="/users/{id}",status="4xx"} 1
+app_public_endpoint_counter{method="POST",route="/other",status="2xx"} 0
+# HELP app_public_endpoint_inflight Requests being handled right now
+# TYPE app_public_endpoint_inflight gauge
+app_public_endpoint_inflight{method="GET",route="/users/{id}"} 0
+app_public_endpoint_inflight{method="POST",route="/other"} 0
+`
+	r.NoError(testutil.GatherAndCompare(reg, strings.NewReader(expected), "app_public_endpoint_counter", "app_public_endpoint_inflight"))
+
+	// only the body is counted, with and without WriteHeader
+	mfs, err := reg.Gather()
+	r.NoError(err)
+	for _, mf := range mfs {
+		if mf.GetName() != "app_public_endpoint_sizes" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			h := m.GetHistogram()
+			r.Equal(float64(5*h.GetSampleCount()), h.GetSampleSum())
+		}
+	}
+
+	// a rebuilt router, like on SIGHUP, keeps using the registered metrics
+	r.NotPanics(func() { promRouter(t, conf) })
+}
+
+func TestUnitPromMiddlewareExemplars(t *testing.T) {
+	r := require.New(t)
+	reg := prometheus.NewRegistry()
+	router := promRouter(t, middleware.PromConfig{
+		AppName: "app", EndpointType: "public", Timed: true, Exemplars: true, NativeHistograms: true,
+		DurationBuckets: []float64{0.5, 1},
+		Registerer:      reg,
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", http.NoBody))
+
+	mfs, err := reg.Gather()
+	r.NoError(err)
+	r.Len(mfs, 1)
+	r.Equal("app_public_endpoint_duration_seconds", mfs[0].GetName())
+	h := mfs[0].GetMetric()[0].GetHistogram()
+	r.Len(h.GetBucket(), 2)
+	r.Greater(h.GetSchema(), int32(0), "native histogram")
+
+	var exemplar *dto.Exemplar
+	for _, b := range h.GetBucket() {
+		if b.GetExemplar() != nil {
+			exemplar = b.GetExemplar()
+		}
+	}
+	r.NotNil(exemplar)
+	r.Equal("trace_id", exemplar.GetLabel()[0].GetName())
+	r.Equal("4bf92f3577b34da6a3ce929d0e0e4736", exemplar.GetLabel()[0].GetValue())
+}
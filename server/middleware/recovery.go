@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/gorilla/mux"
+	"github.com/jonmol/http-skeleton/instrumentation"
+	"github.com/jonmol/http-skeleton/server/util/myctx"
+	"github.com/jonmol/http-skeleton/server/util/response"
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RecoveryConfig configures the panic recovery middleware
+type RecoveryConfig struct {
+	AppName string // used as metric prefix
+	Group   string // the route group, used as metric label
+	// CrashDir is where crash reports are written, empty turns them off
+	CrashDir string
+	// Registerer is where the panic counter is registered, nil turns it off
+	Registerer prometheus.Registerer
+}
+
+// CrashReport is what is written to RecoveryConfig.CrashDir, one file per panic
+type CrashReport struct {
+	Time    time.Time `json:"time"`
+	Group   string    `json:"group"`
+	Method  string    `json:"method"`
+	Path    string    `json:"path"`
+	TraceID string    `json:"traceID,omitempty"`
+	Panic   string    `json:"panic"`
+	Stack   string    `json:"stack"`
+}
+
+// NewRecoveryHandler returns a middleware which recovers from panics in the handlers. It should be the first
+// middleware so that it covers the other middlewares as well. On a panic it:
+//   - responds with the Internal error code, unless the handler already started writing the response
+//   - logs the panic and stack with the request logger, so the trace ID is there if the context middleware is used
+//   - increments the panic counter
+//   - writes a crash report to RecoveryConfig.CrashDir if set
+//
+// http.ErrAbortHandler is passed on since it's used to deliberately abort a response.
+func NewRecoveryHandler(conf RecoveryConfig) mux.MiddlewareFunc {
+	var counter *prometheus.CounterVec
+	if conf.Registerer != nil {
+		counter = instrumentation.Register(conf.Registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: fmt.Sprintf("%s_panics", conf.AppName),
+			Help: "Panics recovered from in the handlers",
+		}, []string{"group"}))
+	}
+
+	if conf.CrashDir != "" {
+		if err := os.MkdirAll(conf.CrashDir, 0o750); err != nil {
+			slog.Error("Failed to create the crash report dir", logging.Err(err), slog.String("dir", conf.CrashDir))
+			panic("Failed to initialize the recovery middleware")
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, scope := myctx.WithScope(r.Context())
+			sw := &statusWriter{ResponseWriter: w}
+
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if err, ok := rec.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(rec)
+				}
+
+				if counter != nil {
+					counter.WithLabelValues(conf.Group).Inc()
+				}
+
+				report := CrashReport{
+					Time:    time.Now(),
+					Group:   conf.Group,
+					Method:  r.Method,
+					Path:    r.URL.Path,
+					TraceID: scope.TraceID(),
+					Panic:   fmt.Sprint(rec),
+					Stack:   string(debug.Stack()),
+				}
+
+				l := scope.Logger()
+				if l == nil {
+					l = slog.Default()
+				}
+				l.Error("Recovered from panic", slog.String("panic", report.Panic), slog.String("stack", report.Stack))
+
+				if conf.CrashDir != "" {
+					if err := writeCrashReport(conf.CrashDir, &report); err != nil {
+						l.Error("Failed to write crash report", logging.Err(err))
+					}
+				}
+
+				if !sw.Written() {
+					response.JSONErrorResponse(myctx.WithLogger(ctx, l), sw, response.Internal, "internal error")
+				}
+			}()
+
+			next.ServeHTTP(sw, r.WithContext(ctx))
+		})
+	}
+}
+
+func writeCrashReport(dir string, report *CrashReport) error {
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("crash-%s-%s.json", report.Time.UTC().Format("20060102T150405.000000000"), uuid.Must(uuid.NewV4()).String())
+	return os.WriteFile(filepath.Join(dir, name), b, 0o600)
+}
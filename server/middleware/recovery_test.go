@@ -0,0 +1,79 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jonmol/http-skeleton/server/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitRecovery(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+	reg := prometheus.NewRegistry()
+
+	recovery := middleware.NewRecoveryHandler(middleware.RecoveryConfig{AppName: "test", Group: "private", CrashDir: dir, Registerer: reg})
+	ctxHandler := middleware.NewContextHandler("X-Trace", false)
+	traceID := "a943bac0-6f72-4c71-8be0-7c505e9194a9"
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		respCode int
+		respData string
+	}{
+		{name: "no panic", handler: func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNoContent) }, respCode: http.StatusNoContent},
+		{name: "panic", handler: func(http.ResponseWriter, *http.Request) { panic("boom") }, respCode: http.StatusInternalServerError, respData: `{"error":{"code":"internal","msg":"internal error"}}`},
+		{name: "panic after write", handler: func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			panic("boom")
+		}, respCode: http.StatusAccepted},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/test/private/hello", http.NoBody)
+			req.Header.Set("X-Trace", traceID)
+			w := httptest.NewRecorder()
+
+			recovery(ctxHandler(test.handler)).ServeHTTP(w, req)
+
+			r.Equal(test.respCode, w.Code)
+			r.Equal(test.respData, w.Body.String())
+		})
+	}
+
+	files, err := os.ReadDir(dir)
+	r.NoError(err)
+	r.Len(files, 2)
+
+	b, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	r.NoError(err)
+	var report middleware.CrashReport
+	r.NoError(json.Unmarshal(b, &report))
+	r.Equal(traceID, report.TraceID)
+	r.Equal("boom", report.Panic)
+	r.Equal("/v1/test/private/hello", report.Path)
+	r.Contains(report.Stack, "recovery_test.go")
+
+	mfs, err := reg.Gather()
+	r.NoError(err)
+	r.Len(mfs, 1)
+	r.Equal("test_panics", mfs[0].GetName())
+	r.InDelta(2, mfs[0].GetMetric()[0].GetCounter().GetValue(), 0)
+}
+
+func TestUnitRecoveryAbort(t *testing.T) {
+	recovery := middleware.NewRecoveryHandler(middleware.RecoveryConfig{AppName: "test", Group: "public"})
+	h := recovery(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic(http.ErrAbortHandler) }))
+
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	})
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/jonmol/http-skeleton/server/middleware"
+
+// WithTracing makes NewContextHandler read and write the W3C traceparent/tracestate headers with prop and start
+// a server span per request from tp. Without an SDK set up tp can be a noop provider, the trace ID from the client
+// is then still used in the logs
+func WithTracing(tp trace.TracerProvider, prop propagation.TextMapPropagator) ContextOption {
+	return func(c *contextConfig) {
+		c.tracer = tp.Tracer(tracerName)
+		c.propagator = prop
+	}
+}
+
+// startSpan extracts the trace from the request headers and starts a server span following the HTTP semantic
+// conventions, named "METHOD /route/{template}"
+func (c *contextConfig) startSpan(ctx context.Context, r *http.Request) (context.Context, trace.Span) {
+	ctx = c.propagator.Extract(ctx, propagation.HeaderCarrier(r.Header))
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(r.Method),
+		semconv.URLPath(r.URL.Path),
+		semconv.URLScheme(scheme),
+		semconv.NetworkProtocolVersion(fmt.Sprintf("%d.%d", r.ProtoMajor, r.ProtoMinor)),
+	}
+	attrs = append(attrs, serverAddress(r.Host, scheme)...)
+	if ua := r.UserAgent(); ua != "" {
+		attrs = append(attrs, semconv.UserAgentOriginal(ua))
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		attrs = append(attrs, semconv.ClientAddress(host))
+	}
+
+	name := r.Method
+	if route := routeTemplate(r); route != "" {
+		name += " " + route
+		attrs = append(attrs, semconv.HTTPRoute(route))
+	}
+
+	return c.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// serverAddress returns server.address and server.port of the Host header, the port is the default of the scheme if
+// it isn't there
+func serverAddress(hostport, scheme string) []attribute.KeyValue {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = hostport, "80"
+		if scheme == "https" {
+			port = "443"
+		}
+	}
+	attrs := []attribute.KeyValue{semconv.ServerAddress(host)}
+	if p, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, semconv.ServerPort(p))
+	}
+	return attrs
+}
+
+// endSpan adds the response attributes and ends the span. If done is false the handler panicked
+func endSpan(span trace.Span, sw *statusWriter, done bool) {
+	status := sw.Status()
+	if !done {
+		status = http.StatusInternalServerError
+		span.SetStatus(codes.Error, "panic")
+	} else if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status), semconv.HTTPResponseBodySize(sw.bytes))
+	span.End()
+}
@@ -0,0 +1,99 @@
+package middleware_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jonmol/http-skeleton/server/middleware"
+	"github.com/jonmol/http-skeleton/server/util/myctx"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	parentTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	parentSpanID  = "00f067aa0ba902b7"
+	traceparent   = "00-" + parentTraceID + "-" + parentSpanID + "-01"
+)
+
+func tracedRouter(t *testing.T, tp trace.TracerProvider, buf *bytes.Buffer, status int) *mux.Router {
+	t.Helper()
+	def := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(def) })
+	slog.SetDefault(slog.New(slog.NewJSONHandler(buf, nil)))
+
+	ctxHandler := middleware.NewContextHandler("X-Trace", false, middleware.WithTracing(tp, propagation.TraceContext{}))
+	router := mux.NewRouter()
+	router.Use(ctxHandler)
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		myctx.LoggerFromCtx(r.Context()).Info("in handler")
+		w.WriteHeader(status)
+	})
+	return router
+}
+
+func TestUnitTracingSpan(t *testing.T) {
+	r := require.New(t)
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	var buf bytes.Buffer
+
+	req := httptest.NewRequest(http.MethodGet, "/users/42", http.NoBody)
+	req.Host = "api.example.com:8443"
+	req.Header.Set("traceparent", traceparent)
+	w := httptest.NewRecorder()
+	tracedRouter(t, tp, &buf, http.StatusBadGateway).ServeHTTP(w, req)
+
+	spans := rec.Ended()
+	r.Len(spans, 1)
+	span := spans[0]
+	r.Equal("GET /users/{id}", span.Name())
+	r.Equal(trace.SpanKindServer, span.SpanKind())
+	r.Equal(parentTraceID, span.SpanContext().TraceID().String())
+	r.Equal(parentSpanID, span.Parent().SpanID().String())
+	r.Equal(codes.Error, span.Status().Code)
+	r.Contains(span.Attributes(), semconv.HTTPRoute("/users/{id}"))
+	r.Contains(span.Attributes(), semconv.HTTPResponseStatusCode(http.StatusBadGateway))
+	r.Contains(span.Attributes(), attribute.String("http.request.method", http.MethodGet))
+	r.Contains(span.Attributes(), semconv.ServerAddress("api.example.com"))
+	r.Contains(span.Attributes(), semconv.ServerPort(8443))
+
+	// the span is sent back to the client, and the legacy header isn't used
+	r.Contains(w.Header().Get("traceparent"), parentTraceID+"-"+span.SpanContext().SpanID().String())
+	r.Empty(w.Header().Get("X-Trace"))
+
+	r.Contains(buf.String(), `"trace_id":"`+parentTraceID+`"`)
+	r.Contains(buf.String(), `"span_id":"`+span.SpanContext().SpanID().String()+`"`)
+}
+
+func TestUnitTracingFallback(t *testing.T) {
+	r := require.New(t)
+	var buf bytes.Buffer
+	router := tracedRouter(t, noop.NewTracerProvider(), &buf, http.StatusOK)
+
+	// without an SDK the trace from the client is still used
+	req := httptest.NewRequest(http.MethodGet, "/users/42", http.NoBody)
+	req.Header.Set("traceparent", traceparent)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	r.Contains(buf.String(), `"trace_id":"`+parentTraceID+`"`)
+
+	// and without traceparent it falls back to the legacy header
+	buf.Reset()
+	req = httptest.NewRequest(http.MethodGet, "/users/42", http.NoBody)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	r.NotEmpty(w.Header().Get("X-Trace"))
+	r.Contains(buf.String(), `"traceID":"`+w.Header().Get("X-Trace")+`"`)
+	r.NotContains(buf.String(), "trace_id")
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/jonmol/http-skeleton/server/middleware"
+	"go.opentelemetry.io/otel/metric"
 )
 
 // having three layers of paths can feel a bit clunky, but if you have a load balancer and
@@ -27,6 +28,10 @@ var (
 // Middleware is a struct of middlewares split by secure or non, where secure would
 // require a JWT token or similar, while the non-secure are public facing endpoints
 type Middleware struct {
+	// SecuredRecovery and NonSecuredRecovery are added before everything else, the metrics and tracing included,
+	// so a panic anywhere is recovered
+	SecuredRecovery      mux.MiddlewareFunc
+	NonSecuredRecovery   mux.MiddlewareFunc
 	SecuredMiddleware    []mux.MiddlewareFunc
 	NonSecuredMiddleware []mux.MiddlewareFunc
 }
@@ -36,8 +41,15 @@ type Config struct {
 	PromTiming           bool
 	PromCount            bool
 	PromSize             bool
-	Middleware           Middleware
-	ServiceName          string
+	// PromDurationBuckets (seconds) and PromSizeBuckets (bytes) are the histogram buckets, empty for the defaults
+	PromDurationBuckets []float64
+	PromSizeBuckets     []float64
+	PromNative          bool
+	PromExemplars       bool
+	// OTelMeterProvider adds the OTEL metrics middleware if set, the Prom flags pick what it measures
+	OTelMeterProvider metric.MeterProvider
+	Middleware        Middleware
+	ServiceName       string
 }
 
 type IHandler interface {
@@ -73,18 +85,28 @@ func BuildRouter(han IHandler, conf Config) *mux.Router {
 	service := version.PathPrefix(appPath).Subrouter()
 
 	private := service.PathPrefix(privatePath).Subrouter()
-	addPromeMiddleware(conf.PromethusMiddlleWare, "private", privatePath, private, eps.private, conf.PromCount, conf.PromTiming, conf.PromSize)
+	addRecovery(private, conf.Middleware.SecuredRecovery)
+	addPromeMiddleware(conf, "private", privatePath, private, eps.private)
+	addOTelMiddleware(conf, private)
 	private.Use(conf.Middleware.SecuredMiddleware...)
 	addRoutes(private, eps.private)
 
 	public := service.PathPrefix(publicPath).Subrouter()
-	addPromeMiddleware(conf.PromethusMiddlleWare, "public", publicPath, public, eps.public, conf.PromCount, conf.PromTiming, conf.PromSize)
+	addRecovery(public, conf.Middleware.NonSecuredRecovery)
+	addPromeMiddleware(conf, "public", publicPath, public, eps.public)
+	addOTelMiddleware(conf, public)
 	public.Use(conf.Middleware.NonSecuredMiddleware...)
 	addRoutes(public, eps.public)
 
 	return r
 }
 
+func addRecovery(r *mux.Router, rec mux.MiddlewareFunc) {
+	if rec != nil {
+		r.Use(rec)
+	}
+}
+
 func addRoutes(r *mux.Router, h []endpoint) {
 	for _, e := range h {
 		for _, m := range e.methods {
@@ -93,15 +115,35 @@ func addRoutes(r *mux.Router, h []endpoint) {
 	}
 }
 
-func addPromeMiddleware(on bool, epType, epPrefix string, r *mux.Router, h []endpoint, counter, timings, sizes bool) {
-	if !on {
+func addPromeMiddleware(conf Config, epType, epPrefix string, r *mux.Router, h []endpoint) {
+	if !conf.PromethusMiddlleWare {
 		return
 	}
 	baseP := versionPath + appPath + epPrefix
-	paths := make([]string, 0)
+	routes := make(map[string][]string, len(h))
 	for _, e := range h {
-		paths = append(paths, baseP+e.path)
+		for _, m := range e.methods {
+			routes[baseP+e.path] = append(routes[baseP+e.path], m.verb)
+		}
 	}
-	pMid := middleware.NewPromMiddleware(serviceName, epType, counter, sizes, timings, paths)
+	pMid := middleware.NewPromMiddleware(middleware.PromConfig{
+		AppName:          serviceName,
+		EndpointType:     epType,
+		Counted:          conf.PromCount,
+		Sized:            conf.PromSize,
+		Timed:            conf.PromTiming,
+		Routes:           routes,
+		DurationBuckets:  conf.PromDurationBuckets,
+		SizeBuckets:      conf.PromSizeBuckets,
+		NativeHistograms: conf.PromNative,
+		Exemplars:        conf.PromExemplars,
+	})
 	r.Use(pMid)
 }
+
+func addOTelMiddleware(conf Config, r *mux.Router) {
+	if conf.OTelMeterProvider == nil || (!conf.PromCount && !conf.PromTiming && !conf.PromSize) {
+		return
+	}
+	r.Use(middleware.NewOTelMetrics(conf.OTelMeterProvider, conf.PromCount, conf.PromSize, conf.PromTiming))
+}
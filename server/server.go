@@ -7,6 +7,9 @@ import (
 	"time"
 
 	"log/slog"
+
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
@@ -34,10 +37,23 @@ type Server struct {
 	idleTimeout       time.Duration
 	maxHeaderBytes    int
 	addr              string
+	name              string
+	metrics           *serverMetrics
+}
+
+// Instrument names the server in the logs and metrics. If reg isn't nil the errors net/http logs are counted in
+// <appName>_http_server_errors_total and the connections in <appName>_http_server_connections. Call it before Start
+func (s *Server) Instrument(name, appName string, reg prometheus.Registerer) *Server {
+	s.name = name
+	if reg != nil {
+		s.metrics = newServerMetrics(appName, reg)
+	}
+	return s
 }
 
 // Start starts the server on the provided listener.
 func (s *Server) Start(handler http.Handler) error {
+	errLog, connState := s.instrumentation(slog.Default().With(logging.Lib("http")))
 	s.httpServer = &http.Server{
 		Addr:              s.addr,
 		Handler:           handler,
@@ -46,6 +62,9 @@ func (s *Server) Start(handler http.Handler) error {
 		WriteTimeout:      s.writeTimeout,
 		IdleTimeout:       s.idleTimeout,
 		MaxHeaderBytes:    s.maxHeaderBytes,
+		// TLS handshake errors, panics in handlers etc would otherwise go to stderr bypassing slog
+		ErrorLog:  errLog,
+		ConnState: connState,
 	}
 
 	slog.Info("Starting http server", slog.String("address", s.addr))
@@ -76,5 +95,6 @@ func New(rt, rht, wt, it time.Duration, p, mhb int, loc string) *Server {
 		idleTimeout:       it,
 		maxHeaderBytes:    mhb,
 		addr:              fmt.Sprintf("%s:%d", loc, p),
+		name:              "http",
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/jonmol/http-skeleton/server/dto"
+	"github.com/jonmol/http-skeleton/server/util/myctx"
 	"github.com/jonmol/http-skeleton/util/logging"
 )
 
@@ -28,14 +29,17 @@ func (s Service) Hello(ctx context.Context, in dto.InputHello) (*dto.OutputHello
 	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
+	l := myctx.LoggerOr(ctx, slog.Default()).With(logging.Lib("service"))
+	l.Debug("Hello called", slog.Any("input", in))
+
 	t, err := s.c.IncGlobal(ctx)
 	if err != nil {
-		slog.Error("Failed to increase the global counter", logging.Err(err))
+		l.Error("Failed to increase the global counter", logging.Err(err))
 	}
 
 	wt, err := s.c.IncWord(ctx, in.Input)
 	if err != nil {
-		slog.Error("Failed to increase the work counter", logging.Err(err), slog.String("word", in.Input))
+		l.Error("Failed to increase the work counter", logging.Err(err), slog.Any("input", in))
 	}
 
 	if in.Input == "rude" {
@@ -3,11 +3,57 @@ package myctx
 import (
 	"context"
 	"log/slog"
+	"sync/atomic"
+
+	"github.com/jonmol/http-skeleton/util/logging"
 )
 
 type ctxString string
 
-const logKey ctxString = "logger"
+const (
+	logKey   ctxString = "logger"
+	scopeKey ctxString = "scope"
+)
+
+// Scope holds values set further down the middleware chain that the outer middlewares need after the
+// handler is done, for instance the panic recovery needs the request logger which is created after it
+// has been called. It's safe to use from multiple go routines
+type Scope struct {
+	logger  atomic.Pointer[slog.Logger]
+	traceID atomic.Pointer[string]
+	subject atomic.Pointer[string]
+}
+
+// Logger returns the last logger added with WithLogger, nil if none has been added
+func (s *Scope) Logger() *slog.Logger {
+	return s.logger.Load()
+}
+
+// TraceID returns the trace ID of the request, empty if none has been set
+func (s *Scope) TraceID() string {
+	if t := s.traceID.Load(); t != nil {
+		return *t
+	}
+	return ""
+}
+
+// SetTraceID sets the trace ID of the request
+func (s *Scope) SetTraceID(id string) {
+	s.traceID.Store(&id)
+}
+
+// Subject returns the authenticated subject of the request, empty if none has been set
+func (s *Scope) Subject() string {
+	if sub := s.subject.Load(); sub != nil {
+		return *sub
+	}
+	return ""
+}
+
+// SetSubject should be called by the authentication middleware with the user/client the request is made by
+func (s *Scope) SetSubject(sub string) {
+	s.subject.Store(&sub)
+}
 
 func LoggerFromCtx(ctx context.Context) *slog.Logger {
 	logger := ctx.Value(logKey)
@@ -19,6 +65,44 @@ func LoggerFromCtx(ctx context.Context) *slog.Logger {
 	}
 }
 
+// LoggerOr returns the logger of the context, or def if there is none. Use it in code that is called both from
+// handlers and from elsewhere, such as services and models, so the request logger is used when there is one
+func LoggerOr(ctx context.Context, def *slog.Logger) *slog.Logger {
+	if l, ok := ctx.Value(logKey).(*slog.Logger); ok {
+		return l
+	}
+	return def
+}
+
+// WithDebug replaces the logger of the context with one logging at debug level, regardless of the global level.
+// Call it from the authentication middleware if the user/token has a debug claim to follow that single request
+func WithDebug(ctx context.Context) context.Context {
+	l := LoggerOr(ctx, slog.Default())
+	return WithLogger(ctx, logging.WithLevel(l, slog.LevelDebug).With(slog.Bool("debugRequest", true)))
+}
+
+// WithLogger adds the logger to the context, if the context has a Scope the logger is set there as well
 func WithLogger(ctx context.Context, log *slog.Logger) context.Context {
+	if s := ScopeFromCtx(ctx); s != nil {
+		s.logger.Store(log)
+	}
 	return context.WithValue(ctx, logKey, log)
 }
+
+// WithScope adds a new Scope to the context. If there already is one it's reused, that way all the outer
+// middlewares see what the inner ones set
+func WithScope(ctx context.Context) (context.Context, *Scope) {
+	if s := ScopeFromCtx(ctx); s != nil {
+		return ctx, s
+	}
+	s := &Scope{}
+	return context.WithValue(ctx, scopeKey, s), s
+}
+
+// ScopeFromCtx returns the Scope of the context, nil if there is none
+func ScopeFromCtx(ctx context.Context) *Scope {
+	if s, ok := ctx.Value(scopeKey).(*Scope); ok {
+		return s
+	}
+	return nil
+}
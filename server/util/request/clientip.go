@@ -0,0 +1,113 @@
+package request
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParsePrefixes parses a list of CIDRs or plain IPs into prefixes, plain IPs become single address prefixes
+func ParsePrefixes(in []string) ([]netip.Prefix, error) {
+	res := make([]netip.Prefix, 0, len(in))
+	for _, s := range in {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if strings.Contains(s, "/") {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, netip.PrefixFrom(a.Unmap(), a.Unmap().BitLen()))
+	}
+	return res, nil
+}
+
+// ClientIP returns the IP of the client. The forwarding headers are only trusted if the request comes from one
+// of the trusted proxies, in that case the Forwarded header (RFC 7239) is used if present, otherwise
+// X-Forwarded-For. The addresses are read from right to left and the first one not being a trusted proxy is the
+// client. Anyone can set the headers, so without trusted proxies they are ignored.
+func ClientIP(r *http.Request, trusted []netip.Prefix) string {
+	remote := parseAddr(r.RemoteAddr)
+	if !remote.IsValid() {
+		return r.RemoteAddr
+	}
+	if !isTrusted(remote, trusted) {
+		return remote.String()
+	}
+
+	hops := forwardedFor(r.Header)
+	if len(hops) == 0 {
+		hops = xForwardedFor(r.Header)
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		a := parseAddr(hops[i])
+		if !a.IsValid() {
+			// garbage in the chain, nothing before it can be trusted
+			break
+		}
+		client = a
+		if !isTrusted(a, trusted) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func isTrusted(a netip.Addr, trusted []netip.Prefix) bool {
+	for _, p := range trusted {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAddr handles ip, ip:port, [ipv6] and [ipv6]:port
+func parseAddr(s string) netip.Addr {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return a.Unmap()
+}
+
+func xForwardedFor(h http.Header) []string {
+	var res []string
+	for _, v := range h.Values("X-Forwarded-For") {
+		res = append(res, strings.Split(v, ",")...)
+	}
+	return res
+}
+
+// forwardedFor extracts the for= parameters from the Forwarded header
+func forwardedFor(h http.Header) []string {
+	var res []string
+	for _, v := range h.Values("Forwarded") {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					res = append(res, val)
+				}
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,48 @@
+package request_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jonmol/http-skeleton/server/util/request"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitClientIP(t *testing.T) {
+	r := require.New(t)
+	trusted, err := request.ParsePrefixes([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	r.NoError(err)
+
+	tests := []struct {
+		name      string
+		remote    string
+		xff       []string
+		forwarded []string
+		expected  string
+	}{
+		{name: "no proxy", remote: "1.2.3.4:5678", expected: "1.2.3.4"},
+		{name: "untrusted proxy", remote: "1.2.3.4:5678", xff: []string{"5.6.7.8"}, expected: "1.2.3.4"},
+		{name: "trusted proxy", remote: "10.1.1.1:5678", xff: []string{"5.6.7.8"}, expected: "5.6.7.8"},
+		{name: "spoofed chain", remote: "10.1.1.1:5678", xff: []string{"6.6.6.6, 5.6.7.8, 192.168.1.1"}, expected: "5.6.7.8"},
+		{name: "multiple headers", remote: "10.1.1.1:5678", xff: []string{"6.6.6.6", "5.6.7.8"}, expected: "5.6.7.8"},
+		{name: "only proxies", remote: "10.1.1.1:5678", xff: []string{"10.2.2.2"}, expected: "10.2.2.2"},
+		{name: "garbage", remote: "10.1.1.1:5678", xff: []string{"5.6.7.8, nonsense"}, expected: "10.1.1.1"},
+		{name: "forwarded", remote: "10.1.1.1:5678", forwarded: []string{`for=5.6.7.8;proto=https, for="[fd00::1]:1234"`}, xff: []string{"6.6.6.6"}, expected: "5.6.7.8"},
+		{name: "forwarded ipv6", remote: "[fd00::2]:5678", forwarded: []string{`for="[2001:db8::1]:1234"`}, expected: "2001:db8::1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.RemoteAddr = test.remote
+			for _, v := range test.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			for _, v := range test.forwarded {
+				req.Header.Add("Forwarded", v)
+			}
+			require.Equal(t, test.expected, request.ClientIP(req, trusted))
+		})
+	}
+}
@@ -40,7 +40,8 @@ func HandleCall(w http.ResponseWriter, r *http.Request, data any, handler Handle
 			}
 
 			if err = json.Unmarshal(body, data); err != nil {
-				l.Error("cannot unmarshal request body", logging.Err(err), slog.String("body", string(body)))
+				// the body isn't logged since it can contain anything from passwords to personal data
+				l.Error("cannot unmarshal request body", logging.Err(err), slog.Int("bodySize", len(body)))
 				response.JSONErrorResponse(ctx, w, response.MalformedRequest, "cannot unmarshal body")
 				return
 			}
@@ -83,7 +84,7 @@ func HandleCall(w http.ResponseWriter, r *http.Request, data any, handler Handle
 func validateGet(ctx context.Context, data interface{}, w http.ResponseWriter, r *http.Request) error {
 	l := myctx.LoggerFromCtx(ctx)
 	if err := decoder.Decode(data, r.URL.Query()); err != nil {
-		l.Error("cannot decode query parameters", err, "query", r.URL.Query())
+		l.Error("cannot decode query parameters", logging.Err(err), "query", r.URL.Query())
 		response.JSONErrorResponse(ctx, w, response.MalformedRequest, "cannot unmarshal query parameters")
 		return err
 	}
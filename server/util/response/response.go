@@ -36,6 +36,8 @@ const (
 	NoContent ErrorCode = "no_content"
 	// UnprocessableEntity error code
 	UnprocessableEntity ErrorCode = "unprocessable_entity"
+	// Unavailable error code
+	Unavailable ErrorCode = "unavailable"
 )
 
 const (
@@ -56,6 +58,7 @@ var CodeMap = map[ErrorCode]int{
 	Stale:               http.StatusInternalServerError,
 	Unauthenticated:     http.StatusUnauthorized,
 	UnprocessableEntity: http.StatusUnprocessableEntity,
+	Unavailable:         http.StatusServiceUnavailable,
 }
 
 // Resp is the response envelope. All responses from the service will allways be
@@ -90,7 +93,7 @@ func JSONResponse(ctx context.Context, w http.ResponseWriter, res *Resp) {
 	SetJSONContent(w)
 	jr, err := json.Marshal(res)
 	if err != nil {
-		l.Error("JSONResponse can't unmarshal", err)
+		l.Error("JSONResponse can't unmarshal", logging.Err(err))
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
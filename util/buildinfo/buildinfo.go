@@ -0,0 +1,62 @@
+// Package buildinfo reads what the Go toolchain embeds in the binary about the build
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+const unknown = "unknown"
+
+// Info describes the build of the running binary
+type Info struct {
+	// Path is the main module path
+	Path string `json:"path"`
+	// Version is the main module version, (devel) when built from a checkout
+	Version string `json:"version"`
+	// Commit is the VCS revision, Modified is true if there were uncommitted changes
+	Commit    string `json:"commit"`
+	Modified  bool   `json:"modified"`
+	Time      string `json:"time,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build info, the fields the toolchain didn't record are "unknown"
+func Get() Info {
+	info := Info{Path: unknown, Version: unknown, Commit: unknown, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if bi.Main.Path != "" {
+		info.Path = bi.Main.Path
+	}
+	if bi.Main.Version != "" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		case "vcs.time":
+			info.Time = s.Value
+		}
+	}
+	return info
+}
+
+// ServiceVersion is the module version, or the commit if built from a checkout
+func (i Info) ServiceVersion() string {
+	if i.Version != unknown && i.Version != "(devel)" {
+		return i.Version
+	}
+	if i.Commit == unknown {
+		return unknown
+	}
+	if i.Modified {
+		return i.Commit + "-dirty"
+	}
+	return i.Commit
+}
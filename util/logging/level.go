@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// levelHandler overrides the minimum level of the handler it wraps
+type levelHandler struct {
+	next  slog.Handler
+	level slog.Leveler
+}
+
+// forcedKey marks the context of the records logged through a logger from WithLevel
+type forcedKey struct{}
+
+// WithLevel returns a logger logging everything at or above lvl regardless of the level the handlers of l were
+// created with. It's used to turn on debug logging for a single request without touching the global level, so
+// the SampleHandler neither samples nor suppresses its records
+func WithLevel(l *slog.Logger, lvl slog.Leveler) *slog.Logger {
+	h := l.Handler()
+	if lh, ok := h.(*levelHandler); ok {
+		h = lh.next
+	}
+	return slog.New(&levelHandler{next: h, level: lvl})
+}
+
+func (h *levelHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(context.WithValue(ctx, forcedKey{}, true), r)
+}
+
+// forced is true when the record is logged through a logger from WithLevel
+func forced(ctx context.Context) bool {
+	f, _ := ctx.Value(forcedKey{}).(bool)
+	return f
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{next: h.next.WithAttrs(attrs), level: h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{next: h.next.WithGroup(name), level: h.level}
+}
@@ -0,0 +1,200 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// libKey is the attribute key set by Lib
+const libKey = "pkg"
+
+// LevelControl changes the log level while running. The level can be set globally or for the loggers of a
+// single package, ie loggers created with l.With(logging.Lib("name")). Changes can be reverted to the configured
+// level automatically after a while, that way debug logging isn't left on by mistake
+type LevelControl struct {
+	level      slog.LevelVar
+	configured atomic.Int64
+	libs       atomic.Pointer[map[string]slog.Level]
+
+	mut      sync.Mutex
+	timer    *time.Timer
+	revertAt time.Time
+}
+
+// LevelState is the current state of a LevelControl
+type LevelState struct {
+	Level      string            `json:"level"`
+	Configured string            `json:"configured"`
+	Libs       map[string]string `json:"libs,omitempty"`
+	RevertAt   *time.Time        `json:"revertAt,omitempty"`
+}
+
+var levels = NewLevelControl(slog.LevelInfo)
+
+// Levels returns the LevelControl of the default logger
+func Levels() *LevelControl {
+	return levels
+}
+
+// NewLevelControl returns a LevelControl logging at configured and above
+func NewLevelControl(configured slog.Level) *LevelControl {
+	c := &LevelControl{}
+	c.Configure(configured)
+	return c
+}
+
+// Configure sets the level to go back to and resets to it
+func (c *LevelControl) Configure(l slog.Level) {
+	c.configured.Store(int64(l))
+	c.Reset()
+}
+
+// Configured returns the level set with Configure
+func (c *LevelControl) Configured() slog.Level {
+	return slog.Level(c.configured.Load())
+}
+
+// Level returns the current global level, it makes LevelControl a slog.Leveler
+func (c *LevelControl) Level() slog.Level {
+	return c.level.Level()
+}
+
+// Set changes the global level. If revertAfter > 0 all levels go back to the configured level after that
+func (c *LevelControl) Set(l slog.Level, revertAfter time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.level.Set(l)
+	c.scheduleRevert(revertAfter)
+}
+
+// SetLib changes the level of the loggers of lib. If revertAfter > 0 all levels go back to the configured level
+// after that
+func (c *LevelControl) SetLib(lib string, l slog.Level, revertAfter time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	libs := map[string]slog.Level{}
+	if old := c.libs.Load(); old != nil {
+		for k, v := range *old {
+			libs[k] = v
+		}
+	}
+	libs[lib] = l
+	c.libs.Store(&libs)
+	c.scheduleRevert(revertAfter)
+}
+
+// ToggleDebug switches between debug and the configured level, it returns the new level. The check and the change
+// are done under the lock, so concurrent toggles take turns
+func (c *LevelControl) ToggleDebug(revertAfter time.Duration) slog.Level {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.Level() == slog.LevelDebug {
+		c.reset()
+		return c.Level()
+	}
+	c.level.Set(slog.LevelDebug)
+	c.scheduleRevert(revertAfter)
+	return slog.LevelDebug
+}
+
+// Reset goes back to the configured level and removes the package levels
+func (c *LevelControl) Reset() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.reset()
+}
+
+// State returns the current levels
+func (c *LevelControl) State() LevelState {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	s := LevelState{Level: c.Level().String(), Configured: c.Configured().String()}
+	if libs := c.libs.Load(); libs != nil && len(*libs) > 0 {
+		s.Libs = make(map[string]string, len(*libs))
+		for k, v := range *libs {
+			s.Libs[k] = v.String()
+		}
+	}
+	if c.timer != nil {
+		t := c.revertAt
+		s.RevertAt = &t
+	}
+	return s
+}
+
+// Handler returns a handler logging at the levels of c, the level next was created with is ignored
+func (c *LevelControl) Handler(next slog.Handler) slog.Handler {
+	return &controlHandler{next: next, ctl: c}
+}
+
+func (c *LevelControl) reset() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.level.Set(c.Configured())
+	c.libs.Store(nil)
+}
+
+func (c *LevelControl) scheduleRevert(after time.Duration) {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if after <= 0 {
+		return
+	}
+	c.revertAt = time.Now().Add(after)
+	var t *time.Timer
+	t = time.AfterFunc(after, func() {
+		c.mut.Lock()
+		defer c.mut.Unlock()
+		if c.timer == t { // not replaced by a later change
+			c.reset()
+		}
+	})
+	c.timer = t
+}
+
+func (c *LevelControl) enabled(lib string, l slog.Level) bool {
+	if lib != "" {
+		if libs := c.libs.Load(); libs != nil {
+			if lvl, ok := (*libs)[lib]; ok {
+				return l >= lvl
+			}
+		}
+	}
+	return l >= c.level.Level()
+}
+
+// controlHandler decides the level with a LevelControl, it keeps track of the package set with Lib
+type controlHandler struct {
+	next slog.Handler
+	ctl  *LevelControl
+	lib  string
+}
+
+func (h *controlHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return h.ctl.enabled(h.lib, l)
+}
+
+func (h *controlHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *controlHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	lib := h.lib
+	for _, a := range attrs {
+		if a.Key == libKey {
+			lib = a.Value.String()
+		}
+	}
+	return &controlHandler{next: h.next.WithAttrs(attrs), ctl: h.ctl, lib: lib}
+}
+
+func (h *controlHandler) WithGroup(name string) slog.Handler {
+	return &controlHandler{next: h.next.WithGroup(name), ctl: h.ctl, lib: h.lib}
+}
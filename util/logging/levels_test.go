@@ -0,0 +1,77 @@
+package logging_test
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitLevelControl(t *testing.T) {
+	r := require.New(t)
+	var buf bytes.Buffer
+	ctl := logging.NewLevelControl(slog.LevelInfo)
+	l := slog.New(logging.NewRedactHandler(ctl.Handler(slog.NewJSONHandler(&buf, nil)), nil))
+	svc := l.With(logging.Lib("service"))
+
+	l.Debug("hidden")
+	r.Empty(buf.String())
+
+	ctl.Set(slog.LevelDebug, 0)
+	l.Debug("global debug")
+	r.Contains(buf.String(), "global debug")
+
+	// a package level wins over the global one
+	buf.Reset()
+	ctl.SetLib("service", slog.LevelError, 0)
+	svc.Warn("hidden")
+	l.Debug("shown")
+	r.NotContains(buf.String(), "hidden")
+	r.Contains(buf.String(), "shown")
+	r.Equal(map[string]string{"service": "ERROR"}, ctl.State().Libs)
+
+	ctl.Reset()
+	r.Equal(slog.LevelInfo, ctl.Level())
+	r.Empty(ctl.State().Libs)
+
+	// toggling flips between debug and the configured level
+	r.Equal(slog.LevelDebug, ctl.ToggleDebug(0))
+	r.Equal(slog.LevelInfo, ctl.ToggleDebug(0))
+}
+
+func TestUnitLevelControlRevert(t *testing.T) {
+	r := require.New(t)
+	ctl := logging.NewLevelControl(slog.LevelWarn)
+
+	ctl.SetLib("redis", slog.LevelDebug, time.Hour)
+	ctl.Set(slog.LevelDebug, 20*time.Millisecond)
+	r.NotNil(ctl.State().RevertAt)
+
+	// the latest change decides when everything is reverted
+	r.Eventually(func() bool { return ctl.Level() == slog.LevelWarn }, time.Second, 5*time.Millisecond)
+	r.Empty(ctl.State().Libs)
+	r.Nil(ctl.State().RevertAt)
+}
+
+func TestUnitLevelControlConcurrentToggles(t *testing.T) {
+	r := require.New(t)
+	ctl := logging.NewLevelControl(slog.LevelInfo)
+
+	// an odd number of toggles ends in debug, with the revert scheduled
+	var wg sync.WaitGroup
+	for i := 0; i < 101; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctl.ToggleDebug(time.Hour)
+		}()
+	}
+	wg.Wait()
+	r.Equal(slog.LevelDebug, ctl.Level())
+	r.NotNil(ctl.State().RevertAt)
+	ctl.Reset()
+}
@@ -0,0 +1,221 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// templates caches the parsed format strings, libraries only have a limited number of them
+var templates sync.Map
+
+// Printf bridges libraries logging printf style to slog. Instead of formatting the string the format is turned
+// into a stable message and the arguments into attributes:
+//
+//	Printf("Replaying file id: %d at offset: %d took %s", 3, 1024, time.Second)
+//	msg="Replaying file id: {id} at offset: {offset} took {arg2}" id=3 offset=1024 arg2=1s
+//
+// That way the same log line can be grouped and filtered no matter the values. An argument is named after the word
+// before it if that ends with : or =, otherwise argN. It implements the badger Logger, the go-redis internal logger
+// and with StdLogger the log package, used by net/http's ErrorLog
+type Printf struct {
+	l *slog.Logger
+	// level is used by Printf and the log package, which don't have levels
+	level slog.Level
+}
+
+// NewPrintf returns a bridge logging to l, Printf and StdLogger log at level
+func NewPrintf(l *slog.Logger, level slog.Level) *Printf {
+	return &Printf{l: l, level: level}
+}
+
+func (p *Printf) Errorf(f string, v ...any) {
+	p.log(context.Background(), slog.LevelError, f, v...)
+}
+
+func (p *Printf) Warningf(f string, v ...any) {
+	p.log(context.Background(), slog.LevelWarn, f, v...)
+}
+
+func (p *Printf) Infof(f string, v ...any) {
+	p.log(context.Background(), slog.LevelInfo, f, v...)
+}
+
+func (p *Printf) Debugf(f string, v ...any) {
+	p.log(context.Background(), slog.LevelDebug, f, v...)
+}
+
+// Printf logs at the level of p, the signature is the one go-redis uses, redis.SetLogger(p)
+func (p *Printf) Printf(ctx context.Context, f string, v ...any) {
+	p.log(ctx, p.level, f, v...)
+}
+
+// StdHook is called with the record of each line StdLogger logs before it's handled, whatever the level. It can
+// change the level and the message and add attributes, for instance to classify the lines
+type StdHook func(r *slog.Record)
+
+// StdLogger returns a log.Logger writing to p, for instance for http.Server.ErrorLog. The log package formats
+// the lines itself, so they are logged as they are at the level of p, unless hook changes it. hook may be nil
+func (p *Printf) StdLogger(hook StdHook) *log.Logger {
+	return log.New(stdWriter{p: p, hook: hook}, "", 0)
+}
+
+type stdWriter struct {
+	p    *Printf
+	hook StdHook
+}
+
+func (w stdWriter) Write(b []byte) (int, error) {
+	ctx := context.Background()
+	if w.hook == nil && !w.p.l.Enabled(ctx, w.p.level) {
+		return len(b), nil
+	}
+	// the caller of log.Printf, past log.(*Logger).output and Write
+	r := slog.NewRecord(time.Now(), w.p.level, strings.TrimSpace(string(b)), callerPC(5))
+	if w.hook != nil {
+		w.hook(&r)
+		if !w.p.l.Enabled(ctx, r.Level) {
+			return len(b), nil
+		}
+	}
+	return len(b), w.p.l.Handler().Handle(ctx, r)
+}
+
+func (p *Printf) log(ctx context.Context, level slog.Level, f string, v ...any) {
+	if !p.l.Enabled(ctx, level) {
+		return
+	}
+	t := parseTemplate(f)
+	r := slog.NewRecord(time.Now(), level, t.msg, callerPC(4))
+	for i, val := range v {
+		if i < len(t.args) {
+			r.AddAttrs(t.args[i].attr(val))
+		} else {
+			r.AddAttrs(slog.Any("arg"+strconv.Itoa(i), val))
+		}
+	}
+	_ = p.l.Handler().Handle(ctx, r) //nolint:errcheck // the libraries can't do anything about it
+}
+
+// callerPC returns the program counter skip frames up, counting callerPC itself
+func callerPC(skip int) uintptr {
+	var pcs [1]uintptr
+	runtime.Callers(skip, pcs[:])
+	return pcs[0]
+}
+
+type template struct {
+	msg  string
+	args []templateArg
+}
+
+type templateArg struct {
+	key string
+	// verb is the full verb, like %5.2f, used for arguments that aren't kept as they are
+	verb string
+}
+
+// attr keeps numbers, durations, bools and errors as they are so that the handlers can output them typed, anything
+// else is formatted with the verb
+func (a templateArg) attr(v any) slog.Attr {
+	switch val := v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, bool, time.Duration:
+		if a.verb == "%d" || a.verb == "%v" || a.verb == "%s" || a.verb == "%f" || a.verb == "%t" {
+			return slog.Any(a.key, val)
+		}
+	case error:
+		return slog.String(a.key, val.Error())
+	}
+	return slog.String(a.key, fmt.Sprintf(a.verb, v))
+}
+
+func parseTemplate(f string) template {
+	if t, ok := templates.Load(f); ok {
+		return t.(template) //nolint:errcheck // only templates are stored
+	}
+
+	var (
+		t   template
+		msg strings.Builder
+		// used makes the keys unique, the second "id:" becomes argN
+		used = map[string]bool{}
+	)
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			msg.WriteByte(f[i])
+			continue
+		}
+		end := verbEnd(f, i+1)
+		if end == -1 {
+			msg.WriteByte('%')
+			i++
+			continue
+		} else if end < 0 {
+			// the arguments can't be matched to the verbs, they all become argN
+			t = template{msg: f}
+			break
+		}
+		key := argName(msg.String())
+		if key == "" || used[key] {
+			key = "arg" + strconv.Itoa(len(t.args))
+		}
+		used[key] = true
+		t.args = append(t.args, templateArg{key: key, verb: f[i : end+1]})
+		msg.WriteString("{" + key + "}")
+		i = end
+	}
+	if t.msg == "" {
+		t.msg = msg.String()
+	}
+	t.msg = strings.TrimSpace(strings.ReplaceAll(t.msg, "\n", " "))
+
+	templates.Store(f, t)
+	return t
+}
+
+// verbEnd returns the index of the letter of the verb starting at i. It returns -1 for %% and -2 for what
+// can't be parsed: explicit argument indexes and * widths, which would shift the arguments, and broken verbs
+func verbEnd(f string, i int) int {
+	if i < len(f) && f[i] == '%' {
+		return -1
+	}
+	for ; i < len(f); i++ {
+		c := f[i]
+		switch {
+		case strings.IndexByte("+-# .", c) >= 0, c >= '0' && c <= '9':
+		case unicode.IsLetter(rune(c)):
+			return i
+		default:
+			return -2
+		}
+	}
+	return -2
+}
+
+// argName returns the word before a verb if it ends with : or =, like "offset: " or "id="
+func argName(before string) string {
+	before = strings.TrimRight(before, " ")
+	if !strings.HasSuffix(before, ":") && !strings.HasSuffix(before, "=") {
+		return ""
+	}
+	before = before[:len(before)-1]
+	start := strings.LastIndexFunc(before, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	word := before[start+1:]
+	if word == "" || !unicode.IsLetter(rune(word[0])) {
+		return ""
+	}
+	switch word {
+	case slog.TimeKey, slog.LevelKey, slog.MessageKey, slog.SourceKey:
+		return "" // would clash with the built in keys
+	}
+	return word
+}
@@ -0,0 +1,91 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitPrintf(t *testing.T) {
+	r := require.New(t)
+	var buf bytes.Buffer
+	p := logging.NewPrintf(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{AddSource: true})), slog.LevelWarn)
+
+	p.Infof("Replaying file id: %d at offset: %d took %s\n", 3, 1024, 1500*time.Millisecond)
+	recs := records(t, &buf)
+	r.Len(recs, 1)
+	r.Equal("INFO", recs[0]["level"])
+	r.Equal("Replaying file id: {id} at offset: {offset} took {arg2}", recs[0]["msg"])
+	r.EqualValues(3, recs[0]["id"])
+	r.EqualValues(1024, recs[0]["offset"])
+	r.EqualValues(1500*time.Millisecond, recs[0]["arg2"])
+	// the source is the caller of the bridge, not the bridge
+	r.Contains(recs[0]["source"].(map[string]any)["file"], "printf_test.go")
+
+	// formatting verbs are applied, %% is kept and extra arguments are added
+	buf.Reset()
+	p.Errorf("%5.1f%% of %q: %v", 12.345, "disk", errors.New("full"), "extra")
+	recs = records(t, &buf)
+	r.Equal("{arg0}% of {arg1}: {arg2}", recs[0]["msg"])
+	r.Equal(" 12.3", recs[0]["arg0"])
+	r.Equal(`"disk"`, recs[0]["arg1"])
+	r.Equal("full", recs[0]["arg2"])
+	r.Equal("extra", recs[0]["arg3"])
+
+	// arguments that can't be matched to the verbs are kept in order
+	buf.Reset()
+	p.Printf(context.Background(), "%[2]d %[1]d", 1, 2)
+	recs = records(t, &buf)
+	r.Equal("WARN", recs[0]["level"])
+	r.Equal("%[2]d %[1]d", recs[0]["msg"])
+	r.EqualValues(1, recs[0]["arg0"])
+
+	// debug isn't formatted if the logger doesn't log it
+	buf.Reset()
+	p.Debugf("hidden %d", 1)
+	r.Empty(buf.String())
+}
+
+func TestUnitPrintfStdLogger(t *testing.T) {
+	r := require.New(t)
+	var buf bytes.Buffer
+	p := logging.NewPrintf(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{AddSource: true})), slog.LevelWarn)
+
+	p.StdLogger(nil).Printf("http: TLS handshake error from %s: %v", "127.0.0.1:1234", "EOF")
+	recs := records(t, &buf)
+	r.Len(recs, 1)
+	r.Equal("WARN", recs[0]["level"])
+	r.Equal("http: TLS handshake error from 127.0.0.1:1234: EOF", recs[0]["msg"])
+	r.Contains(recs[0]["source"].(map[string]any)["file"], "printf_test.go")
+}
+
+func TestUnitPrintfStdLoggerHook(t *testing.T) {
+	r := require.New(t)
+	var buf bytes.Buffer
+	p := logging.NewPrintf(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{AddSource: true})), slog.LevelWarn)
+	hook := func(rec *slog.Record) {
+		if strings.Contains(rec.Message, "panic") {
+			rec.Level = slog.LevelError
+		} else {
+			rec.Level = slog.LevelDebug
+		}
+		rec.Message = strings.ToUpper(rec.Message)
+		rec.AddAttrs(slog.Bool("hooked", true))
+	}
+
+	p.StdLogger(hook).Print("http: panic serving")
+	p.StdLogger(hook).Print("hidden at debug")
+	recs := records(t, &buf)
+	r.Len(recs, 1, "the hook should decide the level")
+	r.Equal("ERROR", recs[0]["level"])
+	r.Equal("HTTP: PANIC SERVING", recs[0]["msg"])
+	r.Equal(true, recs[0]["hooked"])
+	r.Contains(recs[0]["source"].(map[string]any)["file"], "printf_test.go")
+}
@@ -0,0 +1,182 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// RedactedValue replaces the values of redacted attributes
+const RedactedValue = "[redacted]"
+
+// DefaultRedactKeys are the key patterns redacted if nothing else is configured
+var DefaultRedactKeys = []string{"pass", "secret", "token", "authorization", "cookie", "apikey", "api-key", "api_key"}
+
+// structHasRedact caches if a struct type has any fields tagged with log:"redact", directly or in nested structs
+var structHasRedact sync.Map
+
+// RedactHandler wraps another slog.Handler and masks sensitive values before they reach it:
+//   - attributes where the key contains any of the patterns (case insensitive)
+//   - fields in structs tagged with `log:"redact"`, the struct is then logged as a group
+//   - keys in map[string]any values matching the patterns, for instance viper.AllSettings()
+type RedactHandler struct {
+	next     slog.Handler
+	patterns []string
+}
+
+// NewRedactHandler returns a RedactHandler wrapping next, masking keys containing any of the patterns
+func NewRedactHandler(next slog.Handler, patterns []string) *RedactHandler {
+	p := make([]string, 0, len(patterns))
+	for _, s := range patterns {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			p = append(p, s)
+		}
+	}
+	return &RedactHandler{next: next, patterns: p}
+}
+
+func (h *RedactHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.next.Enabled(ctx, l)
+}
+
+func (h *RedactHandler) Handle(ctx context.Context, r slog.Record) error {
+	nr := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		nr.AddAttrs(h.redact(a))
+		return true
+	})
+	return h.next.Handle(ctx, nr)
+}
+
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	red := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		red = append(red, h.redact(a))
+	}
+	return &RedactHandler{next: h.next.WithAttrs(red), patterns: h.patterns}
+}
+
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{next: h.next.WithGroup(name), patterns: h.patterns}
+}
+
+// Sensitive returns true if the key matches any of the patterns
+func (h *RedactHandler) Sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, p := range h.patterns {
+		if strings.Contains(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *RedactHandler) redact(a slog.Attr) slog.Attr {
+	if h.Sensitive(a.Key) {
+		return slog.String(a.Key, RedactedValue)
+	}
+	return slog.Attr{Key: a.Key, Value: h.redactValue(a.Value.Resolve())}
+}
+
+func (h *RedactHandler) redactValue(v slog.Value) slog.Value {
+	switch v.Kind() {
+	case slog.KindGroup:
+		group := v.Group()
+		red := make([]slog.Attr, 0, len(group))
+		for _, a := range group {
+			red = append(red, h.redact(a))
+		}
+		return slog.GroupValue(red...)
+	case slog.KindAny:
+		switch val := v.Any().(type) {
+		case map[string]any:
+			return slog.AnyValue(h.redactMap(val))
+		default:
+			if sv, ok := redactStruct(val); ok {
+				return h.redactValue(sv)
+			}
+		}
+	}
+	return v
+}
+
+func (h *RedactHandler) redactMap(m map[string]any) map[string]any {
+	res := make(map[string]any, len(m))
+	for k, v := range m {
+		if h.Sensitive(k) {
+			res[k] = RedactedValue
+		} else if sub, ok := v.(map[string]any); ok {
+			res[k] = h.redactMap(sub)
+		} else {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// redactStruct turns a struct with fields tagged `log:"redact"` into a group with those fields masked. False is
+// returned for anything else, so structs without tags are logged as they always have been
+func redactStruct(v any) (slog.Value, bool) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return slog.Value{}, false
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || !hasRedact(rv.Type()) {
+		return slog.Value{}, false
+	}
+
+	t := rv.Type()
+	attrs := make([]slog.Attr, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if j, _, _ := strings.Cut(f.Tag.Get("json"), ","); j == "-" {
+			continue
+		} else if j != "" {
+			name = j
+		}
+
+		if f.Tag.Get("log") == "redact" {
+			attrs = append(attrs, slog.String(name, RedactedValue))
+			continue
+		}
+		fv := rv.Field(i).Interface()
+		if sv, ok := redactStruct(fv); ok {
+			attrs = append(attrs, slog.Attr{Key: name, Value: sv})
+		} else {
+			attrs = append(attrs, slog.Any(name, fv))
+		}
+	}
+	return slog.GroupValue(attrs...), true
+}
+
+func hasRedact(t reflect.Type) bool {
+	if c, ok := structHasRedact.Load(t); ok {
+		return c.(bool) //nolint:errcheck // only bools are stored
+	}
+	// store false first so recursive types don't loop forever
+	structHasRedact.Store(t, false)
+
+	res := false
+	for i := 0; i < t.NumField() && !res; i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		res = f.Tag.Get("log") == "redact" || (ft.Kind() == reflect.Struct && hasRedact(ft))
+	}
+	structHasRedact.Store(t, res)
+	return res
+}
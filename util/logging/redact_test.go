@@ -0,0 +1,79 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/stretchr/testify/require"
+)
+
+type inner struct {
+	Token string `json:"token" log:"redact"`
+	Kind  string `json:"kind"`
+}
+
+type outer struct {
+	Name   string `json:"name"`
+	Email  string `json:"email" log:"redact"`
+	Inner  *inner `json:"inner"`
+	Hidden string `json:"-"`
+}
+
+type plain struct {
+	Name string
+}
+
+func TestUnitRedactHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		log      func(l *slog.Logger)
+		expected map[string]any
+	}{
+		{
+			name:     "key patterns",
+			log:      func(l *slog.Logger) { l.Info("msg", "db-pass", "hunter2", "Authorization", "Bearer x", "user", "bob") },
+			expected: map[string]any{"db-pass": logging.RedactedValue, "Authorization": logging.RedactedValue, "user": "bob"},
+		},
+		{
+			name:     "groups and with",
+			log:      func(l *slog.Logger) { l.With("secretKey", "x").Info("msg", slog.Group("g", "token", "y", "ok", 1)) },
+			expected: map[string]any{"secretKey": logging.RedactedValue, "g": map[string]any{"token": logging.RedactedValue, "ok": float64(1)}},
+		},
+		{
+			name: "struct tags",
+			log: func(l *slog.Logger) {
+				l.Info("msg", "in", &outer{Name: "bob", Email: "bob@example.com", Inner: &inner{Token: "t", Kind: "k"}, Hidden: "h"})
+			},
+			expected: map[string]any{"in": map[string]any{"name": "bob", "email": logging.RedactedValue, "inner": map[string]any{"token": logging.RedactedValue, "kind": "k"}}},
+		},
+		{
+			name:     "struct without tags",
+			log:      func(l *slog.Logger) { l.Info("msg", "in", plain{Name: "bob"}) },
+			expected: map[string]any{"in": map[string]any{"Name": "bob"}},
+		},
+		{
+			name:     "maps",
+			log:      func(l *slog.Logger) { l.Info("msg", "configs", map[string]any{"db-pass": "x", "db-addr": "y"}) },
+			expected: map[string]any{"configs": map[string]any{"db-pass": logging.RedactedValue, "db-addr": "y"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := require.New(t)
+			var buf bytes.Buffer
+			l := slog.New(logging.NewRedactHandler(slog.NewJSONHandler(&buf, nil), logging.DefaultRedactKeys))
+			test.log(l)
+
+			var line map[string]any
+			r.NoError(json.Unmarshal(buf.Bytes(), &line))
+			delete(line, "time")
+			delete(line, "level")
+			delete(line, "msg")
+			r.Equal(test.expected, line)
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDedupKeys are the attributes that make two records with the same message different if nothing else is
+// configured, the package set with Lib and the error set with Err
+var DefaultDedupKeys = []string{libKey, "err"}
+
+// SampleConfig configures a SampleHandler
+type SampleConfig struct {
+	// Window is how long identical records are counted before the count starts over, 0 turns deduplication off
+	Window time.Duration
+	// Burst is how many identical records are logged per window before the rest are suppressed, at least 1
+	Burst int
+	// Keys are the attributes that, together with the level and message, make records identical. Attributes
+	// not listed, like request IDs, are ignored. DefaultDedupKeys if nil
+	Keys []string
+	// DebugRate and InfoRate are the fraction of debug and info records kept, values outside (0, 1) keep all so
+	// the zero value samples nothing. Use the level to drop a level completely
+	DebugRate float64
+	InfoRate  float64
+}
+
+// SampleHandler keeps a flapping dependency from flooding the logs. Identical records, by level, message and the
+// configured keys, are logged Burst times per Window, after that they are counted. The summary with the number
+// suppressed is logged with the first record after the window has ended. Debug and info records can also be
+// sampled randomly, warnings and errors never are. Records of a logger from WithLevel, like a request with debug
+// logging turned on, are always logged. Put it below LevelControl.Handler, that way only records that would be
+// logged are counted
+type SampleHandler struct {
+	next  slog.Handler
+	state *sampleState
+	// fixed are the values of the keys added with WithAttrs, like the package set with Lib
+	fixed []string
+	group string
+}
+
+type sampleState struct {
+	conf SampleConfig
+	keys map[string]bool
+	// root is the handler the summaries are logged with, without the attributes of the loggers
+	root slog.Handler
+
+	mut       sync.Mutex
+	seen      map[string]*sampleEntry
+	lastSweep time.Time
+}
+
+type sampleEntry struct {
+	start      time.Time
+	count      int
+	suppressed int
+	level      slog.Level
+	msg        string
+	attrs      []slog.Attr
+}
+
+// NewSampleHandler returns a SampleHandler wrapping next
+func NewSampleHandler(next slog.Handler, conf SampleConfig) *SampleHandler {
+	if conf.Burst < 1 {
+		conf.Burst = 1
+	}
+	if conf.Keys == nil {
+		conf.Keys = DefaultDedupKeys
+	}
+	keys := make(map[string]bool, len(conf.Keys))
+	for _, k := range conf.Keys {
+		keys[k] = true
+	}
+	return &SampleHandler{
+		next: next,
+		state: &sampleState{
+			conf:      conf,
+			keys:      keys,
+			root:      next,
+			seen:      map[string]*sampleEntry{},
+			lastSweep: time.Now(),
+		},
+	}
+}
+
+func (h *SampleHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.next.Enabled(ctx, l)
+}
+
+func (h *SampleHandler) Handle(ctx context.Context, r slog.Record) error {
+	if forced(ctx) {
+		return h.next.Handle(ctx, r)
+	}
+	if !h.sampled(r.Level) {
+		return nil
+	}
+	if h.state.conf.Window <= 0 {
+		return h.next.Handle(ctx, r)
+	}
+
+	attrs := h.keyAttrs(r)
+	keep, summaries := h.state.count(identity(r, attrs), r, attrs, time.Now())
+	for _, s := range summaries {
+		_ = h.state.root.Handle(ctx, s) //nolint:errcheck // a lost summary shouldn't fail the record
+	}
+	if !keep {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *SampleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fixed := h.fixed
+	for _, a := range attrs {
+		if k := h.group + a.Key; h.state.keys[k] {
+			fixed = append(fixed[:len(fixed):len(fixed)], k+"="+a.Value.String())
+		}
+	}
+	return &SampleHandler{next: h.next.WithAttrs(attrs), state: h.state, fixed: fixed, group: h.group}
+}
+
+func (h *SampleHandler) WithGroup(name string) slog.Handler {
+	return &SampleHandler{next: h.next.WithGroup(name), state: h.state, fixed: h.fixed, group: h.group + name + "."}
+}
+
+// sampled decides if a debug or info record is kept
+func (h *SampleHandler) sampled(l slog.Level) bool {
+	rate := 1.0
+	switch {
+	case l < slog.LevelInfo:
+		rate = h.state.conf.DebugRate
+	case l < slog.LevelWarn:
+		rate = h.state.conf.InfoRate
+	}
+	if rate <= 0 || rate >= 1 {
+		return true
+	}
+	return rand.Float64() < rate //nolint:gosec // sampling doesn't need a secure source
+}
+
+// keyAttrs returns the attributes among the keys, from the logger and the record
+func (h *SampleHandler) keyAttrs(r slog.Record) []slog.Attr {
+	attrs := make([]slog.Attr, 0, len(h.fixed))
+	for _, f := range h.fixed {
+		k, v, _ := strings.Cut(f, "=")
+		attrs = append(attrs, slog.String(k, v))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if k := h.group + a.Key; h.state.keys[k] {
+			attrs = append(attrs, slog.String(k, a.Value.String()))
+		}
+		return true
+	})
+	return attrs
+}
+
+// identity is what makes records identical: level, message and the values of the keys
+func identity(r slog.Record, attrs []slog.Attr) string {
+	var b strings.Builder
+	b.WriteString(r.Level.String())
+	b.WriteByte(0)
+	b.WriteString(r.Message)
+	for _, a := range attrs {
+		b.WriteByte(0)
+		b.WriteString(a.Key + "=" + a.Value.String())
+	}
+	return b.String()
+}
+
+// count records an occurrence of id, it returns if the record should be logged and the summaries of the windows
+// that have ended
+func (s *sampleState) count(id string, r slog.Record, attrs []slog.Attr, now time.Time) (bool, []slog.Record) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	var summaries []slog.Record
+	e, ok := s.seen[id]
+	if ok && now.Sub(e.start) >= s.conf.Window {
+		if e.suppressed > 0 {
+			summaries = append(summaries, s.summary(e, now))
+		}
+		ok = false
+	}
+	if !ok {
+		e = &sampleEntry{start: now, level: r.Level, msg: r.Message, attrs: attrs}
+		s.seen[id] = e
+	}
+	e.count++
+	keep := e.count <= s.conf.Burst
+	if !keep {
+		e.suppressed++
+	}
+
+	// once per window the ended windows are summarised and forgotten, otherwise messages that stopped coming
+	// would never get their summary
+	if now.Sub(s.lastSweep) >= s.conf.Window {
+		s.lastSweep = now
+		for k, old := range s.seen {
+			if old == e || now.Sub(old.start) < s.conf.Window {
+				continue
+			}
+			if old.suppressed > 0 {
+				summaries = append(summaries, s.summary(old, now))
+			}
+			delete(s.seen, k)
+		}
+	}
+	return keep, summaries
+}
+
+func (s *sampleState) summary(e *sampleEntry, now time.Time) slog.Record {
+	r := slog.NewRecord(now, e.level, "Suppressed repeated log messages", 0)
+	r.AddAttrs(slog.String("message", e.msg), slog.Int("suppressed", e.suppressed), slog.Duration("window", s.conf.Window))
+	r.AddAttrs(e.attrs...)
+	return r
+}
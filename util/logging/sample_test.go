@@ -0,0 +1,114 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var res []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		m := map[string]any{}
+		require.NoError(t, json.Unmarshal([]byte(line), &m))
+		res = append(res, m)
+	}
+	return res
+}
+
+func TestUnitSampleDedup(t *testing.T) {
+	r := require.New(t)
+	var buf bytes.Buffer
+	window := 50 * time.Millisecond
+	l := slog.New(logging.NewSampleHandler(slog.NewJSONHandler(&buf, nil), logging.SampleConfig{Window: window, Burst: 2}))
+	svc := l.With(logging.Lib("service"))
+
+	// request IDs differ but aren't among the keys, so the records are identical
+	for i := 0; i < 10; i++ {
+		svc.Error("Failed to increase the global counter", logging.Err(errors.New("connection refused")), slog.Int("requestID", i))
+	}
+	// another error or package isn't the same record
+	svc.Error("Failed to increase the global counter", logging.Err(errors.New("timeout")))
+	l.Error("Failed to increase the global counter", logging.Err(errors.New("connection refused")))
+	r.Len(records(t, &buf), 4)
+
+	// the first record after the window gets the summary before it
+	time.Sleep(window)
+	buf.Reset()
+	svc.Error("Failed to increase the global counter", logging.Err(errors.New("connection refused")))
+	recs := records(t, &buf)
+	r.Len(recs, 2)
+	r.Equal("Suppressed repeated log messages", recs[0]["msg"])
+	r.Equal("Failed to increase the global counter", recs[0]["message"])
+	r.EqualValues(8, recs[0]["suppressed"])
+	r.Equal("service", recs[0]["pkg"])
+	r.Equal("connection refused", recs[0]["err"])
+	r.Equal("ERROR", recs[0]["level"])
+	r.Equal("Failed to increase the global counter", recs[1]["msg"])
+}
+
+func TestUnitSampleSweep(t *testing.T) {
+	r := require.New(t)
+	var buf bytes.Buffer
+	window := 30 * time.Millisecond
+	l := slog.New(logging.NewSampleHandler(slog.NewJSONHandler(&buf, nil), logging.SampleConfig{Window: window, Burst: 1}))
+
+	l.Warn("flapping")
+	l.Warn("flapping")
+	l.Warn("flapping")
+
+	// messages that stopped coming get their summary with any later record
+	time.Sleep(window)
+	buf.Reset()
+	l.Info("something else")
+	recs := records(t, &buf)
+	r.Len(recs, 2)
+	r.Equal("flapping", recs[0]["message"])
+	r.EqualValues(2, recs[0]["suppressed"])
+	r.Equal("something else", recs[1]["msg"])
+}
+
+func TestUnitSampleRates(t *testing.T) {
+	r := require.New(t)
+	var buf bytes.Buffer
+	l := slog.New(logging.NewSampleHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		logging.SampleConfig{DebugRate: 0.1, InfoRate: 0.5}))
+
+	for i := 0; i < 1000; i++ {
+		l.Debug("debug")
+		l.Info("info")
+		l.Warn("warn")
+	}
+	count := map[string]int{}
+	for _, rec := range records(t, &buf) {
+		count[rec["msg"].(string)]++
+	}
+	r.InDelta(100, count["debug"], 50)
+	r.InDelta(500, count["info"], 100)
+	r.Equal(1000, count["warn"])
+}
+
+func TestUnitSampleWithLevel(t *testing.T) {
+	r := require.New(t)
+	var buf bytes.Buffer
+	l := slog.New(logging.NewSampleHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		logging.SampleConfig{Window: time.Minute, Burst: 1, DebugRate: 0.01}))
+	debug := logging.WithLevel(l, slog.LevelDebug)
+
+	// a request with debug logging turned on keeps all its records, neither sampled nor suppressed
+	for i := 0; i < 100; i++ {
+		debug.Debug("debug")
+	}
+	r.Len(records(t, &buf), 100)
+}
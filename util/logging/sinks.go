@@ -0,0 +1,275 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	SinkStdout = "stdout"
+	SinkStderr = "stderr"
+	SinkFile   = "file"
+	SinkSyslog = "syslog"
+
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// SinkConfig describes a log output, see ParseSink
+type SinkConfig struct {
+	// Type is one of the Sink constants
+	Type string
+	// Target is the file path for file and the unix socket for syslog, empty for the local syslog daemon
+	Target string
+	// Format is text or json
+	Format string
+	// Level is the minimum level written to this sink, nil follows the level of the logger
+	Level slog.Leveler
+
+	// MaxSizeMB rotates the file when it grows above it, 0 is lumberjack's default of 100MB
+	MaxSizeMB int
+	// MaxAge removes rotated files older than this, 0 keeps them
+	MaxAge time.Duration
+	// MaxBackups is how many rotated files to keep, 0 keeps all
+	MaxBackups int
+	// RotateEvery rotates the file at this interval regardless of the size, 0 turns it off
+	RotateEvery time.Duration
+	// Compress gzips rotated files
+	Compress bool
+
+	// Tag is the syslog tag, the program name if empty
+	Tag string
+}
+
+// ParseSink parses a sink from <type>[:<target>][,key=value...], for example
+//
+//	stderr,level=warn,format=json
+//	file:/var/log/app.log,format=json,max-size=100,max-age=168h,max-backups=5,rotate-every=24h,compress=true
+//	syslog:/dev/log,level=info,tag=myService
+//
+// format defaults to defFormat. The options start at the first comma followed by a known key=, so a path may contain
+// commas as long as no part after one looks like an option
+func ParseSink(spec, defFormat string) (SinkConfig, error) {
+	parts := strings.Split(spec, ",")
+	first := 1
+	for first < len(parts) && !isSinkOption(parts[first]) {
+		first++
+	}
+	typ, target, _ := strings.Cut(strings.TrimSpace(strings.Join(parts[:first], ",")), ":")
+	conf := SinkConfig{Type: typ, Target: target, Format: defFormat}
+
+	switch typ {
+	case SinkStdout, SinkStderr:
+		if target != "" {
+			return conf, fmt.Errorf("log sink %q: %s takes no target", spec, typ)
+		}
+	case SinkFile:
+		if target == "" {
+			return conf, fmt.Errorf("log sink %q: file needs a path", spec)
+		}
+	case SinkSyslog:
+	default:
+		return conf, fmt.Errorf("log sink %q: unknown type %q", spec, typ)
+	}
+
+	for _, p := range parts[first:] {
+		k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok {
+			return conf, fmt.Errorf("log sink %q: %q isn't key=value", spec, p)
+		}
+		if err := conf.set(k, v); err != nil {
+			return conf, fmt.Errorf("log sink %q: %w", spec, err)
+		}
+	}
+	return conf, nil
+}
+
+// sinkOptions are the keys set understands
+var sinkOptions = []string{"level", "format", "max-size", "max-age", "max-backups", "rotate-every", "compress", "tag"}
+
+func isSinkOption(p string) bool {
+	k, _, ok := strings.Cut(strings.TrimSpace(p), "=")
+	return ok && slices.Contains(sinkOptions, k)
+}
+
+func (c *SinkConfig) set(k, v string) error {
+	var err error
+	switch k {
+	case "level":
+		var l slog.Level
+		err = l.UnmarshalText([]byte(v))
+		c.Level = l
+	case "format":
+		if v != FormatText && v != FormatJSON {
+			err = fmt.Errorf("unknown format %q", v)
+		}
+		c.Format = v
+	case "max-size":
+		c.MaxSizeMB, err = strconv.Atoi(v)
+	case "max-age":
+		c.MaxAge, err = time.ParseDuration(v)
+	case "max-backups":
+		c.MaxBackups, err = strconv.Atoi(v)
+	case "rotate-every":
+		c.RotateEvery, err = time.ParseDuration(v)
+	case "compress":
+		c.Compress, err = strconv.ParseBool(v)
+	case "tag":
+		c.Tag = v
+	default:
+		err = fmt.Errorf("unknown option %q", k)
+	}
+	return err
+}
+
+// Open creates the handler writing to the sink, the closer closes the file or syslog connection
+func (c SinkConfig) Open() (Sink, io.Closer, error) {
+	var (
+		w      io.Writer
+		closer io.Closer = nopCloser{}
+	)
+	switch c.Type {
+	case SinkStdout:
+		w = os.Stdout
+	case SinkStderr:
+		w = os.Stderr
+	case SinkFile:
+		lj := &lumberjack.Logger{
+			Filename:   c.Target,
+			MaxSize:    c.MaxSizeMB,
+			MaxAge:     int(math.Ceil(c.MaxAge.Hours() / 24)),
+			MaxBackups: c.MaxBackups,
+			Compress:   c.Compress,
+		}
+		w, closer = lj, rotateEvery(lj, c.RotateEvery)
+	case SinkSyslog:
+		sw, err := dialSyslog(c.Target, c.Tag)
+		if err != nil {
+			return Sink{}, nil, err
+		}
+		// syslog adds its own timestamp
+		h := newSyslogHandler(sw, c.newHandler(sw, true))
+		return Sink{Handler: h, Level: c.Level}, sw, nil
+	default:
+		return Sink{}, nil, fmt.Errorf("unknown log sink type %q", c.Type)
+	}
+	return Sink{Handler: c.newHandler(w, false), Level: c.Level}, closer, nil
+}
+
+func (c SinkConfig) newHandler(w io.Writer, noTime bool) slog.Handler {
+	opts := &slog.HandlerOptions{AddSource: true}
+	if noTime {
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		}
+	}
+	if c.Format == FormatJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+type rotator struct {
+	lj   *lumberjack.Logger
+	done chan struct{}
+}
+
+// rotateEvery rotates the file at every interval, lumberjack only rotates on size
+func rotateEvery(lj *lumberjack.Logger, interval time.Duration) io.Closer {
+	r := &rotator{lj: lj, done: make(chan struct{})}
+	if interval <= 0 {
+		return r
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := lj.Rotate(); err != nil {
+					fmt.Fprintln(os.Stderr, "Failed to rotate log file:", err)
+				}
+			case <-r.done:
+				return
+			}
+		}
+	}()
+	return r
+}
+
+func (r *rotator) Close() error {
+	close(r.done)
+	return r.lj.Close()
+}
+
+// Sink is a handler in a Fanout
+type Sink struct {
+	Handler slog.Handler
+	// Level is the minimum level handled, nil lets everything the logger is enabled for through
+	Level slog.Leveler
+}
+
+// Fanout sends each record to all of its sinks that accept the level
+type Fanout struct {
+	sinks []Sink
+}
+
+// NewFanout returns a handler writing to all sinks. Put it below LevelControl.Handler, that way the levels
+// of the sinks are floors on top of the level of the logger, and a sink without level gets everything
+// the logger logs, also debug logging for a single request
+func NewFanout(sinks ...Sink) *Fanout {
+	return &Fanout{sinks: sinks}
+}
+
+func (f *Fanout) Enabled(_ context.Context, l slog.Level) bool {
+	for _, s := range f.sinks {
+		if s.Level == nil || l >= s.Level.Level() {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fanout) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, s := range f.sinks {
+		if s.Level == nil || r.Level >= s.Level.Level() {
+			err = errors.Join(err, s.Handler.Handle(ctx, r.Clone()))
+		}
+	}
+	return err
+}
+
+func (f *Fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	sinks := make([]Sink, len(f.sinks))
+	for i, s := range f.sinks {
+		sinks[i] = Sink{Handler: s.Handler.WithAttrs(attrs), Level: s.Level}
+	}
+	return &Fanout{sinks: sinks}
+}
+
+func (f *Fanout) WithGroup(name string) slog.Handler {
+	sinks := make([]Sink, len(f.sinks))
+	for i, s := range f.sinks {
+		sinks[i] = Sink{Handler: s.Handler.WithGroup(name), Level: s.Level}
+	}
+	return &Fanout{sinks: sinks}
+}
@@ -0,0 +1,70 @@
+package logging_test
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitParseSink(t *testing.T) {
+	r := require.New(t)
+
+	sc, err := logging.ParseSink("stderr,level=warn", logging.FormatText)
+	r.NoError(err)
+	r.Equal(logging.SinkConfig{Type: logging.SinkStderr, Format: logging.FormatText, Level: slog.LevelWarn}, sc)
+
+	sc, err = logging.ParseSink("file:/var/log/app.log,format=json,max-size=10,max-age=48h,max-backups=3,rotate-every=24h,compress=true", logging.FormatText)
+	r.NoError(err)
+	r.Equal(logging.SinkConfig{
+		Type: logging.SinkFile, Target: "/var/log/app.log", Format: logging.FormatJSON,
+		MaxSizeMB: 10, MaxAge: 48 * time.Hour, MaxBackups: 3, RotateEvery: 24 * time.Hour, Compress: true,
+	}, sc)
+
+	// a comma only starts the options when an option follows
+	sc, err = logging.ParseSink("file:/var/log/a,b.log,level=error", logging.FormatText)
+	r.NoError(err)
+	r.Equal(logging.SinkConfig{Type: logging.SinkFile, Target: "/var/log/a,b.log", Format: logging.FormatText, Level: slog.LevelError}, sc)
+
+	for _, bad := range []string{"", "kafka", "stdout:/tmp/x", "file", "stdout,level=loud", "stdout,format=xml", "stdout,level", "file:/x,max-size=big", "stdout,colour=red"} {
+		_, err := logging.ParseSink(bad, logging.FormatText)
+		r.Error(err, bad)
+	}
+}
+
+func TestUnitFanoutFileSink(t *testing.T) {
+	r := require.New(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+
+	sc, err := logging.ParseSink("file:"+path+",format=json,level=warn,rotate-every=50ms", logging.FormatText)
+	r.NoError(err)
+	file, closer, err := sc.Open()
+	r.NoError(err)
+	defer closer.Close()
+
+	var buf bytes.Buffer
+	ctl := logging.NewLevelControl(slog.LevelInfo)
+	l := slog.New(ctl.Handler(logging.NewFanout(logging.Sink{Handler: slog.NewTextHandler(&buf, nil)}, file)))
+
+	l.Info("to the console only")
+	l.Warn("to both")
+	r.Contains(buf.String(), "to the console only")
+	r.Contains(buf.String(), "to both")
+
+	content, err := os.ReadFile(path)
+	r.NoError(err)
+	r.NotContains(string(content), "to the console only")
+	r.Contains(string(content), `"msg":"to both"`)
+
+	// the file is rotated on time as well as on size
+	r.Eventually(func() bool {
+		entries, err := os.ReadDir(dir)
+		return err == nil && len(entries) > 1
+	}, 2*time.Second, 10*time.Millisecond)
+}
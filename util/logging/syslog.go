@@ -0,0 +1,83 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"log/syslog"
+	"sync"
+)
+
+// syslogWriter sends each write to syslog with the severity of the record being handled
+type syslogWriter struct {
+	mut   sync.Mutex
+	w     *syslog.Writer
+	level slog.Level
+}
+
+// dialSyslog connects to the syslog daemon at the unix socket path, or the local one if path is empty
+func dialSyslog(path, tag string) (*syslogWriter, error) {
+	var (
+		w   *syslog.Writer
+		err error
+	)
+	if path == "" {
+		w, err = syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	} else {
+		w, err = syslog.Dial("unixgram", path, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &syslogWriter{w: w}, nil
+}
+
+func (s *syslogWriter) Write(p []byte) (int, error) {
+	msg := string(p)
+	var err error
+	switch {
+	case s.level >= slog.LevelError:
+		err = s.w.Err(msg)
+	case s.level >= slog.LevelWarn:
+		err = s.w.Warning(msg)
+	case s.level >= slog.LevelInfo:
+		err = s.w.Info(msg)
+	default:
+		err = s.w.Debug(msg)
+	}
+	return len(p), err
+}
+
+func (s *syslogWriter) Close() error {
+	return s.w.Close()
+}
+
+// syslogHandler tells the writer the level of the record before the formatting handler writes it
+type syslogHandler struct {
+	w    *syslogWriter
+	next slog.Handler
+}
+
+func newSyslogHandler(w *syslogWriter, next slog.Handler) slog.Handler {
+	return &syslogHandler{w: w, next: next}
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.next.Enabled(ctx, l)
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.w.mut.Lock()
+	defer h.w.mut.Unlock()
+	h.w.level = r.Level
+	return h.next.Handle(ctx, r)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{w: h.w, next: h.next.WithAttrs(attrs)}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{w: h.w, next: h.next.WithGroup(name)}
+}
@@ -0,0 +1,21 @@
+//go:build windows || plan9
+
+package logging
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+)
+
+type syslogWriter struct {
+	io.WriteCloser
+}
+
+func dialSyslog(_, _ string) (*syslogWriter, error) {
+	return nil, errors.New("syslog isn't supported on this platform")
+}
+
+func newSyslogHandler(_ *syslogWriter, next slog.Handler) slog.Handler {
+	return next
+}
@@ -0,0 +1,41 @@
+//go:build !windows && !plan9
+
+package logging_test
+
+import (
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jonmol/http-skeleton/util/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitSyslogSink(t *testing.T) {
+	r := require.New(t)
+	sock := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	r.NoError(err)
+	defer conn.Close()
+
+	sc, err := logging.ParseSink("syslog:"+sock+",tag=test", logging.FormatText)
+	r.NoError(err)
+	sink, closer, err := sc.Open()
+	r.NoError(err)
+	defer closer.Close()
+
+	slog.New(logging.NewFanout(sink)).Error("boom", "key", "value")
+
+	buf := make([]byte, 2048)
+	r.NoError(conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	r.NoError(err)
+	msg := string(buf[:n])
+	// priority 27 is daemon.err
+	r.Contains(msg, "<27>")
+	r.Contains(msg, "test[")
+	r.Contains(msg, "msg=boom key=value")
+	r.NotContains(msg, "time=")
+}
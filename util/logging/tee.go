@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// TeeHandler sends each record to a primary handler and copies it to the others. Only the primary decides the level,
+// that way the copies log the same as the primary, also when the level is overridden by WithLevel
+type TeeHandler struct {
+	primary slog.Handler
+	copies  []slog.Handler
+}
+
+// NewTeeHandler returns a handler writing to primary and copies, for example the console and an OTEL exporter
+func NewTeeHandler(primary slog.Handler, copies ...slog.Handler) *TeeHandler {
+	return &TeeHandler{primary: primary, copies: copies}
+}
+
+func (h *TeeHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.primary.Enabled(ctx, l)
+}
+
+func (h *TeeHandler) Handle(ctx context.Context, r slog.Record) error {
+	err := h.primary.Handle(ctx, r)
+	for _, c := range h.copies {
+		err = errors.Join(err, c.Handle(ctx, r.Clone()))
+	}
+	return err
+}
+
+func (h *TeeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	copies := make([]slog.Handler, len(h.copies))
+	for i, c := range h.copies {
+		copies[i] = c.WithAttrs(attrs)
+	}
+	return &TeeHandler{primary: h.primary.WithAttrs(attrs), copies: copies}
+}
+
+func (h *TeeHandler) WithGroup(name string) slog.Handler {
+	copies := make([]slog.Handler, len(h.copies))
+	for i, c := range h.copies {
+		copies[i] = c.WithGroup(name)
+	}
+	return &TeeHandler{primary: h.primary.WithGroup(name), copies: copies}
+}